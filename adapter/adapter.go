@@ -5,129 +5,401 @@ import (
 	"fmt"
 	"grid-crypto-real/api"
 	"grid-crypto-real/config"
-	"math"
+	"grid-crypto-real/decimal"
+	"grid-crypto-real/exchange"
+	"grid-crypto-real/history"
+	"grid-crypto-real/ledger"
+	"grid-crypto-real/ordertag"
+	"grid-crypto-real/pnl"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
 )
 
 //注文の構造体です。注文時に使用します
 type Order struct {
-	Price  float64 `json:"price"`
-	Limit  float64 `json:"limit"`
-	Amount float64 `json:"amount"`
-	UseJpy float64 `json:"useJpy"`
+	Price  decimal.Decimal `json:"price"`
+	Limit  decimal.Decimal `json:"limit"`
+	Amount decimal.Decimal `json:"amount"`
+	UseJpy decimal.Decimal `json:"useJpy"`
+	Level  int             `json:"level"` //グリッドの段(0が最初のポジション)
 }
 
-//もろもろ情報
-var latestAccountInfo *api.AccountInfo
-var latestActiveOrderInfo *api.ActiveOrder
-var latestTradeHistory *api.TradeHistory
-var latestBoard *api.Board
+//注文のコメントに埋め込む戦略のバージョンです。注文の決め方を変えた場合に上げてください
+const StrategyVersion = 1
+
+//ポジションが無い場合の成行買いに付ける利確価格です(実質利確しません)
+var marketOrderLimit = decimal.NewFromInt(50000000)
+
+var one = decimal.NewFromInt(1)
+
+//注文の判断に使うため保持する直近の約定の件数です
+const recentTradeCount = 20
+
+//新しい約定を1回で取得する件数です
+const newTradePageSize = 100
+
+//1つの通貨ペアを担当するボットです
+//取引所、設定、最後に取得した情報と時計を保持するため、複数のボットを同時に動かすことができます
+type Bot struct {
+	id       string
+	ex       exchange.Exchange
+	pair     *config.PairConfig
+	now      func() time.Time
+	snapshot *Snapshot
+	ledger   *ledger.Ledger
+	history  *history.Store
+	//約定履歴を同期する間隔と前回同期した時刻です
+	historyInterval time.Duration
+	historySyncedAt time.Time
+	lossCut         lossCutState
+	//取得済みの最後の約定IDです。次の周回はこの次から取得します
+	lastTradeID int
+	//ログの出力先です
+	out io.Writer
+}
 
-//口座情報/未約定注文/取引履歴/板情報を取得し最新化します。成功した場合はtrueを返します。
-func UpdateAllInfo() (bool, error) {
-	latestAccountInfo = &api.AccountInfo{}
-	latestActiveOrderInfo = &api.ActiveOrder{}
-	latestTradeHistory = &api.TradeHistory{}
-	latestBoard = &api.Board{}
+//ある時点で取得した口座と市場の情報です
+type Snapshot struct {
+	Balance      *exchange.Balance
+	ActiveOrders []exchange.Order
+	TradeHistory []exchange.Trade
+	Board        *exchange.Board
+	FetchedAt    time.Time
+	committed    committed //取得後にこの周回で発注した分
+}
 
-	ai, errAI := api.GetAccountInfo()
-	if errAI != nil {
-		return false, errAI
+//取引所と通貨ペアの設定を指定してボットを作成します
+func NewBot(ex exchange.Exchange, pair *config.PairConfig) *Bot {
+	return &Bot{
+		id:       config.BotID,
+		ex:       ex,
+		pair:     pair,
+		now:      time.Now,
+		snapshot: &Snapshot{Balance: &exchange.Balance{}, Board: &exchange.Board{}},
+		out:      os.Stdout,
 	}
+}
 
-	if ai.Success != 1 {
-		return false, errors.New(ai.Error)
+//注文のコメントに埋め込むボットIDを設定します。同じ口座で複数のボットを動かす場合に使用します
+func (b *Bot) SetID(id string) {
+	b.id = id
+}
+
+//時刻の取得元を差し替えます。バックテストで使用します
+func (b *Bot) SetClock(now func() time.Time) {
+	b.now = now
+}
+
+//ログの出力先を差し替えます。既定は標準出力で、nilの場合は出力しません
+func (b *Bot) SetOutput(w io.Writer) {
+	if w == nil {
+		w = ioutil.Discard
 	}
-	latestAccountInfo = ai
+	b.out = w
+}
 
-	ao, errAO := api.GetActiveOrder()
-	if errAO != nil {
-		return false, errAO
+//注文と約定を記録する台帳を設定します。設定しない場合は記録しません
+func (b *Bot) SetLedger(l *ledger.Ledger) {
+	b.ledger = l
+}
+
+//設定されている台帳を返却します
+func (b *Bot) Ledger() *ledger.Ledger {
+	return b.ledger
+}
+
+//約定履歴を保存するストアを設定します。UpdateAllInfoは毎回、SyncHistoryはinterval以上の間隔を空けて同期します
+//設定した場合、台帳にはストアに同期した約定を反映します
+func (b *Bot) SetHistory(store *history.Store, interval time.Duration) {
+	b.history = store
+	b.historyInterval = interval
+}
+
+//約定履歴をストアに同期し、追加した約定を台帳に反映して件数を返却します
+//ストアが設定されていない場合や、前回の同期から間隔が経過していない場合は何もしません
+//UpdateAllInfoも毎周回ストアを同期するため、周回の合間に情報を取得しないコマンドで使用します
+func (b *Bot) SyncHistory() (int, error) {
+	if b.history == nil || b.now().Sub(b.historySyncedAt) < b.historyInterval {
+		return 0, nil
+	}
+	pager, ok := b.ex.(exchange.TradeHistoryPager)
+	if !ok {
+		return 0, nil
+	}
+	pair := b.pair.CurrencyPair
+	lastID, err := b.history.LastID(pair)
+	if err != nil {
+		return 0, err
 	}
-	if ao.Success != 1 {
-		return false, errors.New(ao.Error)
+	added, err := b.history.Sync(pager, pair)
+	if err != nil {
+		return added, err
+	}
+	b.historySyncedAt = b.now()
+	if added == 0 || b.ledger == nil {
+		return added, nil
 	}
-	latestActiveOrderInfo = ao
+	trades, err := b.history.Query(pair, history.Filter{AfterID: lastID})
+	if err != nil {
+		return added, err
+	}
+	reverseTrades(trades)
+	return added, b.ledger.ApplyTrades(trades)
+}
+
+//前回の同期からの間隔に関係なく約定履歴をストアに同期します。終了する前に使用します
+func (b *Bot) SyncHistoryNow() (int, error) {
+	b.historySyncedAt = time.Time{}
+	return b.SyncHistory()
+}
+
+//担当する通貨ペアの設定を返却します
+func (b *Bot) Pair() *config.PairConfig {
+	return b.pair
+}
 
-	th, errTH := api.GetTradeHistory()
-	if errTH != nil {
-		return false, errTH
+//最後に取得した情報を返却します
+func (b *Bot) Snapshot() *Snapshot {
+	return b.snapshot
+}
+
+//取得済みの情報を差し替えます。取引所に問い合わせずに注文ロジックを確認する場合に使用します
+func (b *Bot) SetSnapshot(s *Snapshot) {
+	b.snapshot = s
+}
+
+//基軸通貨(btc_jpyのbtc)を返却します
+func (b *Bot) baseCurrency() string {
+	base, _ := exchange.SplitPair(b.pair.CurrencyPair)
+	return base
+}
+
+//決済通貨(btc_jpyのjpy)を返却します
+func (b *Bot) quoteCurrency() string {
+	_, quote := exchange.SplitPair(b.pair.CurrencyPair)
+	return quote
+}
+
+//口座情報/未約定注文/取引履歴/板情報を取得し最新化します。成功した場合はtrueを返します。
+//全て取得できた場合のみ差し替えるため、失敗した場合は前回の情報が残ります
+func (b *Bot) UpdateAllInfo() (bool, error) {
+	balance, errBalance := b.ex.GetBalance()
+	if errBalance != nil {
+		return false, errBalance
 	}
-	if th.Success != 1 {
-		return false, errors.New(th.Error)
+
+	orders, errOrders := b.ex.GetActiveOrders(b.pair.CurrencyPair)
+	if errOrders != nil {
+		return false, errOrders
+	}
+
+	trades, errTrades := b.fetchNewTrades()
+	if errTrades != nil {
+		return false, errTrades
 	}
-	latestTradeHistory = th
 
-	b, errB := api.GetBoard()
-	if errB != nil {
-		return false, errB
+	board, errBoard := b.ex.GetBoard(b.pair.CurrencyPair)
+	if errBoard != nil {
+		return false, errBoard
 	}
-	latestBoard = b
 
+	recent := mergeRecentTrades(trades, b.snapshot.TradeHistory)
+	if len(recent) > recentTradeCount {
+		recent = recent[:recentTradeCount]
+	}
+	b.snapshot = &Snapshot{
+		Balance:      balance,
+		ActiveOrders: orders,
+		TradeHistory: recent,
+		Board:        board,
+		FetchedAt:    b.now(),
+	}
+	if len(trades) > 0 && trades[0].ID > b.lastTradeID {
+		b.lastTradeID = trades[0].ID
+	}
+	if b.ledger != nil {
+		if err := b.ledger.ApplyTrades(trades); err != nil {
+			fmt.Fprintln(b.out, "台帳の更新に失敗しました", err)
+		}
+	}
 	return true, nil
 }
 
-//保有資産をログに出力します
-func PrintDeposit() {
-	fmt.Println("----保有資産-----")
-	fmt.Printf("btc:%f(1BTC=%f)\n", latestAccountInfo.Return.Deposit.Btc)
-	fmt.Printf("jpy:%f\n", latestAccountInfo.Return.Deposit.Jpy)
-	fmt.Printf("総資産:%f\n", (latestBoard.Asks[0][0]*latestAccountInfo.Return.Deposit.Btc)+latestAccountInfo.Return.Deposit.Jpy)
-	fmt.Printf("pos:%d\n", GetPositionNum())
-	fmt.Println("-------------\n")
+//前回取得した約定の次から最新までを新しい順で返却します
+//古い順に最後まで取得するため、周回の間に何件約定しても台帳に漏れなく反映できます
+//起点は台帳に反映済みの最後の約定です。台帳が無い場合は最初の呼び出しで直近の約定を取得し、その次からとします
+//条件付きの取得に対応していない取引所の場合は、取引所が返す約定履歴をそのまま返却します
+func (b *Bot) fetchNewTrades() ([]exchange.Trade, error) {
+	pair := b.pair.CurrencyPair
+	pager, ok := b.ex.(exchange.TradeHistoryPager)
+	if !ok {
+		return b.ex.GetTradeHistory(pair)
+	}
+	fromID := b.lastTradeID
+	if b.ledger != nil && b.ledger.LastTradeID(pair) > fromID {
+		fromID = b.ledger.LastTradeID(pair)
+	}
+	if b.history != nil {
+		return b.fetchNewTradesFromStore(pager, fromID)
+	}
+	ret := []exchange.Trade{}
+	if len(b.snapshot.TradeHistory) == 0 {
+		//注文の判断に使う直近の約定です。既に反映済みの約定は台帳が無視します
+		recent, err := pager.QueryTradeHistory(pair, exchange.TradeQuery{Count: recentTradeCount})
+		if err != nil {
+			return nil, err
+		}
+		if len(recent) > 0 && b.ledger == nil && recent[0].ID > fromID {
+			fromID = recent[0].ID
+		}
+		ret = recent
+	}
+	added := []exchange.Trade{}
+	for {
+		page, err := pager.QueryTradeHistory(pair, exchange.TradeQuery{FromID: fromID + 1, Count: newTradePageSize, Ascending: true})
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range page {
+			if t.ID > fromID {
+				added = append(added, t)
+				fromID = t.ID
+			}
+		}
+		if len(page) < newTradePageSize {
+			break
+		}
+	}
+	reverseTrades(added)
+	return mergeRecentTrades(added, ret), nil
+}
+
+//約定履歴のストアを同期し、ストアにあるfromIDより後の約定を新しい順で返却します
+//台帳とストアが同じ約定を元にするように、ストアが設定されている場合は取引所から直接は取得しません
+func (b *Bot) fetchNewTradesFromStore(pager exchange.TradeHistoryPager, fromID int) ([]exchange.Trade, error) {
+	pair := b.pair.CurrencyPair
+	if _, err := b.history.Sync(pager, pair); err != nil {
+		return nil, err
+	}
+	b.historySyncedAt = b.now()
+	//直近の約定も必要なため、最初はストアの全ての約定を読み込みます。反映済みの約定は台帳が無視します
+	if len(b.snapshot.TradeHistory) == 0 {
+		fromID = 0
+	}
+	trades, err := b.history.Query(pair, history.Filter{AfterID: fromID})
+	if err != nil {
+		return nil, err
+	}
+	reverseTrades(trades)
+	return trades, nil
+}
+
+//古い順の約定を新しい順に並べ替えます
+func reverseTrades(trades []exchange.Trade) {
+	for i, j := 0, len(trades)-1; i < j; i, j = i+1, j-1 {
+		trades[i], trades[j] = trades[j], trades[i]
+	}
+}
+
+//新しい約定と前回までの約定を、重複を除いて新しい順に並べます
+func mergeRecentTrades(added []exchange.Trade, prev []exchange.Trade) []exchange.Trade {
+	ret := make([]exchange.Trade, 0, len(added)+len(prev))
+	seen := map[int]bool{}
+	for _, trades := range [][]exchange.Trade{added, prev} {
+		for _, t := range trades {
+			if !seen[t.ID] {
+				seen[t.ID] = true
+				ret = append(ret, t)
+			}
+		}
+	}
+	sort.SliceStable(ret, func(i, j int) bool { return ret[i].ID > ret[j].ID })
+	return ret
+}
+
+//保有資産をログに出力します。総資産は売却できる価格(最良買気配)で評価します
+func (b *Bot) PrintDeposit() {
+	base := b.baseCurrency()
+	quote := b.quoteCurrency()
+	deposit := b.snapshot.Balance.Deposit
+	bid := b.snapshot.Board.Bids[0].Price
+	fmt.Fprintln(b.out, "----保有資産("+b.pair.CurrencyPair+")-----")
+	fmt.Fprintf(b.out, "%s:%f(1%s=%f%s)\n", base, deposit[base], base, bid, quote)
+	fmt.Fprintf(b.out, "%s:%f\n", quote, deposit[quote])
+	fmt.Fprintf(b.out, "総資産:%f%s\n", bid.Mul(deposit[base]).Add(deposit[quote]), quote)
+	fmt.Fprintf(b.out, "pos:%d\n", b.GetPositionNum())
+	if b.ledger != nil {
+		summary := b.ledger.Summary(b.pair.CurrencyPair)
+		unrealized := pnl.Unrealized(b.ledger.OpenLots(b.pair.CurrencyPair), bid)
+		fmt.Fprintf(b.out, "台帳 保有ロット:%d 保有数量:%f\n", summary.OpenLots, summary.Held)
+		fmt.Fprintf(b.out, "実現損益:%.2f 含み損益:%.2f 手数料:%.2f ボーナス:%.2f\n", summary.RealizedProfit, unrealized, summary.Fees, summary.Bonus)
+		if summary.LossCutLots > 0 {
+			fmt.Fprintf(b.out, "損切り ロット:%d 損益:%.2f\n", summary.LossCutLots, summary.LossCutProfit)
+		}
+	}
+	fmt.Fprintln(b.out, "-------------")
 }
 
 //取引履歴をログに出力します
-func PrintTradeInfo() {
-	fmt.Println("----取引履歴-----")
-	api.PrettyPrint(latestTradeHistory)
-	fmt.Println("-----------------")
+func (b *Bot) PrintTradeInfo() {
+	fmt.Fprintln(b.out, "----取引履歴-----")
+	api.FprettyPrint(b.out, b.snapshot.TradeHistory)
+	fmt.Fprintln(b.out, "-----------------")
 }
 
 //未約定注文をログに出力します
-func PrintOrderInfo() {
-	fmt.Println("----未約定注文-----")
-	api.PrettyPrint(latestActiveOrderInfo)
-	fmt.Println("-------------------")
+func (b *Bot) PrintOrderInfo() {
+	fmt.Fprintln(b.out, "----未約定注文-----")
+	api.FprettyPrint(b.out, b.snapshot.ActiveOrders)
+	fmt.Fprintln(b.out, "-------------------")
 }
 
 //取引履歴と設定を元に適切な注文を作成します。
 //ポジションが無い場合は成行買い、
 //ポジションが存在する場合は最後に約定した価格から、下のグリッド価格を算出し注文structを作成します
-func GetOrderFromLastTradePriceAndConfig() []*Order {
-	positionNum := GetPositionNum()
-	remainJpy := GetRemainJpy()
-	remainPosition := config.MaxPositionCount - positionNum
-	useJpy := remainJpy / float64(remainPosition)
-	price := 0.0
+func (b *Bot) GetOrderFromLastTradePriceAndConfig() []*Order {
+	positionNum := b.GetPositionNum()
+	remainJpy := b.GetRemainQuote()
+	remainPosition := b.pair.MaxPositionCount - positionNum
+	if remainPosition <= 0 {
+		return []*Order{}
+	}
+	useJpy := remainJpy.Div(decimal.NewFromInt(int64(remainPosition)))
+	buyRatio := one.Sub(decimal.NewFromFloat(b.pair.BuyRange))
+	takeProfitRatio := one.Add(decimal.NewFromFloat(b.pair.TakeProfitRange))
+	price := decimal.Zero
 
-	if GetLastPrice() == 0 || GetPositionNum() == 0 {
-		return []*Order{GetMarketPriceOrder(useJpy, 50000000)}
+	if b.GetLastPrice().IsZero() || b.GetPositionNum() == 0 {
+		return []*Order{b.GetMarketPriceOrder(useJpy, marketOrderLimit)}
 	}
 
-	if isLastTradeLong() {
-		price = GetLastPrice() * (1 - config.BuyRange)
+	if b.isLastTradeLong() {
+		price = b.GetLastPrice().Mul(buyRatio)
 	} else {
-		price = GetLastPrice() / (1 + config.TakeProfitRange)
+		price = b.GetLastPrice().Div(takeProfitRatio)
 	}
 
 	//ポジション数が1の時は現時点価格からレンジ下げた価格を購入価格とする
-	if GetPositionNum() == 1 {
-		price = latestBoard.Bids[0][0] * (1 - config.BuyRange)
+	if b.GetPositionNum() == 1 {
+		price = b.snapshot.Board.Bids[0].Price.Mul(buyRatio)
 	}
-	buyMaxNum := config.MaxPositionCount - GetPositionNum()
-	if buyMaxNum >= config.MaxOrderCount {
-		buyMaxNum = config.MaxOrderCount
+	buyMaxNum := b.pair.MaxPositionCount - b.GetPositionNum()
+	if buyMaxNum >= b.pair.MaxOrderCount {
+		buyMaxNum = b.pair.MaxOrderCount
 	}
 	retArray := []*Order{}
 	for i := 0; i < buyMaxNum; i++ {
-		price = price * math.Pow((1-config.BuyRange), float64(i))
-		limit := price * (1 + config.TakeProfitRange)
-		//TODO:コメントに指値額をいれてそれをGetLastPrice()としてあつかう
+		price = price.Mul(buyRatio.Pow(i))
+		limit := price.Mul(takeProfitRatio)
 		retOrder := &Order{
 			price,
 			limit,
-			useJpy / price,
+			useJpy.Div(price),
 			useJpy,
+			positionNum + i,
 		}
 		retArray = append(retArray, retOrder)
 	}
@@ -135,19 +407,19 @@ func GetOrderFromLastTradePriceAndConfig() []*Order {
 }
 
 //板を参照し使用するJPYから適切な注文を作成します
-func GetMarketPriceOrder(useJpy float64, limit float64) *Order {
-	canAmount := 0.0
+func (b *Bot) GetMarketPriceOrder(useJpy decimal.Decimal, limit decimal.Decimal) *Order {
+	canAmount := decimal.Zero
 	lastJpy := useJpy
-	retPrice := 0.0
-	for _, row := range latestBoard.Asks {
+	retPrice := decimal.Zero
+	for _, row := range b.snapshot.Board.Asks {
 		tmpLastJpy := lastJpy
-		lastJpy -= row[0] * row[1]
-		if lastJpy < 0 {
-			canAmount += tmpLastJpy / row[0]
-			retPrice = row[0]
+		lastJpy = lastJpy.Sub(row.Price.Mul(row.Amount))
+		if lastJpy.IsNegative() {
+			canAmount = canAmount.Add(tmpLastJpy.Div(row.Price))
+			retPrice = row.Price
 			break
 		} else {
-			canAmount += row[1]
+			canAmount = canAmount.Add(row.Amount)
 		}
 	}
 	retOrder := &Order{
@@ -155,39 +427,42 @@ func GetMarketPriceOrder(useJpy float64, limit float64) *Order {
 		limit,
 		canAmount,
 		useJpy,
+		b.GetPositionNum(),
 	}
 	return retOrder
 }
 
-//使用可能な残りJPYを返却します
-func GetRemainJpy() float64 {
-	return latestAccountInfo.Return.Funds.Jpy
+//使用可能な残りの決済通貨(btc_jpyの場合JPY)を返却します
+func (b *Bot) GetRemainQuote() decimal.Decimal {
+	return b.snapshot.Balance.Funds[b.quoteCurrency()]
 }
 
-func CancelLowestOrderIfOrderFull() {
+func (b *Bot) CancelLowestOrderIfOrderFull() {
 
-	if GetPositionNum() <= config.MaxOrderCount {
+	if b.GetPositionNum() <= b.pair.MaxOrderCount {
 		return
 	}
-	lowest := 1234567890.0
+	lowest := decimal.Zero
 	retOrderId := 0
-	for _, order := range latestActiveOrderInfo.Return {
-		if lowest > order.Price {
+	for _, order := range b.snapshot.ActiveOrders {
+		if retOrderId == 0 || lowest.GreaterThan(order.Price) {
 			lowest = order.Price
 			retOrderId = order.ID
 		}
 	}
 	if retOrderId != 0 {
-		api.CancelOrder(retOrderId)
+		if err := b.cancelOrder(retOrderId); err != nil {
+			fmt.Fprintln(b.out, "注文キャンセル時にエラーが発生しました", err)
+		}
 	}
 
 }
 
 //通っていない買い注文があるかどうかを返却します
-func GetLongOrderCount() int {
+func (b *Bot) GetLongOrderCount() int {
 	ret := 0
-	for _, order := range latestActiveOrderInfo.Return {
-		if order.Action == "bid" {
+	for _, order := range b.snapshot.ActiveOrders {
+		if order.Action == exchange.Bid {
 			ret++
 		}
 	}
@@ -195,24 +470,36 @@ func GetLongOrderCount() int {
 }
 
 //最後の取引の約定価格を返却します,action:ask(売り) bid(買い)
-func GetLastPrice() float64 {
+//ボットの買い注文の約定はコメントに残した意図した価格を返却します
+func (b *Bot) GetLastPrice() decimal.Decimal {
 
-	if len(latestTradeHistory.Return) == 0 {
-		return 0
+	if len(b.snapshot.TradeHistory) == 0 {
+		return decimal.Zero
 	}
 
-	lastTrade := latestTradeHistory.Return[0]
+	lastTrade := b.snapshot.TradeHistory[0]
+	if tag, ok := ordertag.Parse(lastTrade.Comment); ok && lastTrade.YourAction == exchange.Bid && tag.Price.IsPositive() {
+		return tag.Price
+	}
 	return lastTrade.Price
 }
 
+//注文の意図した価格を返却します。コメントに価格が無い場合は注文価格です
+func intendedPrice(order exchange.Order) decimal.Decimal {
+	if tag, ok := ordertag.Parse(order.Comment); ok && order.Action == exchange.Bid && tag.Price.IsPositive() {
+		return tag.Price
+	}
+	return order.Price
+}
+
 //最後の取引履歴が買いかどうかを返却します
-func isLastTradeLong() bool {
-	if len(latestTradeHistory.Return) == 0 {
+func (b *Bot) isLastTradeLong() bool {
+	if len(b.snapshot.TradeHistory) == 0 {
 		return false
 	}
 
-	lastTrade := latestTradeHistory.Return[0]
-	if lastTrade.YourAction == "bid" {
+	lastTrade := b.snapshot.TradeHistory[0]
+	if lastTrade.YourAction == exchange.Bid {
 		return true
 	}
 	return false
@@ -220,49 +507,165 @@ func isLastTradeLong() bool {
 }
 
 //現在保有しているポジション数を返却します（=通っていない売り注文の数です）
-func GetPositionNum() int {
+func (b *Bot) GetPositionNum() int {
 	count := 0
-	for _, order := range latestActiveOrderInfo.Return {
-		if order.Action == "ask" {
+	for _, order := range b.snapshot.ActiveOrders {
+		if order.Action == exchange.Ask {
 			count++
 		}
 	}
 	return count
 }
 
+//最大ポジション数に達しているため注文しなかった場合のエラーです
+var ErrMaxPosition = errors.New("最大ポジション数に達しました")
+
 //注文structから実際に注文を行います
-//最大ポジション数に達した場合は実行されません
-func BuyFromOrder(order *Order) {
+//最大ポジション数に達した場合は実行されずErrMaxPosition、リスク制限に収まらない場合はRiskErrorを返却します
+func (b *Bot) BuyFromOrder(order *Order) error {
 
-	if GetPositionNum() >= config.MaxPositionCount {
-		fmt.Println("最大ポジション数に達しました。")
-		return
+	if b.GetPositionNum() >= b.pair.MaxPositionCount {
+		return ErrMaxPosition
+	}
+	if err := b.checkRisk(order); err != nil {
+		return err
 	}
 
-	res, err := api.GetLongPosition(order.Price, order.Limit, order.Amount)
+	comment, err := ordertag.Encode(ordertag.Tag{
+		BotID:    b.id,
+		Level:    order.Level,
+		Price:    order.Price,
+		Strategy: StrategyVersion,
+	})
+	if err != nil {
+		return err
+	}
+	result, err := b.ex.PlaceOrder(&exchange.OrderRequest{
+		CurrencyPair: b.pair.CurrencyPair,
+		Action:       exchange.Bid,
+		Price:        order.Price,
+		Limit:        order.Limit,
+		Amount:       order.Amount,
+		Comment:      comment,
+	})
 	if err != nil {
-		fmt.Print(err)
+		return err
+	}
+	b.commitRisk(order)
+	fmt.Fprintf(b.out, "注文に成功しました。\n")
+	api.FprettyPrint(b.out, order)
+	b.recordOrder(order, result, comment)
+	return nil
+}
+
+//発注した買い注文を台帳に記録します。約定の割り当てに使うため取引所と同じく刻みに合わせます
+func (b *Bot) recordOrder(order *Order, result *exchange.OrderResult, comment string) {
+	if b.ledger == nil {
 		return
 	}
-	if res.Success != 1 {
-		fmt.Print(res.Error)
+	info, err := api.GetPairInfo(b.pair.CurrencyPair)
+	if err != nil {
+		fmt.Fprintln(b.out, "台帳への記録に失敗しました", err)
 		return
 	}
-	fmt.Printf("注文に成功しました。\n")
-	api.PrettyPrint(order)
+	_, err = b.ledger.RecordOrder(b.pair.CurrencyPair, result.OrderID,
+		info.SnapPrice(order.Price, string(exchange.Bid)),
+		info.SnapPrice(order.Limit, string(exchange.Ask)),
+		info.SnapAmount(order.Amount),
+		comment, b.now())
+	if err != nil {
+		fmt.Fprintln(b.out, "台帳への記録に失敗しました", err)
+	}
+}
+
+//注文をキャンセルし、台帳に記録します
+func (b *Bot) cancelOrder(orderID int) error {
+	if err := b.ex.CancelOrder(orderID); err != nil {
+		return err
+	}
+	if b.ledger != nil {
+		if err := b.ledger.CancelOrder(orderID); err != nil {
+			fmt.Fprintln(b.out, "台帳への記録に失敗しました", err)
+		}
+	}
+	return nil
+}
+
+//損切りを確認してからグリッドの買い注文を出します。周回ごとにUpdateAllInfoの後で呼び出します
+//本番とバックテストで同じ順序で発注するための入口です。台帳は最後にまとめて保存します
+func (b *Bot) Trade() {
+	if b.ledger == nil {
+		b.trade()
+		return
+	}
+	if err := b.ledger.Batch(b.trade); err != nil {
+		fmt.Fprintln(b.out, "台帳の保存に失敗しました", err)
+	}
+}
+
+func (b *Bot) trade() {
+	if err := b.RunLossCut(); err != nil {
+		fmt.Fprintln(b.out, "損切りに失敗しました", err)
+	}
+	b.PlaceGridOrders()
+}
+
+//設定に従ってグリッドの買い注文をまとめて出します
+//近い価格に買い注文がある場合はスキップし、続行できないエラーが発生した時点で打ち切ります
+func (b *Bot) PlaceGridOrders() {
+	if b.InLossCutCooldown() {
+		fmt.Fprintln(b.out, "損切りの直後のため買い注文を控えます", b.lossCut.cooldownUntil.Format("15:04:05"))
+		return
+	}
+	orders := b.GetOrderFromLastTradePriceAndConfig()
+	for _, order := range orders {
+		if b.HasRangeBuyOrder(order.Price) {
+			fmt.Fprintln(b.out, "すでに同様の注文/もしくは高い注文があるためスキップします", order.Price)
+			continue
+		}
+		err := b.BuyFromOrder(order)
+		if err == nil {
+			continue
+		}
+		fmt.Fprintln(b.out, "注文に失敗しました:", err)
+		if shouldStopOrdering(err) {
+			break
+		}
+	}
+}
+
+//この周回で以降の注文を打ち切るべきエラーかどうかを返却します
+//価格/数量不正はその注文だけの問題のため続行します
+func shouldStopOrdering(err error) bool {
+	if err == ErrMaxPosition {
+		return true
+	}
+	//金額や数量の上限は以降の注文も超えるため打ち切ります
+	if _, ok := err.(*RiskError); ok {
+		return true
+	}
+	switch api.KindOf(err) {
+	case api.ErrInsufficientFunds, api.ErrRateLimited, api.ErrMaintenance, api.ErrAuth, api.ErrNonce:
+		return true
+	}
+	return false
 }
 
 //現時点と同じか、高い価格の注文があるかどうかを返却します
-func IsSameOrHigherOrderExist(order *Order) bool {
+func (b *Bot) IsSameOrHigherOrderExist(order *Order) bool {
 
-	amount := float64(api.Round(order.Amount, 4))
-	price := float64(api.Round5(order.Price))
+	info, err := api.GetPairInfo(b.pair.CurrencyPair)
+	if err != nil {
+		return false
+	}
+	amount := info.SnapAmount(order.Amount)
+	price := info.SnapPrice(order.Price, string(exchange.Bid))
 
-	for _, serverOrder := range latestActiveOrderInfo.Return {
-		if serverOrder.Amount == amount && serverOrder.Price == price && serverOrder.Action == "bid" {
+	for _, serverOrder := range b.snapshot.ActiveOrders {
+		if serverOrder.Amount.Equal(amount) && serverOrder.Price.Equal(price) && serverOrder.Action == exchange.Bid {
 			return true
 		}
-		if serverOrder.Price > order.Price && serverOrder.Action == "bid" {
+		if intendedPrice(serverOrder).GreaterThan(order.Price) && serverOrder.Action == exchange.Bid {
 			return true
 		}
 	}
@@ -270,10 +673,10 @@ func IsSameOrHigherOrderExist(order *Order) bool {
 	return false
 }
 
-func HasRangeBuyOrder(price float64) bool {
-	activeOrder := latestActiveOrderInfo
-	for _, order := range activeOrder.Return {
-		if order.Action == "bid" && (math.Abs(order.Price-price) < price*config.BuyRange) {
+func (b *Bot) HasRangeBuyOrder(price decimal.Decimal) bool {
+	rangeWidth := price.Mul(decimal.NewFromFloat(b.pair.BuyRange))
+	for _, order := range b.snapshot.ActiveOrders {
+		if order.Action == exchange.Bid && intendedPrice(order).Sub(price).Abs().LessThan(rangeWidth) {
 			return true
 		}
 	}
@@ -281,59 +684,104 @@ func HasRangeBuyOrder(price float64) bool {
 }
 
 //全てのLong注文をキャンセルします
-func CancelAllLongOrder() (bool, error) {
-	for _, order := range latestActiveOrderInfo.Return {
-		if order.Action == "bid" {
-			cancelResponse, errCancel := api.CancelOrder(order.ID)
-			if errCancel != nil {
+func (b *Bot) CancelAllLongOrder() (bool, error) {
+	for _, order := range b.snapshot.ActiveOrders {
+		if order.Action == exchange.Bid {
+			if errCancel := b.cancelOrder(order.ID); errCancel != nil {
 				return false, errCancel
 			}
-			if cancelResponse.Success != 1 {
-				return false, errors.New(cancelResponse.Error)
-			}
 		}
 	}
-	fmt.Println("全ての買い注文をキャンセルしました")
+	fmt.Fprintln(b.out, "全ての買い注文をキャンセルしました")
 	return true, nil
 }
 
+//このボットが出したグリッドの買い注文を意図した価格の高い順に返却します
+func (b *Bot) gridBuyOrders() []exchange.Order {
+	ret := []exchange.Order{}
+	for _, order := range b.snapshot.ActiveOrders {
+		if order.Action == exchange.Bid && ordertag.IsOwnedBy(order.Comment, b.id) {
+			ret = append(ret, order)
+		}
+	}
+	sort.SliceStable(ret, func(i, j int) bool { return intendedPrice(ret[i]).GreaterThan(intendedPrice(ret[j])) })
+	return ret
+}
+
+//現在の設定に合わなくなったグリッドの買い注文をキャンセルします
+//高い注文から順にMaxOrderCountを超えた分と、1つ上の注文との間隔がBuyRangeより狭い注文が対象です
+func (b *Bot) CancelOffGridOrders() (int, error) {
+	//価格の刻みへの丸めで間隔が少し狭くなるのを許容します
+	minGap := decimal.NewFromFloat(b.pair.BuyRange * 0.9)
+	kept := []exchange.Order{}
+	canceled := 0
+	for _, order := range b.gridBuyOrders() {
+		price := intendedPrice(order)
+		fits := len(kept) < b.pair.MaxOrderCount
+		if fits && len(kept) > 0 {
+			upper := intendedPrice(kept[len(kept)-1])
+			fits = upper.Sub(price).GreaterThanOrEqual(upper.Mul(minGap))
+		}
+		if fits {
+			kept = append(kept, order)
+			continue
+		}
+		if err := b.cancelOrder(order.ID); err != nil {
+			return canceled, err
+		}
+		fmt.Fprintln(b.out, "新しい設定に合わない買い注文をキャンセルしました", price)
+		canceled++
+	}
+	return canceled, nil
+}
+
+//グリッドの買い注文を全てキャンセルします。次の周回で新しい設定の価格で出し直します
+func (b *Bot) CancelGridOrders() (int, error) {
+	canceled := 0
+	for _, order := range b.gridBuyOrders() {
+		if err := b.cancelOrder(order.ID); err != nil {
+			return canceled, err
+		}
+		canceled++
+	}
+	fmt.Fprintf(b.out, "グリッドの買い注文を%d件キャンセルしました\n", canceled)
+	return canceled, nil
+}
+
 //全ての注文をキャンセルします
-func CancelAllOrder() (bool, error) {
-	for _, order := range latestActiveOrderInfo.Return {
-		cancelResponse, errCancel := api.CancelOrder(order.ID)
-		if errCancel != nil {
+func (b *Bot) CancelAllOrder() (bool, error) {
+	for _, order := range b.snapshot.ActiveOrders {
+		if errCancel := b.cancelOrder(order.ID); errCancel != nil {
 			return false, errCancel
 		}
-		if cancelResponse.Success != 1 {
-			return false, errors.New(cancelResponse.Error)
-		}
-		fmt.Println("注文を1本キャンセルしました")
+		fmt.Fprintln(b.out, "注文を1本キャンセルしました")
 	}
-	fmt.Println("全ての注文をキャンセルしました")
+	fmt.Fprintln(b.out, "全ての注文をキャンセルしました")
 	return true, nil
 
 }
 
-//全てのBTCを成行で売却します
-func SellAllBtc() bool {
-	res, err := api.SellBtc(latestAccountInfo.Return.Deposit.Btc)
+//保有している基軸通貨(btc_jpyの場合BTC)を全て成行で売却します
+func (b *Bot) SellAllBase() bool {
+	base := b.baseCurrency()
+	_, err := b.ex.PlaceOrder(&exchange.OrderRequest{
+		CurrencyPair: b.pair.CurrencyPair,
+		Action:       exchange.Ask,
+		Amount:       b.snapshot.Balance.Deposit[base],
+		Market:       true,
+	})
 	if err != nil {
-		fmt.Println("BTC売却に失敗しました")
-		fmt.Println(err)
-		return false
-	}
-	if res.Success != 1 {
-		fmt.Println("BTC売却に失敗しました")
-		fmt.Println(res.Error)
+		fmt.Fprintln(b.out, base+"売却に失敗しました")
+		fmt.Fprintln(b.out, err)
 		return false
 	}
-	fmt.Println("BTCを売却しました")
+	fmt.Fprintln(b.out, base+"を売却しました")
 	return true
 }
 
-func ShouldSongiri() bool {
-	if GetPositionNum() >= config.MaxPositionCount {
-		if latestBoard.Asks[0][0] < GetLastPrice()*(1-config.BuyRange) {
+func (b *Bot) ShouldSongiri() bool {
+	if b.GetPositionNum() >= b.pair.MaxPositionCount {
+		if b.snapshot.Board.Asks[0].Price.LessThan(b.GetLastPrice().Mul(one.Sub(decimal.NewFromFloat(b.pair.BuyRange)))) {
 			return true
 		}
 	}
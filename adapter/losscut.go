@@ -0,0 +1,270 @@
+package adapter
+
+import (
+	"fmt"
+	"grid-crypto-real/decimal"
+	"grid-crypto-real/exchange"
+	"grid-crypto-real/ordertag"
+	"time"
+)
+
+//損切りの状態です
+type lossCutState struct {
+	peak          decimal.Decimal //これまでの総資産の最高値
+	liquidating   bool            //総資産による損切りで全ロットを売却中
+	stageSize     int             //1回に売却するロット数
+	cooldownUntil time.Time
+	pending       map[int]lossCutOrder //約定を待っている指値の売り注文
+}
+
+//損切りで出した指値の売り注文です
+type lossCutOrder struct {
+	lotIDs   []int
+	amount   decimal.Decimal
+	canceled bool //指値注文は取り消し済みで、成行の売却が受け付けられるのを待っている
+}
+
+//損切りの対象になるポジションです
+type lossCutTarget struct {
+	lotID      int //台帳が無い場合は0
+	amount     decimal.Decimal
+	entry      decimal.Decimal
+	takeProfit decimal.Decimal
+}
+
+//損切りの直後で新しい買い注文を控えている場合はtrueを返却します
+func (b *Bot) InLossCutCooldown() bool {
+	return b.lossCut.liquidating || b.now().Before(b.lossCut.cooldownUntil)
+}
+
+//損切りの条件を確認し、該当するポジションを売却します
+//前回の周回で出した指値の売り注文が残っている場合は、取り消して成行で売却します
+//ロットごとの損切りはStopLossRange、総資産による損切りはMaxDrawdownで設定し、総資産による損切りはLossCutStages回に分けて売却します
+func (b *Bot) RunLossCut() error {
+	if err := b.sellPendingAtMarket(); err != nil {
+		return err
+	}
+	bids := b.snapshot.Board.Bids
+	if len(bids) == 0 {
+		return nil
+	}
+	bid := bids[0].Price
+	targets := b.lossCutTargets()
+
+	if b.pair.MaxDrawdown > 0 && !b.lossCut.liquidating {
+		equity := b.snapshot.Balance.Deposit[b.quoteCurrency()].Add(b.snapshot.Balance.Deposit[b.baseCurrency()].Mul(bid))
+		b.lossCut.peak = decimal.Max(b.lossCut.peak, equity)
+		if b.lossCut.peak.IsPositive() {
+			drawdown := b.lossCut.peak.Sub(equity).Float64() / b.lossCut.peak.Float64()
+			if drawdown >= b.pair.MaxDrawdown {
+				fmt.Fprintf(b.out, "総資産が最高値%fから%.2f%%減ったため全てのロットを損切りします\n", b.lossCut.peak, drawdown*100)
+				b.lossCut.liquidating = true
+				stages := b.pair.LossCutStages
+				if stages < 1 {
+					stages = 1
+				}
+				b.lossCut.stageSize = (len(targets) + stages - 1) / stages
+				if _, err := b.CancelAllLongOrder(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	cuts := []lossCutTarget{}
+	if b.lossCut.liquidating {
+		if len(targets) == 0 && len(b.lossCut.pending) == 0 {
+			fmt.Fprintln(b.out, "損切りによる売却が完了しました")
+			b.lossCut.liquidating = false
+			b.lossCut.peak = decimal.Zero
+			b.startCooldown()
+			return nil
+		}
+		cuts = targets
+		if b.lossCut.stageSize > 0 && len(cuts) > b.lossCut.stageSize {
+			cuts = cuts[:b.lossCut.stageSize]
+		}
+	} else if b.pair.StopLossRange > 0 {
+		ratio := one.Sub(decimal.NewFromFloat(b.pair.StopLossRange))
+		for _, t := range targets {
+			if bid.LessThanOrEqual(t.entry.Mul(ratio)) {
+				cuts = append(cuts, t)
+			}
+		}
+	}
+	if len(cuts) == 0 {
+		return nil
+	}
+	return b.cutPositions(cuts, bid)
+}
+
+//損切りの対象になりうるポジションを買値の低い順に返却します
+//台帳がある場合は保有中のロット、無い場合は利確の売り注文をポジションとして扱います
+func (b *Bot) lossCutTargets() []lossCutTarget {
+	pending := map[int]bool{}
+	for _, order := range b.lossCut.pending {
+		for _, id := range order.lotIDs {
+			pending[id] = true
+		}
+	}
+	ret := []lossCutTarget{}
+	if b.ledger != nil {
+		for _, lot := range b.ledger.OpenLots(b.pair.CurrencyPair) {
+			if lot.LossCut || pending[lot.ID] {
+				continue
+			}
+			ret = append(ret, lossCutTarget{lot.ID, lot.Held(), lot.BuyPrice(), lot.TakeProfitPrice})
+		}
+	} else {
+		takeProfitRatio := one.Add(decimal.NewFromFloat(b.pair.TakeProfitRange))
+		for _, order := range b.snapshot.ActiveOrders {
+			if tag, ok := ordertag.Parse(order.Comment); order.Action != exchange.Ask || (ok && tag.Level == ordertag.LossCutLevel) {
+				continue
+			}
+			ret = append(ret, lossCutTarget{0, order.Amount, order.Price.Div(takeProfitRatio), order.Price})
+		}
+	}
+	//損失の大きいものから売却します
+	for i := 1; i < len(ret); i++ {
+		for j := i; j > 0 && ret[j].entry.GreaterThan(ret[j-1].entry); j-- {
+			ret[j], ret[j-1] = ret[j-1], ret[j]
+		}
+	}
+	return ret
+}
+
+//ポジションの利確注文を取り消し、最良買気配の指値でまとめて売却します
+//売り注文が受け付けられなかった場合は取り消した利確注文を出し直し、ロットは損切りとして記録しないため次の周回で再び対象になります
+func (b *Bot) cutPositions(cuts []lossCutTarget, bid decimal.Decimal) error {
+	canceled := map[int]bool{}
+	takeProfits := []exchange.Order{}
+	amount := decimal.Zero
+	lotIDs := []int{}
+	for _, cut := range cuts {
+		//約定が分かれた場合は利確注文も分かれるため、保有数量に達するまで取り消します
+		released := decimal.Zero
+		for _, order := range b.snapshot.ActiveOrders {
+			if released.GreaterThanOrEqual(cut.amount) {
+				break
+			}
+			if canceled[order.ID] || order.Action != exchange.Ask || !order.Price.Equal(cut.takeProfit) {
+				continue
+			}
+			if err := b.cancelOrder(order.ID); err != nil {
+				return err
+			}
+			canceled[order.ID] = true
+			takeProfits = append(takeProfits, order)
+			released = released.Add(order.Amount)
+		}
+		amount = amount.Add(cut.amount)
+		if cut.lotID != 0 {
+			lotIDs = append(lotIDs, cut.lotID)
+		}
+	}
+	comment, err := b.lossCutComment(bid)
+	if err == nil {
+		fmt.Fprintf(b.out, "%d件のポジション(%f%s)を%fで損切りします\n", len(cuts), amount, b.baseCurrency(), bid)
+		var result *exchange.OrderResult
+		result, err = b.ex.PlaceOrder(&exchange.OrderRequest{
+			CurrencyPair: b.pair.CurrencyPair,
+			Action:       exchange.Ask,
+			Price:        bid,
+			Amount:       amount,
+			Comment:      comment,
+		})
+		if err == nil && result.OrderID != 0 {
+			if b.lossCut.pending == nil {
+				b.lossCut.pending = map[int]lossCutOrder{}
+			}
+			b.lossCut.pending[result.OrderID] = lossCutOrder{lotIDs: lotIDs, amount: result.Remains}
+		}
+	}
+	if err != nil {
+		b.restoreTakeProfits(takeProfits)
+		return err
+	}
+	if b.ledger != nil && len(lotIDs) > 0 {
+		if err := b.ledger.MarkLossCut(lotIDs...); err != nil {
+			fmt.Fprintln(b.out, "台帳への記録に失敗しました", err)
+		}
+	}
+	if !b.lossCut.liquidating {
+		b.startCooldown()
+	}
+	return nil
+}
+
+//損切りの売り注文が受け付けられなかった場合に、取り消した利確注文を同じ価格と数量で出し直します
+//出し直せなかった分はログに出力します。ロットは損切りの対象に残るため次の周回で再び売却を試みます
+func (b *Bot) restoreTakeProfits(orders []exchange.Order) {
+	for _, order := range orders {
+		if _, err := b.ex.PlaceOrder(&exchange.OrderRequest{
+			CurrencyPair: order.CurrencyPair,
+			Action:       exchange.Ask,
+			Price:        order.Price,
+			Amount:       order.Amount,
+			Comment:      order.Comment,
+		}); err != nil {
+			fmt.Fprintf(b.out, "利確注文(%f%s@%f)を出し直せませんでした %v\n", order.Amount, b.baseCurrency(), order.Price, err)
+			continue
+		}
+		fmt.Fprintf(b.out, "損切りできなかったため利確注文(%f%s@%f)を出し直しました\n", order.Amount, b.baseCurrency(), order.Price)
+	}
+}
+
+//前回の周回で出した損切りの指値注文のうち、約定していない分を取り消して成行で売却します
+//成行の売却が受け付けられなかった場合は、取り消した数量を残して次の周回で再び売却します
+func (b *Bot) sellPendingAtMarket() error {
+	for id, pending := range b.lossCut.pending {
+		if pending.canceled {
+			//取り消すまでの間に約定した分は売却できないため、使用可能な残高までとします
+			pending.amount = decimal.Min(pending.amount, b.snapshot.Balance.Funds[b.baseCurrency()])
+			if !pending.amount.IsPositive() {
+				delete(b.lossCut.pending, id)
+				continue
+			}
+		} else {
+			var remain *exchange.Order
+			for i := range b.snapshot.ActiveOrders {
+				if b.snapshot.ActiveOrders[i].ID == id {
+					remain = &b.snapshot.ActiveOrders[i]
+				}
+			}
+			if remain == nil {
+				delete(b.lossCut.pending, id)
+				continue
+			}
+			if err := b.cancelOrder(id); err != nil {
+				return err
+			}
+			pending = lossCutOrder{lotIDs: pending.lotIDs, amount: remain.Amount, canceled: true}
+			b.lossCut.pending[id] = pending
+		}
+		comment, err := b.lossCutComment(decimal.Zero)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(b.out, "損切りの指値注文が約定しなかったため%f%sを成行で売却します\n", pending.amount, b.baseCurrency())
+		if _, err := b.ex.PlaceOrder(&exchange.OrderRequest{
+			CurrencyPair: b.pair.CurrencyPair,
+			Action:       exchange.Ask,
+			Amount:       pending.amount,
+			Comment:      comment,
+			Market:       true,
+		}); err != nil {
+			return err
+		}
+		delete(b.lossCut.pending, id)
+	}
+	return nil
+}
+
+//損切りの売り注文のコメントを返却します。台帳はこのコメントで損切りの約定を判断します
+func (b *Bot) lossCutComment(price decimal.Decimal) (string, error) {
+	return ordertag.Encode(ordertag.Tag{BotID: b.id, Level: ordertag.LossCutLevel, Price: price, Strategy: StrategyVersion})
+}
+
+func (b *Bot) startCooldown() {
+	b.lossCut.cooldownUntil = b.now().Add(b.pair.LossCutCooldown)
+}
@@ -0,0 +1,192 @@
+package adapter
+
+import (
+	"fmt"
+	"grid-crypto-real/decimal"
+	"grid-crypto-real/exchange"
+	"grid-crypto-real/history"
+	"grid-crypto-real/ordertag"
+	"io"
+)
+
+//復旧時に1回で取得する約定履歴の件数です
+const recoveryPageSize = 1000
+
+//再起動時の復旧結果です
+type RecoveryReport struct {
+	CurrencyPair      string
+	Trades            int              //取得した約定の件数
+	BotTrades         int              //このボットのコメントが付いた約定の件数
+	OwnedOrders       []exchange.Order //ボットの注文
+	UnexplainedOrders []exchange.Order //ボットのものと判断できない注文
+	RecordedOrders    int              //台帳に無かったため追加した買い注文の件数
+	ExpiredOrders     int              //台帳にあるが既に存在しない買い注文の件数
+	LastGridPrice     decimal.Decimal  //ボットの最後の約定価格
+	LastGridAction    exchange.Action
+	Discrepancies     []string
+}
+
+//取引所の全約定履歴と未約定注文から台帳とポジションを復元し、説明のつかない注文や残高の差異を報告します
+//取引を再開する前に呼び出してください。取得に失敗した場合はエラーを返却します
+func (b *Bot) Recover() (*RecoveryReport, error) {
+	pair := b.pair.CurrencyPair
+	report := &RecoveryReport{CurrencyPair: pair}
+	trades, err := b.fetchAllTrades()
+	if err != nil {
+		return nil, err
+	}
+	//台帳には古い約定から反映する必要があるため、最新の情報より先に全履歴を反映します
+	if b.ledger != nil {
+		if err := b.ledger.ApplyTrades(trades); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := b.UpdateAllInfo(); err != nil {
+		return nil, err
+	}
+	report.Trades = len(trades)
+	for _, t := range trades {
+		if !ordertag.IsOwnedBy(t.Comment, b.id) {
+			continue
+		}
+		if report.BotTrades == 0 {
+			report.LastGridPrice = t.Price
+			report.LastGridAction = t.YourAction
+		}
+		report.BotTrades++
+	}
+
+	//利確の売り注文にはコメントが付かない場合があるため、台帳の利確価格と一致するものもボットの注文とみなします
+	takeProfits := map[string]int{}
+	if b.ledger != nil {
+		for _, lot := range b.ledger.OpenLots(pair) {
+			takeProfits[lot.TakeProfitPrice.String()]++
+		}
+	}
+	active := map[int]bool{}
+	for _, order := range b.snapshot.ActiveOrders {
+		active[order.ID] = true
+		owned := ordertag.IsOwnedBy(order.Comment, b.id)
+		if !owned && order.Action == exchange.Ask && takeProfits[order.Price.String()] > 0 {
+			takeProfits[order.Price.String()]--
+			owned = true
+		}
+		if !owned && b.ledger != nil && b.ledger.HasOrder(order.ID) {
+			owned = true
+		}
+		if !owned {
+			report.UnexplainedOrders = append(report.UnexplainedOrders, order)
+			continue
+		}
+		report.OwnedOrders = append(report.OwnedOrders, order)
+		//利確価格は未約定注文から分からないため記録しません
+		if b.ledger != nil && order.Action == exchange.Bid && !b.ledger.HasOrder(order.ID) {
+			if _, err := b.ledger.RecordOrder(pair, order.ID, order.Price, decimal.Zero, order.Amount, order.Comment, order.Timestamp); err != nil {
+				return nil, err
+			}
+			report.RecordedOrders++
+		}
+	}
+	if b.ledger != nil {
+		if report.ExpiredOrders, err = b.ledger.ExpireOrders(pair, active); err != nil {
+			return nil, err
+		}
+	}
+	b.checkDiscrepancies(report)
+	return report, nil
+}
+
+//全ての約定履歴を新しい順に取得します。遡って取得できない取引所の場合は通常の履歴を使用します
+//約定履歴のストアが設定されている場合は、差分を同期した上でストアから読み込みます
+func (b *Bot) fetchAllTrades() ([]exchange.Trade, error) {
+	pager, ok := b.ex.(exchange.TradeHistoryPager)
+	if !ok {
+		return b.ex.GetTradeHistory(b.pair.CurrencyPair)
+	}
+	if b.history != nil {
+		if _, err := b.history.Sync(pager, b.pair.CurrencyPair); err != nil {
+			return nil, err
+		}
+		b.historySyncedAt = b.now()
+		trades, err := b.history.Query(b.pair.CurrencyPair, history.Filter{})
+		if err != nil {
+			return nil, err
+		}
+		reverseTrades(trades)
+		return trades, nil
+	}
+	all := []exchange.Trade{}
+	endID := 0
+	for {
+		page, err := pager.QueryTradeHistory(b.pair.CurrencyPair, exchange.TradeQuery{EndID: endID, Count: recoveryPageSize})
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, page...)
+		if len(page) < recoveryPageSize {
+			return all, nil
+		}
+		endID = page[len(page)-1].ID - 1
+		if endID <= 0 {
+			return all, nil
+		}
+	}
+}
+
+//台帳・注文・残高の整合性を確認します
+func (b *Bot) checkDiscrepancies(report *RecoveryReport) {
+	base := b.baseCurrency()
+	deposit := b.snapshot.Balance.Deposit[base]
+	selling := decimal.Zero
+	for _, order := range report.OwnedOrders {
+		if order.Action == exchange.Ask {
+			selling = selling.Add(order.Amount)
+		}
+	}
+	if selling.GreaterThan(deposit) {
+		report.Discrepancies = append(report.Discrepancies,
+			fmt.Sprintf("利確注文の数量%sが%sの残高%sを上回っています", selling, base, deposit))
+	}
+	if b.ledger == nil {
+		return
+	}
+	summary := b.ledger.Summary(b.pair.CurrencyPair)
+	if summary.Held.GreaterThan(deposit) {
+		report.Discrepancies = append(report.Discrepancies,
+			fmt.Sprintf("台帳の保有数量%sが%sの残高%sを上回っています", summary.Held, base, deposit))
+	}
+	if !summary.Held.Equal(selling) {
+		report.Discrepancies = append(report.Discrepancies,
+			fmt.Sprintf("台帳の保有数量%sと利確注文の数量%sが一致しません", summary.Held, selling))
+	}
+	if summary.OpenLots != b.GetPositionNum() {
+		report.Discrepancies = append(report.Discrepancies,
+			fmt.Sprintf("台帳の保有ロット数%dとポジション数%dが一致しません", summary.OpenLots, b.GetPositionNum()))
+	}
+	if unmatched := b.ledger.Unmatched(); len(unmatched) > 0 {
+		report.Discrepancies = append(report.Discrepancies,
+			fmt.Sprintf("どのロットにも割り当てられない売り約定が%d件あります", len(unmatched)))
+	}
+}
+
+//復旧結果をログに出力します
+func (r *RecoveryReport) Print(w io.Writer) {
+	fmt.Fprintln(w, "----復旧結果("+r.CurrencyPair+")-----")
+	fmt.Fprintf(w, "約定履歴:%d件(ボット:%d件)\n", r.Trades, r.BotTrades)
+	if r.BotTrades > 0 {
+		fmt.Fprintf(w, "最後のグリッド:%s %f\n", r.LastGridAction, r.LastGridPrice)
+	}
+	fmt.Fprintf(w, "ボットの注文:%d件 台帳に追加:%d件 終了済み:%d件\n", len(r.OwnedOrders), r.RecordedOrders, r.ExpiredOrders)
+	for _, order := range r.UnexplainedOrders {
+		fmt.Fprintf(w, "説明のつかない注文: id:%d %s %f@%f comment:%q\n", order.ID, order.Action, order.Amount, order.Price, order.Comment)
+	}
+	for _, d := range r.Discrepancies {
+		fmt.Fprintln(w, "差異:", d)
+	}
+	fmt.Fprintln(w, "-------------")
+}
+
+//確認が必要な項目があるかを返却します
+func (r *RecoveryReport) HasIssues() bool {
+	return len(r.UnexplainedOrders) > 0 || len(r.Discrepancies) > 0
+}
@@ -0,0 +1,121 @@
+package adapter
+
+import (
+	"fmt"
+	"grid-crypto-real/api"
+	"grid-crypto-real/decimal"
+	"grid-crypto-real/exchange"
+	"grid-crypto-real/pnl"
+)
+
+//リスク制限により注文しなかった場合のエラーです
+type RiskError struct {
+	Reason string
+}
+
+func (e *RiskError) Error() string {
+	return "リスク制限により注文しません: " + e.Reason
+}
+
+//スナップショットを取得してから発注した買い注文の合計です
+type committed struct {
+	quote decimal.Decimal
+	base  decimal.Decimal
+}
+
+//発注前にリスク制限を確認し、必要に応じて数量を減らします
+//制限に収まらない場合や、減らした数量が最小注文数量を下回る場合はRiskErrorを返却します
+func (b *Bot) checkRisk(order *Order) error {
+	if !order.Price.IsPositive() || !order.Amount.IsPositive() {
+		return &RiskError{fmt.Sprintf("価格%fと数量%fが不正です", order.Price, order.Amount)}
+	}
+	if b.pair.DailyLossLimit.IsPositive() {
+		if loss := b.todayRealized().Neg(); loss.GreaterThanOrEqual(b.pair.DailyLossLimit) {
+			return &RiskError{fmt.Sprintf("本日の実現損失%.2fが上限%.2fに達しています", loss, b.pair.DailyLossLimit)}
+		}
+	}
+
+	//注文金額の上限を順に適用し、超える場合は数量を減らします
+	type limit struct {
+		reason string
+		quote  decimal.Decimal
+	}
+	limits := []limit{{"使用可能な" + b.quoteCurrency(), b.GetRemainQuote().Sub(b.pair.MinQuoteReserve).Sub(b.snapshot.committed.quote)}}
+	if b.pair.MaxOrderNotional.IsPositive() {
+		limits = append(limits, limit{"1注文の金額の上限", b.pair.MaxOrderNotional})
+	}
+	if b.pair.MaxQuoteExposure.IsPositive() {
+		limits = append(limits, limit{"買い注文中と保有分の金額の上限", b.pair.MaxQuoteExposure.Sub(b.exposure())})
+	}
+	if b.pair.MaxBaseInventory.IsPositive() {
+		limits = append(limits, limit{b.baseCurrency() + "の保有量の上限", b.pair.MaxBaseInventory.Sub(b.inventory()).Mul(order.Price)})
+	}
+	notional := order.Price.Mul(order.Amount)
+	for _, l := range limits {
+		if notional.LessThanOrEqual(l.quote) {
+			continue
+		}
+		if !l.quote.IsPositive() {
+			return &RiskError{l.reason + "に達しています"}
+		}
+		amount := l.quote.Div(order.Price)
+		if info, err := api.GetPairInfo(b.pair.CurrencyPair); err == nil {
+			amount = info.SnapAmount(amount)
+			if amount.LessThan(info.MinAmount) {
+				return &RiskError{fmt.Sprintf("%sにより数量%fが最小注文数量%fを下回ります", l.reason, amount, info.MinAmount)}
+			}
+		}
+		fmt.Fprintf(b.out, "%sにより数量を%fから%fに減らします\n", l.reason, order.Amount, amount)
+		order.Amount = amount
+		order.UseJpy = order.Price.Mul(amount)
+		notional = order.UseJpy
+	}
+	return nil
+}
+
+//発注した買い注文を以降のリスク制限の確認に反映します
+func (b *Bot) commitRisk(order *Order) {
+	b.snapshot.committed.quote = b.snapshot.committed.quote.Add(order.Price.Mul(order.Amount))
+	b.snapshot.committed.base = b.snapshot.committed.base.Add(order.Amount)
+}
+
+//保有分と買い注文中の分を合わせた決済通貨建ての金額を返却します。保有分は最良買気配で評価します
+func (b *Bot) exposure() decimal.Decimal {
+	ret := b.snapshot.committed.quote
+	if len(b.snapshot.Board.Bids) > 0 {
+		ret = ret.Add(b.snapshot.Balance.Deposit[b.baseCurrency()].Mul(b.snapshot.Board.Bids[0].Price))
+	}
+	for _, order := range b.snapshot.ActiveOrders {
+		if order.Action == exchange.Bid {
+			ret = ret.Add(order.Price.Mul(order.Amount))
+		}
+	}
+	return ret
+}
+
+//保有分と買い注文中の分を合わせた基軸通貨の数量を返却します
+func (b *Bot) inventory() decimal.Decimal {
+	ret := b.snapshot.Balance.Deposit[b.baseCurrency()].Add(b.snapshot.committed.base)
+	for _, order := range b.snapshot.ActiveOrders {
+		if order.Action == exchange.Bid {
+			ret = ret.Add(order.Amount)
+		}
+	}
+	return ret
+}
+
+//台帳から本日の実現損益を返却します。台帳が無い場合は0です
+func (b *Bot) todayRealized() decimal.Decimal {
+	if b.ledger == nil {
+		return decimal.Zero
+	}
+	report := pnl.Compute(b.pair.CurrencyPair, b.ledger.Lots(b.pair.CurrencyPair), decimal.Zero, pnl.Daily)
+	today := pnl.Daily.Start(b.now())
+	ret := decimal.Zero
+	for _, bucket := range report.Buckets {
+		if !bucket.Start.Before(today) {
+			ret = ret.Add(bucket.Realized)
+		}
+	}
+	return ret
+}
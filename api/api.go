@@ -7,9 +7,13 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"grid-crypto-real/credential"
+	"grid-crypto-real/decimal"
+	"grid-crypto-real/exchange"
+	"io"
 	"log"
-	"math"
-	"regexp"
+	"net/url"
+	"os"
 	"strconv"
 	"time"
 
@@ -17,38 +21,31 @@ import (
 )
 
 const (
-	PrivateAPIEndpoint  = "https://api.zaif.jp/tapi"
-	BtcBoardAPIEndpoint = "https://api.zaif.jp/api/1/depth/btc_jpy"
-	APIKey              = "d95945e8-d8f0-40dd-90a9-e8aa2e9f15c6"
-	APISecret           = "e5fad24a-ff32-4f38-9b66-a1e5ecb75cc5"
-	AccountInfoMethod   = "get_info2"
-	TradeHistoryMethod  = "trade_history"
-	TradeMethod         = "trade"
-	ActiveOrderMethod   = "active_orders"
-	CancelOrderMethod   = "cancel_order"
+	PrivateAPIEndpoint = "https://api.zaif.jp/tapi"
+	PublicAPIEndpoint  = "https://api.zaif.jp/api/1"
+	AccountInfoMethod  = "get_info2"
+	TradeHistoryMethod = "trade_history"
+	TradeMethod        = "trade"
+	ActiveOrderMethod  = "active_orders"
+	CancelOrderMethod  = "cancel_order"
+	DepthMethod        = "depth"
 
 	CommentPrefix = "fromBot"
 )
 
 //板情報
 type Board struct {
-	Asks [][]float64 `json:"asks"`
-	Bids [][]float64 `json:"bids"`
+	Asks [][]decimal.Decimal `json:"asks"`
+	Bids [][]decimal.Decimal `json:"bids"`
 }
 
 type AccountInfo struct {
 	Success int    `json:"success"`
 	Error   string `json:"error"`
 	Return  struct {
-		Funds struct {
-			Jpy float64 `json:"jpy"`
-			Btc float64 `json:"btc"`
-		} `json:"funds"`
-		Deposit struct {
-			Jpy float64 `json:"jpy"`
-			Btc float64 `json:"btc"`
-		} `json:"deposit"`
-		Rights struct {
+		Funds   map[string]decimal.Decimal `json:"funds"`
+		Deposit map[string]decimal.Decimal `json:"deposit"`
+		Rights  struct {
 			Info         float64 `json:"info"`
 			Trade        float64 `json:"trade"`
 			Withdraw     float64 `json:"withdraw"`
@@ -61,48 +58,51 @@ type AccountInfo struct {
 }
 
 type TradeHistory struct {
-	Success int    `json:"success"`
-	Error   string `json:"error"`
-	Return  []struct {
-		ID           int         `json:"id"`
-		CurrencyPair string      `json:"currency_pair"`
-		Action       string      `json:"action"`
-		Amount       float64     `json:"amount"`
-		Price        float64     `json:"price"`
-		Fee          float64     `json:"fee"`
-		FeeAmount    float64     `json:"fee_amount"`
-		YourAction   string      `json:"your_action"`
-		Bonus        interface{} `json:"bonus"`
-		Timestamp    string      `json:"timestamp"`
-		Comment      string      `json:"comment"`
-	} `json:"return"`
+	Success int               `json:"success"`
+	Error   string            `json:"error"`
+	Return  TradeHistoryItems `json:"return"`
+}
+
+//取引履歴の1件です
+type TradeHistoryItem struct {
+	ID           int             `json:"id"`
+	CurrencyPair string          `json:"currency_pair"`
+	Action       string          `json:"action"`
+	Amount       decimal.Decimal `json:"amount"`
+	Price        decimal.Decimal `json:"price"`
+	Fee          decimal.Decimal `json:"fee"`
+	FeeAmount    decimal.Decimal `json:"fee_amount"`
+	YourAction   string          `json:"your_action"`
+	Bonus        decimal.Decimal `json:"bonus"` //付与されない場合はnullです
+	Timestamp    string          `json:"timestamp"`
+	Comment      string          `json:"comment"`
 }
 
 type ActiveOrder struct {
-	Success int    `json:"success"`
-	Error   string `json:"error"`
-	Return  []struct {
-		ID           int     `json:"id"`
-		CurrencyPair string  `json:"currency_pair"`
-		Action       string  `json:"action"`
-		Amount       float64 `json:"amount"`
-		Price        float64 `json:"price"`
-		Timestamp    string  `json:"timestamp"`
-		Comment      string  `json:"comment"`
-	} `json:"return"`
+	Success int              `json:"success"`
+	Error   string           `json:"error"`
+	Return  ActiveOrderItems `json:"return"`
+}
+
+//未約定注文の1件です
+type ActiveOrderItem struct {
+	ID           int             `json:"id"`
+	CurrencyPair string          `json:"currency_pair"`
+	Action       string          `json:"action"`
+	Amount       decimal.Decimal `json:"amount"`
+	Price        decimal.Decimal `json:"price"`
+	Timestamp    string          `json:"timestamp"`
+	Comment      string          `json:"comment"`
 }
 
 type TradeResponse struct {
 	Success int    `json:"success"`
 	Error   string `json:"error"`
 	Return  struct {
-		Received float64 `json:"received"`
-		Remains  float64 `json:"remains"`
-		OrderID  int     `json:"order_id"`
-		Funds    struct {
-			Jpy float64 `json:"jpy"`
-			Btc float64 `json:"btc"`
-		} `json:"funds"`
+		Received decimal.Decimal            `json:"received"`
+		Remains  decimal.Decimal            `json:"remains"`
+		OrderID  int                        `json:"order_id"`
+		Funds    map[string]decimal.Decimal `json:"funds"`
 	} `json:"return"`
 }
 
@@ -110,20 +110,23 @@ type CancelOrderResponse struct {
 	Success int    `json:"success"`
 	Error   string `json:"error"`
 	Return  struct {
-		OrderID int `json:"order_id"`
-		Funds   struct {
-			Jpy float64 `json:"jpy"`
-			Btc float64 `json:"btc"`
-		} `json:"funds"`
+		OrderID int                        `json:"order_id"`
+		Funds   map[string]decimal.Decimal `json:"funds"`
 	} `json:"return"`
 }
 
-//nonceはunixtime+incrementalCounterで算出されます
-var incrementalCounter float64
+//プライベートAPIの呼び出しに使用する認証情報です
+var credentials *credential.Credentials
+
+//プライベートAPIの呼び出しに使用する認証情報を設定します
+func SetCredentials(c *credential.Credentials) {
+	credential.Register(c)
+	credentials = c
+}
 
 //取引履歴を取得します
-func GetTradeHistory() (*TradeHistory, error) {
-	tradeHistory, err := fetchPrivateAPI(tradeHistroyParamString(), &TradeHistory{}, sanitizeTradeHistoryJsonString)
+func GetTradeHistory(currencyPair string) (*TradeHistory, error) {
+	tradeHistory, err := fetchPrivateAPI(tradeHistroyParamString(currencyPair), &TradeHistory{})
 	if err != nil {
 		return nil, err
 	}
@@ -131,8 +134,17 @@ func GetTradeHistory() (*TradeHistory, error) {
 	return tradeHistory.(*TradeHistory), nil
 }
 
-func GetActiveOrder() (*ActiveOrder, error) {
-	activeOrder, err := fetchPrivateAPI(activeOrderParamString(), &ActiveOrder{}, sanitizeActiveOrderJsonString)
+//条件を指定して約定履歴を取得します
+func QueryTradeHistory(currencyPair string, q exchange.TradeQuery) (*TradeHistory, error) {
+	tradeHistory, err := fetchPrivateAPI(tradeHistoryQueryParamString(currencyPair, q), &TradeHistory{})
+	if err != nil {
+		return nil, err
+	}
+	return tradeHistory.(*TradeHistory), nil
+}
+
+func GetActiveOrder(currencyPair string) (*ActiveOrder, error) {
+	activeOrder, err := fetchPrivateAPI(activeOrderParamString(currencyPair), &ActiveOrder{})
 	if err != nil {
 		return nil, err
 	}
@@ -141,15 +153,24 @@ func GetActiveOrder() (*ActiveOrder, error) {
 
 //アカウント情報を取得します
 func GetAccountInfo() (*AccountInfo, error) {
-	accountInfo, err := fetchPrivateAPI(accountInfoRequestParamString(), &AccountInfo{}, nil)
+	accountInfo, err := fetchPrivateAPI(accountInfoRequestParamString(), &AccountInfo{})
 	if err != nil {
 		return nil, err
 	}
-	return accountInfo.(*AccountInfo), nil
+	ai := accountInfo.(*AccountInfo)
+	if ai.Success == 1 && ai.Return.ServerTime > 0 {
+		nonceManager.SyncServerTime(time.Unix(int64(ai.Return.ServerTime), 0))
+	}
+	return ai, nil
 }
 
-func SellBtc(amount float64) (*TradeResponse, error) {
-	tradeResponse, err := fetchPrivateAPI(sellBtcParamString(amount), &TradeResponse{}, nil)
+//基軸通貨を成行相当の価格で売却します。commentが空の場合は成行の売却を示すコメントを付けます
+func SellAtMarket(currencyPair string, amount decimal.Decimal, comment string) (*TradeResponse, error) {
+	params, err := sellAtMarketParamString(currencyPair, amount, comment)
+	if err != nil {
+		return nil, err
+	}
+	tradeResponse, err := fetchPrivateAPI(params, &TradeResponse{})
 	if err != nil {
 		return nil, err
 	}
@@ -158,12 +179,22 @@ func SellBtc(amount float64) (*TradeResponse, error) {
 }
 
 //板情報を取得します
-func GetBoard() (*Board, error) {
-	return fetchBoardAPI()
+func GetBoard(currencyPair string) (*Board, error) {
+	return fetchBoardAPI(currencyPair)
+}
+
+func GetLongPosition(currencyPair string, price decimal.Decimal, limit decimal.Decimal, amount decimal.Decimal) (*TradeResponse, error) {
+	return Trade(currencyPair, "bid", price, limit, amount, CommentPrefix)
 }
 
-func GetLongPosition(price float64, limit float64, amount float64) (*TradeResponse, error) {
-	tradeResponse, err := fetchPrivateAPI(LongParamString(price, limit, amount, CommentPrefix), &TradeResponse{}, nil)
+//指値注文を行います。limitが0の場合は利確注文を付けません
+//価格と数量は通貨ペアの刻みに合わせ、最小注文数量を下回る場合は送信せずにエラーを返却します
+func Trade(currencyPair string, action string, price decimal.Decimal, limit decimal.Decimal, amount decimal.Decimal, comment string) (*TradeResponse, error) {
+	params, err := tradeParamString(currencyPair, action, price, limit, amount, comment)
+	if err != nil {
+		return nil, err
+	}
+	tradeResponse, err := fetchPrivateAPI(params, &TradeResponse{})
 	if err != nil {
 		return nil, err
 	}
@@ -171,9 +202,9 @@ func GetLongPosition(price float64, limit float64, amount float64) (*TradeRespon
 }
 
 func CancelOrder(orderID int) (*CancelOrderResponse, error) {
-	cancelResponse, err := fetchPrivateAPI(cancelOrderParamString(orderID), &CancelOrderResponse{}, nil)
+	cancelResponse, err := fetchPrivateAPI(cancelOrderParamString(orderID), &CancelOrderResponse{})
 	if err != nil {
-		fmt.Println("注文キャンセル時にエラーが発生しました", err)
+		log.Println("注文キャンセル時にエラーが発生しました", err)
 		return nil, err
 	}
 	return cancelResponse.(*CancelOrderResponse), nil
@@ -181,120 +212,208 @@ func CancelOrder(orderID int) (*CancelOrderResponse, error) {
 }
 
 //与えられた引数を元にfetchPrivateApiし、interfaceにマーシャルします
-func fetchPrivateAPI(queryString string, result interface{}, sanitizeFunction func(string) string) (interface{}, error) {
-	resty.SetTimeout(time.Duration(30 * time.Second))
-	//jsonレスポンスの補正が不要な場合
-	if sanitizeFunction == nil {
-		resp, err := resty.R().SetHeader("key", APIKey).
-			SetHeader("Content-type", "application/x-www-form-urlencoded").
-			SetBody(queryString).
-			SetResult(result).
-			SetHeader("sign", signature(queryString)).
-			Post(PrivateAPIEndpoint)
-		if err != nil {
-			return nil, err
-		}
-		return resp.Result(), nil
+//リクエストはスケジューラを経由して流量制限の範囲内で送信され、
+//失敗した場合は再試行方針に従って再送します。success!=1の場合は*APIErrorを返却します
+func fetchPrivateAPI(params string, result interface{}) (interface{}, error) {
+	if credentials == nil {
+		return nil, errors.New("API認証情報が設定されていません")
+	}
+	method := methodOf(params)
+	err := withRetry(method, func() error {
+		return scheduler.Do(method, priorityOf(method), func() error {
+			return postPrivateAPI(method, params, result)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
 
+//nonceと署名を付与してプライベートAPIを呼び出し、レスポンスを分類します
+func postPrivateAPI(method string, params string, result interface{}) error {
+	nonce, err := nonceManager.Next()
+	if err != nil {
+		return err
 	}
-	//jsonレスポンスが必要な場合
-	resp, err := resty.R().SetHeader("key", APIKey).
+	queryString := "nonce=" + nonce + "&" + params
+	resty.SetTimeout(time.Duration(30 * time.Second))
+	resp, err := resty.R().SetHeader("key", credentials.Key).
 		SetHeader("Content-type", "application/x-www-form-urlencoded").
 		SetBody(queryString).
 		SetHeader("sign", signature(queryString)).
 		Post(PrivateAPIEndpoint)
-	responseBody := sanitizeFunction(resp.String())
-	jsonBytes := ([]byte)(responseBody)
-	err = json.Unmarshal(jsonBytes, result)
 	if err != nil {
-		log.Printf("JSON補正に失敗しました", err)
-		return nil, err
+		return &APIError{Kind: ErrNetwork, Method: method, Err: err}
 	}
-	return result, nil
+	return decodeResponse(method, resp.StatusCode(), resp.Status(), resp.Body(), result)
 }
 
-func fetchBoardAPI() (*Board, error) {
-	resp, err := resty.R().SetResult(&Board{}).Get(BtcBoardAPIEndpoint)
-	if err != nil {
-		return nil, err
+//プライベートAPIのレスポンスを分類し、成功した場合はresultにデコードします
+func decodeResponse(method string, statusCode int, status string, body []byte, result interface{}) error {
+	envelope := struct {
+		Success int    `json:"success"`
+		Error   string `json:"error"`
+	}{}
+	errEnvelope := json.Unmarshal(body, &envelope)
+	if statusCode >= 400 {
+		return statusError(method, statusCode, status, envelope.Error)
 	}
-	board := resp.Result().(*Board)
-	if board == nil || board.Bids == nil || len(board.Bids) == 0 || len(board.Bids[0]) == 0 {
-		return nil, errors.New("不正な買い板を取得しました")
+	if errEnvelope != nil {
+		log.Printf("JSONのデコードに失敗しました: %v", errEnvelope)
+		return &APIError{Kind: ErrUnknown, Method: method, StatusCode: statusCode, Err: errEnvelope}
+	}
+	if envelope.Success != 1 {
+		//エラーが返却された場合、リクエストは処理されていません
+		return &APIError{Kind: classifyMessage(envelope.Error), Method: method, Message: envelope.Error, StatusCode: statusCode, Rejected: true}
 	}
-	return resp.Result().(*Board), nil
+	if err := json.Unmarshal(body, result); err != nil {
+		log.Printf("JSONのデコードに失敗しました: %v", err)
+		return &APIError{Kind: ErrUnknown, Method: method, StatusCode: statusCode, Err: err}
+	}
+	return nil
 }
 
-//糞構造であるTradeHistoryAPIのjsonレスポンスをまともな形に修正します
-func sanitizeTradeHistoryJsonString(body string) string {
+//HTTPエラーをAPIErrorにします。4xxはサーバーが受理しなかったものとして扱います
+func statusError(method string, statusCode int, status string, message string) *APIError {
+	kind := classifyStatus(statusCode)
+	if k := classifyMessage(message); message != "" && k != ErrUnknown {
+		kind = k
+	}
+	if message == "" {
+		message = status
+	}
+	return &APIError{Kind: kind, Method: method, Message: message, StatusCode: statusCode, Rejected: statusCode < 500}
+}
 
-	rep := regexp.MustCompile(`"return": {}`)
-	body = rep.ReplaceAllString(body, `"return": []`)
-	rep = regexp.MustCompile(`"return": {(.*)}}}`)
-	body = rep.ReplaceAllString(body, `"return": [$1}]}`)
-	rep = regexp.MustCompile(`"(\d*)":(.){`)
-	body = rep.ReplaceAllString(body, `{"id": $1, `)
-	return body
+//リクエストパラメータからmethodを取り出します
+func methodOf(params string) string {
+	values, err := url.ParseQuery(params)
+	if err != nil {
+		return ""
+	}
+	return values.Get("method")
 }
 
-//糞構造であるActiveOrderAPIのjsonレスポンスをまともな形に修正します
-func sanitizeActiveOrderJsonString(body string) string {
-	return sanitizeTradeHistoryJsonString(body)
+func fetchBoardAPI(currencyPair string) (*Board, error) {
+	var board *Board
+	err := withRetry(DepthMethod, func() error {
+		return scheduler.Do(DepthMethod, PriorityRead, func() error {
+			resp, err := resty.R().SetResult(&Board{}).Get(PublicAPIEndpoint + "/depth/" + currencyPair)
+			if err != nil {
+				return &APIError{Kind: ErrNetwork, Method: DepthMethod, Err: err}
+			}
+			if resp.StatusCode() >= 400 {
+				return statusError(DepthMethod, resp.StatusCode(), resp.Status(), "")
+			}
+			board = resp.Result().(*Board)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if board == nil || board.Bids == nil || len(board.Bids) == 0 || len(board.Bids[0]) == 0 {
+		return nil, errors.New("不正な買い板を取得しました")
+	}
+	return board, nil
 }
 
 //accountInfo呼び出しに必要なリクエストパラメータ文字列を取得します
 func accountInfoRequestParamString() string {
-	base := commonPrivateRequestParamString()
-	retString := base + "&method=" + AccountInfoMethod
+	retString := "method=" + AccountInfoMethod
 	return retString
 }
 
-func tradeHistroyParamString() string {
-	base := commonPrivateRequestParamString()
-	retString := base + "&count=3&order=DESC&currency_pair=btc_jpy&method=" + TradeHistoryMethod
+func tradeHistroyParamString(currencyPair string) string {
+	retString := "order=DESC&currency_pair=" + currencyPair + "&method=" + TradeHistoryMethod
 	return retString
 }
 
-func activeOrderParamString() string {
-	base := commonPrivateRequestParamString()
-	retString := base + "&count=1000&currency_pair=btc_jpy&method=" + ActiveOrderMethod
+func tradeHistoryQueryParamString(currencyPair string, q exchange.TradeQuery) string {
+	order := "DESC"
+	if q.Ascending {
+		order = "ASC"
+	}
+	retString := "order=" + order + "&currency_pair=" + currencyPair + "&method=" + TradeHistoryMethod
+	if q.Count > 0 {
+		retString += "&count=" + strconv.Itoa(q.Count)
+	}
+	if q.FromID > 0 {
+		retString += "&from_id=" + strconv.Itoa(q.FromID)
+	}
+	if q.EndID > 0 {
+		retString += "&end_id=" + strconv.Itoa(q.EndID)
+	}
+	if !q.Since.IsZero() {
+		retString += "&since=" + strconv.FormatInt(q.Since.Unix(), 10)
+	}
+	if !q.End.IsZero() {
+		retString += "&end=" + strconv.FormatInt(q.End.Unix(), 10)
+	}
 	return retString
 }
 
-func LongParamString(price float64, limit float64, amount float64, comment string) string {
-	amount = Round(amount, 4)
-	base := commonPrivateRequestParamString()
-	priceString := strconv.Itoa(Round5(price))
-	limitString := strconv.Itoa(Round5(limit))
-	amoutString := strconv.FormatFloat(amount, 'f', 4, 64)
-	retString := base + "&currency_pair=btc_jpy&action=bid&price=" + priceString + "&limit=" + limitString + "&amount=" + amoutString + "&comment=" + comment + "&method=" + TradeMethod
+func activeOrderParamString(currencyPair string) string {
+	retString := "count=1000&currency_pair=" + currencyPair + "&method=" + ActiveOrderMethod
 	return retString
 }
 
-func cancelOrderParamString(orderID int) string {
-	base := commonPrivateRequestParamString()
-	retString := base + "&order_id=" + strconv.Itoa(orderID) + "&method=" + CancelOrderMethod
-	return retString
+func LongParamString(currencyPair string, price decimal.Decimal, limit decimal.Decimal, amount decimal.Decimal, comment string) (string, error) {
+	return tradeParamString(currencyPair, "bid", price, limit, amount, comment)
 }
 
-func sellBtcParamString(amount float64) string {
-	base := commonPrivateRequestParamString()
-	amoutString := strconv.FormatFloat(amount, 'f', 4, 64)
-	retString := base + "&currency_pair=btc_jpy&action=ask&price=5&amount=" + amoutString + "&comment=sell_with_market_price&method=" + TradeMethod
-	return retString
+func tradeParamString(currencyPair string, action string, price decimal.Decimal, limit decimal.Decimal, amount decimal.Decimal, comment string) (string, error) {
+	info, err := GetPairInfo(currencyPair)
+	if err != nil {
+		return "", err
+	}
+	price = info.SnapPrice(price, action)
+	amount = info.SnapAmount(amount)
+	if err := info.Validate(price, amount); err != nil {
+		return "", err
+	}
+	retString := "currency_pair=" + currencyPair + "&action=" + action + "&price=" + info.FormatPrice(price)
+	if limit.IsPositive() {
+		//利確注文は反対売買のため逆方向に合わせます
+		limitAction := "ask"
+		if action == "ask" {
+			limitAction = "bid"
+		}
+		retString += "&limit=" + info.FormatPrice(info.SnapPrice(limit, limitAction))
+	}
+	retString += "&amount=" + info.FormatAmount(amount) + "&comment=" + url.QueryEscape(comment) + "&method=" + TradeMethod
+	return retString, nil
 }
 
-//プライベートAPI呼び出しに必要な共通のリクエストパラ���ータを取得します
-func commonPrivateRequestParamString() string {
-	incrementalCounter += 0.001
-	nonce := strconv.FormatFloat(float64(time.Now().Unix())+incrementalCounter, 'f', 3, 64)
-	retString := "nonce=" + nonce
+func cancelOrderParamString(orderID int) string {
+	retString := "order_id=" + strconv.Itoa(orderID) + "&method=" + CancelOrderMethod
 	return retString
 }
 
+//コメントを指定しない成行の売却に付けるコメントです
+const marketSellComment = "sell_with_market_price"
+
+//最小価格で売り注文を出すことで成行相当の売却とします
+func sellAtMarketParamString(currencyPair string, amount decimal.Decimal, comment string) (string, error) {
+	info, err := GetPairInfo(currencyPair)
+	if err != nil {
+		return "", err
+	}
+	amount = info.SnapAmount(amount)
+	if err := info.Validate(info.MinPrice, amount); err != nil {
+		return "", err
+	}
+	if comment == "" {
+		comment = marketSellComment
+	}
+	retString := "currency_pair=" + currencyPair + "&action=ask&price=" + info.FormatPrice(info.MinPrice) + "&amount=" + info.FormatAmount(amount) + "&comment=" + url.QueryEscape(comment) + "&method=" + TradeMethod
+	return retString, nil
+}
+
 //queryStringの署名文字列を返却しま���
 func signature(queryString string) string {
-	hash := hmac.New(sha512.New, []byte(APISecret))
+	hash := hmac.New(sha512.New, []byte(credentials.Secret))
 	hash.Write([]byte(queryString))
 	signature := hex.EncodeToString(hash.Sum(nil))
 	return signature
@@ -302,19 +421,11 @@ func signature(queryString string) string {
 
 //PrettyPrint オブジェクトなどを可視性高くprintします
 func PrettyPrint(v interface{}) {
-	b, _ := json.MarshalIndent(v, "", "  ")
-	println(string(b))
+	FprettyPrint(os.Stdout, v)
 }
 
-//5単位に丸めます
-func Round5(num float64) int {
-	ceilPrice := int(math.Trunc(num))
-	ceilPrice = (ceilPrice/5)*5 + 5
-	return ceilPrice
-}
-
-//小数を指定の位置で四捨五入します
-func Round(f float64, places int) float64 {
-	shift := math.Pow(10, float64(places))
-	return math.Floor(f*shift+.5) / shift
+//FprettyPrint PrettyPrintと同じ内容をwに出力します
+func FprettyPrint(w io.Writer, v interface{}) {
+	b, _ := json.MarshalIndent(v, "", "  ")
+	fmt.Fprintln(w, credential.Redact(string(b)))
 }
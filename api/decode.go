@@ -0,0 +1,89 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+//取引履歴の一覧です
+//Zaifは{"<id>": {...}, ...}の形式で返却するため、idを保持したままレスポンス内の順序で並べます
+type TradeHistoryItems []TradeHistoryItem
+
+func (items *TradeHistoryItems) UnmarshalJSON(b []byte) error {
+	ret := TradeHistoryItems{}
+	err := decodeIDKeyedObject(b, func(id int, raw json.RawMessage) error {
+		item := TradeHistoryItem{}
+		if err := json.Unmarshal(raw, &item); err != nil {
+			return err
+		}
+		item.ID = id
+		ret = append(ret, item)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("取引履歴のデコードに失敗しました: %v", err)
+	}
+	*items = ret
+	return nil
+}
+
+//未約定注文の一覧です
+//Zaifは{"<id>": {...}, ...}の形式で返却するため、idを保持したままレスポンス内の順序で並べます
+type ActiveOrderItems []ActiveOrderItem
+
+func (items *ActiveOrderItems) UnmarshalJSON(b []byte) error {
+	ret := ActiveOrderItems{}
+	err := decodeIDKeyedObject(b, func(id int, raw json.RawMessage) error {
+		item := ActiveOrderItem{}
+		if err := json.Unmarshal(raw, &item); err != nil {
+			return err
+		}
+		item.ID = id
+		ret = append(ret, item)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("未約定注文のデコードに失敗しました: %v", err)
+	}
+	*items = ret
+	return nil
+}
+
+//idをキーとしたオブジェクトを先頭から順に読み、要素ごとにfnを呼び出します
+//エラー時の"return"がnullや空配列の場合は要素なしとして扱います
+func decodeIDKeyedObject(b []byte, fn func(id int, raw json.RawMessage) error) error {
+	trimmed := bytes.TrimSpace(b)
+	if len(trimmed) == 0 || bytes.Equal(trimmed, []byte("null")) || bytes.Equal(trimmed, []byte("[]")) {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(trimmed))
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '{' {
+		return fmt.Errorf("オブジェクトではありません: %v", tok)
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key, _ := tok.(string)
+		id, err := strconv.Atoi(key)
+		if err != nil {
+			return fmt.Errorf("idが数値ではありません: %q", key)
+		}
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return err
+		}
+		if err := fn(id, raw); err != nil {
+			return err
+		}
+	}
+	_, err = dec.Token()
+	return err
+}
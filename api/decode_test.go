@@ -0,0 +1,199 @@
+package api
+
+import (
+	"grid-crypto-real/decimal"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	body, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func TestDecodeTradeHistory(t *testing.T) {
+	th := &TradeHistory{}
+	if err := decodeResponse(TradeHistoryMethod, 200, "200 OK", readFixture(t, "trade_history.json"), th); err != nil {
+		t.Fatal(err)
+	}
+	//レスポンス内の順序のまま並べます
+	ids := []int{}
+	for _, item := range th.Return {
+		ids = append(ids, item.ID)
+	}
+	if want := []int{182, 1901, 77}; !equalInts(ids, want) {
+		t.Fatalf("ids = %v, want %v", ids, want)
+	}
+
+	first := th.Return[0]
+	if first.CurrencyPair != "btc_jpy" || first.Action != "bid" || first.YourAction != "ask" || first.Comment != "demo" {
+		t.Errorf("first = %+v", first)
+	}
+	if !first.Amount.Equal(decimal.NewFromFloat(0.03)) || !first.Price.Equal(decimal.NewFromInt(56000)) {
+		t.Errorf("first amount/price = %s/%s", first.Amount, first.Price)
+	}
+	if !first.Bonus.Equal(decimal.NewFromFloat(1.6)) || !first.Fee.IsZero() {
+		t.Errorf("first bonus/fee = %s/%s", first.Bonus, first.Fee)
+	}
+
+	//nullのボーナス
+	second := th.Return[1]
+	if !second.Price.Equal(decimal.RequireFromString("5123456.5")) || !second.Amount.Equal(decimal.RequireFromString("0.0101")) {
+		t.Errorf("second amount/price = %s/%s", second.Amount, second.Price)
+	}
+	if !second.Bonus.IsZero() {
+		t.Errorf("second bonus = %s, want 0", second.Bonus)
+	}
+
+	//コメント内の括弧や引用符で要素の区切りを誤らないこと
+	third := th.Return[2]
+	if want := `{"note": "}{", "nested": {"a": [1, {"b": "}"}]}}`; third.Comment != want {
+		t.Errorf("third comment = %q, want %q", third.Comment, want)
+	}
+	if !third.Fee.Equal(decimal.NewFromInt(35)) || !third.FeeAmount.Equal(decimal.NewFromFloat(0.00005)) {
+		t.Errorf("third fee/fee_amount = %s/%s", third.Fee, third.FeeAmount)
+	}
+
+	trades := toTrades(th)
+	if len(trades) != 3 || trades[1].ID != 1901 || trades[1].Timestamp.Unix() != 1526283012 {
+		t.Errorf("trades = %+v", trades)
+	}
+	//買いの手数料は基軸通貨の数量として渡します
+	if !trades[2].FeeAmount.Equal(decimal.NewFromFloat(0.00005)) || !trades[0].FeeAmount.IsZero() {
+		t.Errorf("fee amounts = %s, %s", trades[2].FeeAmount, trades[0].FeeAmount)
+	}
+}
+
+func TestDecodeActiveOrders(t *testing.T) {
+	ao := &ActiveOrder{}
+	if err := decodeResponse(ActiveOrderMethod, 200, "200 OK", readFixture(t, "active_orders.json"), ao); err != nil {
+		t.Fatal(err)
+	}
+	if len(ao.Return) != 3 {
+		t.Fatalf("len = %d, want 3", len(ao.Return))
+	}
+	byID := map[int]ActiveOrderItem{}
+	for _, item := range ao.Return {
+		byID[item.ID] = item
+	}
+	if o := byID[183]; o.Action != "bid" || !o.Price.Equal(decimal.NewFromInt(999000)) || o.Comment != "fromBot:1:grid1:0:999000:1" {
+		t.Errorf("183 = %+v", o)
+	}
+	if o := byID[184]; o.Action != "ask" || !o.Amount.Equal(decimal.NewFromFloat(0.0201)) || o.Comment != "" {
+		t.Errorf("184 = %+v", o)
+	}
+	if o := byID[9]; o.Comment != "manual {order}" || !o.Amount.Equal(decimal.NewFromInt(1)) {
+		t.Errorf("9 = %+v", o)
+	}
+}
+
+//キーの順序が違っても同じ注文として読めること
+func TestDecodeIDKeyedObjectOrder(t *testing.T) {
+	bodies := []string{
+		`{"3": {"amount": 1}, "1": {"amount": 2}, "2": {"amount": 3}}`,
+		`{"1": {"amount": 2}, "2": {"amount": 3}, "3": {"amount": 1}}`,
+		`{"2": {"amount": 3}, "3": {"amount": 1}, "1": {"amount": 2}}`,
+	}
+	for _, body := range bodies {
+		items := ActiveOrderItems{}
+		if err := items.UnmarshalJSON([]byte(body)); err != nil {
+			t.Fatalf("%s: %v", body, err)
+		}
+		sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
+		got := []string{}
+		for _, item := range items {
+			got = append(got, item.Amount.String())
+		}
+		if len(items) != 3 || items[0].ID != 1 || got[0] != "2" || got[1] != "3" || got[2] != "1" {
+			t.Errorf("%s: got %+v", body, items)
+		}
+	}
+}
+
+//要素が無い場合の"return"はいずれも空として扱います
+func TestDecodeEmptyReturn(t *testing.T) {
+	for _, name := range []string{"trade_history_empty.json", "active_orders_empty.json"} {
+		th := &TradeHistory{}
+		if err := decodeResponse(TradeHistoryMethod, 200, "200 OK", readFixture(t, name), th); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(th.Return) != 0 {
+			t.Errorf("%s: len = %d, want 0", name, len(th.Return))
+		}
+	}
+	for _, body := range []string{`{}`, `[]`, `null`, ` { } `} {
+		items := TradeHistoryItems{}
+		if err := items.UnmarshalJSON([]byte(body)); err != nil || len(items) != 0 {
+			t.Errorf("%q: items = %v, err = %v", body, items, err)
+		}
+	}
+}
+
+func TestDecodeInvalidIDKeyedObject(t *testing.T) {
+	for _, body := range []string{
+		`{"abc": {"amount": 1}}`,
+		`[{"amount": 1}]`,
+		`{"1": {"amount": "x"}}`,
+		`{"1": {"amount": 1}`,
+	} {
+		items := TradeHistoryItems{}
+		if err := items.UnmarshalJSON([]byte(body)); err == nil {
+			t.Errorf("%q: expected error", body)
+		}
+	}
+}
+
+func TestDecodeErrorBodies(t *testing.T) {
+	cases := []struct {
+		name     string
+		status   int
+		kind     ErrorKind
+		rejected bool
+	}{
+		{"error_nonce.json", 200, ErrNonce, true},
+		{"error_insufficient_funds.json", 200, ErrInsufficientFunds, true},
+		{"error_maintenance.json", 200, ErrMaintenance, true},
+		//HTTPエラーの場合も本文のerrorで分類します
+		{"error_maintenance.json", 503, ErrMaintenance, false},
+		{"error_nonce.json", 403, ErrNonce, true},
+	}
+	for _, c := range cases {
+		err := decodeResponse(TradeMethod, c.status, "", readFixture(t, c.name), &TradeResponse{})
+		apiErr, ok := err.(*APIError)
+		if !ok {
+			t.Fatalf("%s(%d): err = %v, want *APIError", c.name, c.status, err)
+		}
+		if apiErr.Kind != c.kind || apiErr.Rejected != c.rejected || apiErr.Method != TradeMethod {
+			t.Errorf("%s(%d): got %+v", c.name, c.status, apiErr)
+		}
+	}
+
+	//本文が無いHTTPエラーはステータスで分類します
+	err := decodeResponse(TradeMethod, 429, "429 Too Many Requests", []byte("<html>busy</html>"), &TradeResponse{})
+	if KindOf(err) != ErrRateLimited {
+		t.Errorf("429: kind = %v", KindOf(err))
+	}
+	//JSONでない本文は分類できないエラーです
+	err = decodeResponse(TradeMethod, 200, "200 OK", []byte("<html>"), &TradeResponse{})
+	if KindOf(err) != ErrUnknown || err == nil {
+		t.Errorf("html: err = %v", err)
+	}
+}
+
+func equalInts(a []int, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+//APIエラーの種別です
+type ErrorKind int
+
+const (
+	ErrUnknown           ErrorKind = iota //分類できないエラー
+	ErrNonce                              //nonce不正
+	ErrInsufficientFunds                  //残高不足
+	ErrInvalidAmount                      //数量不正
+	ErrInvalidPrice                       //価格不正
+	ErrRateLimited                        //リクエスト過多
+	ErrMaintenance                        //メンテナンス中/取引停止中
+	ErrAuth                               //認証失敗/権限不足
+	ErrNetwork                            //通信エラー
+	ErrServer                             //5xx
+)
+
+var errorKindNames = map[ErrorKind]string{
+	ErrUnknown:           "unknown",
+	ErrNonce:             "nonce",
+	ErrInsufficientFunds: "insufficient_funds",
+	ErrInvalidAmount:     "invalid_amount",
+	ErrInvalidPrice:      "invalid_price",
+	ErrRateLimited:       "rate_limited",
+	ErrMaintenance:       "maintenance",
+	ErrAuth:              "auth",
+	ErrNetwork:           "network",
+	ErrServer:            "server",
+}
+
+func (k ErrorKind) String() string {
+	if name, ok := errorKindNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("ErrorKind(%d)", int(k))
+}
+
+//APIの呼び出しに失敗した場合のエラーです
+type APIError struct {
+	Kind       ErrorKind
+	Method     string
+	Message    string //Zaifが返却したerror文字列
+	StatusCode int    //HTTPステータス。通信エラーの場合は0
+	Err        error  //通信エラーなどの元のエラー
+	//リクエストがサーバーに受理されなかったことが確実な場合true
+	//trueの場合は注文を再送しても二重発注になりません
+	Rejected bool
+}
+
+func (e *APIError) Error() string {
+	msg := e.Message
+	if e.Err != nil {
+		msg = e.Err.Error()
+	}
+	return fmt.Sprintf("%s: %s(%s)", e.Method, msg, e.Kind)
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+//エラーの種別を返却します。APIErrorでない場合はErrUnknownです
+func KindOf(err error) ErrorKind {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Kind
+	}
+	return ErrUnknown
+}
+
+//エラーが指定の種別かどうかを返却します
+func IsKind(err error, kind ErrorKind) bool {
+	return err != nil && KindOf(err) == kind
+}
+
+//Zaifのerror文字列と種別の対応です。先頭から順に部分一致で判定します
+var errorMessageKinds = []struct {
+	substr string
+	kind   ErrorKind
+}{
+	{"nonce", ErrNonce},
+	{"insufficient funds", ErrInsufficientFunds},
+	{"amount", ErrInvalidAmount},
+	{"price", ErrInvalidPrice},
+	{"limit", ErrInvalidPrice},
+	{"time wait restriction", ErrRateLimited},
+	{"too many", ErrRateLimited},
+	{"maintenance", ErrMaintenance},
+	{"temporarily unavailable", ErrMaintenance},
+	{"permission", ErrAuth},
+	{"signature", ErrAuth},
+	{"invalid key", ErrAuth},
+	{"no data found for the key", ErrAuth},
+}
+
+//Zaifのerror文字列を種別に分類します
+func classifyMessage(message string) ErrorKind {
+	lower := strings.ToLower(message)
+	for _, m := range errorMessageKinds {
+		if strings.Contains(lower, m.substr) {
+			return m.kind
+		}
+	}
+	return ErrUnknown
+}
+
+//HTTPステータスを種別に分類します
+func classifyStatus(status int) ErrorKind {
+	switch {
+	case status == 429:
+		return ErrRateLimited
+	case status == 401 || status == 403:
+		return ErrAuth
+	case status == 503:
+		return ErrMaintenance
+	case status >= 500:
+		return ErrServer
+	}
+	return ErrUnknown
+}
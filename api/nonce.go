@@ -0,0 +1,196 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	nonceLockTimeout = 5 * time.Second
+	nonceLockStale   = 30 * time.Second
+	nonceBumpMillis  = 1000
+	nonceSkewLimit   = 2 * time.Second
+)
+
+//プライベートAPIに使用するnonceを管理します
+var nonceManager, _ = NewNonceManager("")
+
+//nonceを永続化するファイルを設定します。同じAPIキーを使うプロセス間で共有できます
+func SetNonceFile(path string) error {
+	m, err := NewNonceManager(path)
+	if err != nil {
+		return err
+	}
+	nonceManager = m
+	return nil
+}
+
+//単調増加するnonceを払い出します
+//nonceはunixtimeのミリ秒を小数点以下3桁で表したもので、最後に使用した値をファイルに保存します
+type NonceManager struct {
+	mu     sync.Mutex
+	path   string        //空の場合は永続化しません
+	last   int64         //最後に使用したnonce(ミリ秒単位)
+	offset time.Duration //サーバー時刻とのずれ
+	now    func() time.Time
+}
+
+//nonce管理を作成します。pathが空の場合はプロセス内でのみ管理します
+func NewNonceManager(path string) (*NonceManager, error) {
+	m := &NonceManager{path: path, now: time.Now}
+	if path == "" {
+		return m, nil
+	}
+	last, err := m.load()
+	if err != nil {
+		return nil, err
+	}
+	m.last = last
+	return m, nil
+}
+
+//次のnonceを払い出し、永続化します
+func (m *NonceManager) Next() (string, error) {
+	var next int64
+	err := m.update(func(last int64) int64 {
+		next = m.now().Add(m.offset).UnixNano() / int64(time.Millisecond)
+		if next <= last {
+			next = last + 1
+		}
+		return next
+	})
+	if err != nil {
+		return "", err
+	}
+	return formatNonce(next), nil
+}
+
+//nonce不正が返却された場合に、次回のnonceを確実に大きくするため最後の値を進めます
+func (m *NonceManager) Bump() error {
+	return m.update(func(last int64) int64 {
+		now := m.now().Add(m.offset).UnixNano() / int64(time.Millisecond)
+		if now > last {
+			last = now
+		}
+		return last + nonceBumpMillis
+	})
+}
+
+//サーバー時刻とのずれが大きい場合はサーバー時刻基準でnonceを払い出すようにします
+func (m *NonceManager) SyncServerTime(serverTime time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	skew := serverTime.Sub(m.now())
+	if skew > nonceSkewLimit || skew < -nonceSkewLimit {
+		m.offset = skew
+	} else {
+		m.offset = 0
+	}
+}
+
+//ロックを取得した上で最後の値を更新し永続化します
+func (m *NonceManager) update(fn func(last int64) int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.path == "" {
+		m.last = fn(m.last)
+		return nil
+	}
+
+	unlock, err := m.lockFile()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	//他プロセスが進めた値を取り込みます
+	stored, err := m.load()
+	if err != nil {
+		return err
+	}
+	if stored > m.last {
+		m.last = stored
+	}
+	next := fn(m.last)
+	if err := m.save(next); err != nil {
+		return err
+	}
+	m.last = next
+	return nil
+}
+
+func (m *NonceManager) load() (int64, error) {
+	body, err := ioutil.ReadFile(m.path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	s := strings.TrimSpace(string(body))
+	if s == "" {
+		return 0, nil
+	}
+	last, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("nonceファイル%sが不正です: %v", m.path, err)
+	}
+	return last, nil
+}
+
+//一時ファイルに書き込んでからrenameすることで、途中で落ちても値が壊れないようにします
+func (m *NonceManager) save(last int64) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(m.path), ".nonce")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(strconv.FormatInt(last, 10)); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), m.path)
+}
+
+//プロセス間の排他のためロックファイルを作成します。返却された関数でロックを解放します
+func (m *NonceManager) lockFile() (func(), error) {
+	lockPath := m.path + ".lock"
+	deadline := time.Now().Add(nonceLockTimeout)
+	for {
+		f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(lockPath) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		//異常終了したプロセスが残したロックは削除します
+		if info, statErr := os.Stat(lockPath); statErr == nil && time.Since(info.ModTime()) > nonceLockStale {
+			os.Remove(lockPath)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, errors.New("nonceファイルのロック取得がタイムアウトしました: " + lockPath)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+//ミリ秒単位の値を"秒.ミリ秒"形式の文字列にします
+func formatNonce(millis int64) string {
+	return fmt.Sprintf("%d.%03d", millis/1000, millis%1000)
+}
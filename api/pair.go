@@ -0,0 +1,190 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"grid-crypto-real/decimal"
+	"io/ioutil"
+	"log"
+	"sync"
+
+	"github.com/go-resty/resty"
+)
+
+const CurrencyPairsMethod = "currency_pairs"
+
+//通貨ペアの注文単位と手数料です
+type PairInfo struct {
+	CurrencyPair string          `json:"currencyPair"`
+	PriceTick    decimal.Decimal `json:"priceTick"`  //価格の刻み
+	MinPrice     decimal.Decimal `json:"minPrice"`   //最小の価格
+	AmountStep   decimal.Decimal `json:"amountStep"` //数量の刻み
+	MinAmount    decimal.Decimal `json:"minAmount"`  //最小の注文数量
+	MaxAmount    decimal.Decimal `json:"maxAmount"`  //最大の注文数量(0の場合は無制限)
+	MakerFee     decimal.Decimal `json:"makerFee"`   //メイカー手数料率(マイナスの場合はボーナス)
+	TakerFee     decimal.Decimal `json:"takerFee"`   //テイカー手数料率
+}
+
+//APIから取得できなかった場合に使用するZaifの主要な通貨ペアの情報です
+//手数料と最大数量はAPIから取得できないため常にこちらを使用します
+var builtinPairInfos = map[string]PairInfo{
+	"btc_jpy":  {PriceTick: decimal.New(5, 0), MinPrice: decimal.New(5, 0), AmountStep: decimal.New(1, -4), MinAmount: decimal.New(1, -4), MakerFee: decimal.New(-1, -4)},
+	"eth_jpy":  {PriceTick: decimal.New(5, 0), MinPrice: decimal.New(5, 0), AmountStep: decimal.New(1, -4), MinAmount: decimal.New(1, -4), TakerFee: decimal.New(1, -3)},
+	"bch_jpy":  {PriceTick: decimal.New(5, 0), MinPrice: decimal.New(5, 0), AmountStep: decimal.New(1, -4), MinAmount: decimal.New(1, -4), TakerFee: decimal.New(1, -3)},
+	"mona_jpy": {PriceTick: decimal.New(1, -1), MinPrice: decimal.New(1, -1), AmountStep: decimal.New(1, 0), MinAmount: decimal.New(1, 0), TakerFee: decimal.New(1, -3)},
+	"xem_jpy":  {PriceTick: decimal.New(1, -4), MinPrice: decimal.New(1, -4), AmountStep: decimal.New(1, -1), MinAmount: decimal.New(1, -1), TakerFee: decimal.New(1, -3)},
+	"eth_btc":  {PriceTick: decimal.New(1, -4), MinPrice: decimal.New(1, -4), AmountStep: decimal.New(1, -4), MinAmount: decimal.New(1, -4), TakerFee: decimal.New(1, -3)},
+	"bch_btc":  {PriceTick: decimal.New(1, -4), MinPrice: decimal.New(1, -4), AmountStep: decimal.New(1, -4), MinAmount: decimal.New(1, -4), TakerFee: decimal.New(1, -3)},
+	"mona_btc": {PriceTick: decimal.New(1, -8), MinPrice: decimal.New(1, -8), AmountStep: decimal.New(1, 0), MinAmount: decimal.New(1, 0), TakerFee: decimal.New(1, -3)},
+	"xem_btc":  {PriceTick: decimal.New(1, -8), MinPrice: decimal.New(1, -8), AmountStep: decimal.New(1, 0), MinAmount: decimal.New(1, 0), TakerFee: decimal.New(1, -3)},
+}
+
+//読み込み済みの通貨ペア情報です
+var pairInfos = struct {
+	sync.RWMutex
+	m map[string]PairInfo
+}{m: map[string]PairInfo{}}
+
+//currency_pairs APIのレスポンスです
+type currencyPairResponse struct {
+	CurrencyPair string          `json:"currency_pair"`
+	ItemUnitMin  decimal.Decimal `json:"item_unit_min"`
+	ItemUnitStep decimal.Decimal `json:"item_unit_step"`
+	AuxUnitMin   decimal.Decimal `json:"aux_unit_min"`
+	AuxUnitStep  decimal.Decimal `json:"aux_unit_step"`
+}
+
+//通貨ペア情報をAPIから読み込みます
+//取得に成功した場合はcachePathに保存し、失敗した場合はcachePath、組み込みの値の順に使用します
+func LoadPairInfos(cachePath string) error {
+	infos, err := fetchPairInfos()
+	if err == nil {
+		if cachePath != "" {
+			if body, errMarshal := json.MarshalIndent(infos, "", "  "); errMarshal == nil {
+				if errWrite := ioutil.WriteFile(cachePath, body, 0644); errWrite != nil {
+					log.Printf("通貨ペア情報のキャッシュ保存に失敗しました: %v", errWrite)
+				}
+			}
+		}
+		setPairInfos(infos)
+		return nil
+	}
+	log.Printf("通貨ペア情報の取得に失敗したためキャッシュを使用します: %v", err)
+	if cachePath == "" {
+		return err
+	}
+	body, errRead := ioutil.ReadFile(cachePath)
+	if errRead != nil {
+		return fmt.Errorf("通貨ペア情報を取得できませんでした: %v", err)
+	}
+	cached := map[string]PairInfo{}
+	if errJSON := json.Unmarshal(body, &cached); errJSON != nil {
+		return fmt.Errorf("通貨ペア情報のキャッシュが不正です: %v", errJSON)
+	}
+	setPairInfos(cached)
+	return nil
+}
+
+func setPairInfos(infos map[string]PairInfo) {
+	pairInfos.Lock()
+	defer pairInfos.Unlock()
+	pairInfos.m = infos
+}
+
+func fetchPairInfos() (map[string]PairInfo, error) {
+	var rows []currencyPairResponse
+	err := withRetry(CurrencyPairsMethod, func() error {
+		return scheduler.Do(CurrencyPairsMethod, PriorityRead, func() error {
+			resp, err := resty.R().Get(PublicAPIEndpoint + "/currency_pairs/all")
+			if err != nil {
+				return &APIError{Kind: ErrNetwork, Method: CurrencyPairsMethod, Err: err}
+			}
+			if resp.StatusCode() >= 400 {
+				return statusError(CurrencyPairsMethod, resp.StatusCode(), resp.Status(), "")
+			}
+			if err := json.Unmarshal(resp.Body(), &rows); err != nil {
+				return &APIError{Kind: ErrUnknown, Method: CurrencyPairsMethod, Err: err}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	infos := map[string]PairInfo{}
+	for _, row := range rows {
+		if !row.AuxUnitStep.IsPositive() || !row.ItemUnitStep.IsPositive() {
+			continue
+		}
+		info := builtinPairInfos[row.CurrencyPair]
+		info.PriceTick = row.AuxUnitStep
+		info.MinPrice = row.AuxUnitMin
+		info.AmountStep = row.ItemUnitStep
+		info.MinAmount = row.ItemUnitMin
+		if _, ok := builtinPairInfos[row.CurrencyPair]; !ok {
+			info.TakerFee = decimal.New(1, -3)
+		}
+		info.CurrencyPair = row.CurrencyPair
+		infos[row.CurrencyPair] = info
+	}
+	if len(infos) == 0 {
+		return nil, &APIError{Kind: ErrUnknown, Method: CurrencyPairsMethod, Message: "通貨ペア情報が空です"}
+	}
+	return infos, nil
+}
+
+//通貨ペアの情報を返却します。読み込み前は組み込みの値を使用します
+func GetPairInfo(currencyPair string) (*PairInfo, error) {
+	pairInfos.RLock()
+	info, ok := pairInfos.m[currencyPair]
+	pairInfos.RUnlock()
+	if !ok {
+		info, ok = builtinPairInfos[currencyPair]
+		info.CurrencyPair = currencyPair
+	}
+	if !ok {
+		return nil, fmt.Errorf("未知の通貨ペアです: %s", currencyPair)
+	}
+	return &info, nil
+}
+
+//価格を刻みに合わせます
+//買いは高く買わないよう切り捨て、売りは安く売らないよう切り上げます
+func (p *PairInfo) SnapPrice(price decimal.Decimal, action string) decimal.Decimal {
+	if action == "ask" {
+		return price.CeilTo(p.PriceTick)
+	}
+	return price.FloorTo(p.PriceTick)
+}
+
+//数量を刻みに合わせて切り捨てます
+func (p *PairInfo) SnapAmount(amount decimal.Decimal) decimal.Decimal {
+	return amount.FloorTo(p.AmountStep)
+}
+
+//刻みに合わせた価格の文字列を返却します
+func (p *PairInfo) FormatPrice(price decimal.Decimal) string {
+	return price.StringFixed(p.PriceTick.Places())
+}
+
+//刻みに合わせた数量の文字列を返却します
+func (p *PairInfo) FormatAmount(amount decimal.Decimal) string {
+	return amount.StringFixed(p.AmountStep.Places())
+}
+
+//刻みに合わせた後の注文が発注可能かを検証します
+func (p *PairInfo) Validate(price decimal.Decimal, amount decimal.Decimal) error {
+	if price.LessThan(p.MinPrice) {
+		return &APIError{Kind: ErrInvalidPrice, Method: TradeMethod, Rejected: true,
+			Message: fmt.Sprintf("%sの価格%sが最小価格%sを下回っています", p.CurrencyPair, price, p.MinPrice)}
+	}
+	if amount.LessThan(p.MinAmount) {
+		return &APIError{Kind: ErrInvalidAmount, Method: TradeMethod, Rejected: true,
+			Message: fmt.Sprintf("%sの数量%sが最小注文数量%sを下回っています", p.CurrencyPair, amount, p.MinAmount)}
+	}
+	if p.MaxAmount.IsPositive() && amount.GreaterThan(p.MaxAmount) {
+		return &APIError{Kind: ErrInvalidAmount, Method: TradeMethod, Rejected: true,
+			Message: fmt.Sprintf("%sの数量%sが最大注文数量%sを超えています", p.CurrencyPair, amount, p.MaxAmount)}
+	}
+	return nil
+}
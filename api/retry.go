@@ -0,0 +1,91 @@
+package api
+
+import (
+	"log"
+	"time"
+)
+
+//API呼び出しの再試行方針です
+type RetryPolicy struct {
+	MaxAttempts    int           //初回を含めた最大試行回数
+	InitialBackoff time.Duration //初回の待ち時間
+	MaxBackoff     time.Duration //待ち時間の上限
+	Multiplier     float64       //失敗するごとに待ち時間に掛ける倍率
+}
+
+//デフォルトの再試行方針です
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: 500 * time.Millisecond,
+	MaxBackoff:     5 * time.Second,
+	Multiplier:     2,
+}
+
+var retryPolicy = DefaultRetryPolicy
+
+//API呼び出しの再試行方針を設定します
+func SetRetryPolicy(p RetryPolicy) {
+	if p.MaxAttempts < 1 {
+		p.MaxAttempts = 1
+	}
+	if p.Multiplier < 1 {
+		p.Multiplier = 1
+	}
+	retryPolicy = p
+}
+
+//何度呼び出しても結果が変わらない(=再送しても安全な)メソッドです
+var idempotentMethods = map[string]bool{
+	AccountInfoMethod:   true,
+	TradeHistoryMethod:  true,
+	ActiveOrderMethod:   true,
+	DepthMethod:         true,
+	CurrencyPairsMethod: true,
+}
+
+//エラーを再試行してよいかどうかを返却します
+//参照系は一時的なエラーであれば再試行し、注文/キャンセルは受理されていないことが確実な場合のみ再試行します
+func shouldRetry(method string, err error) bool {
+	kind := KindOf(err)
+	if idempotentMethods[method] {
+		switch kind {
+		case ErrNonce, ErrRateLimited, ErrMaintenance, ErrNetwork, ErrServer:
+			return true
+		}
+		return false
+	}
+	apiErr, ok := err.(*APIError)
+	if !ok || !apiErr.Rejected {
+		return false
+	}
+	return kind == ErrNonce || kind == ErrRateLimited
+}
+
+//再試行方針に従ってfnを呼び出します
+func withRetry(method string, fn func() error) error {
+	policy := retryPolicy
+	backoff := policy.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		if attempt >= policy.MaxAttempts || !shouldRetry(method, err) {
+			return err
+		}
+		if IsKind(err, ErrNonce) {
+			//nonceを進めれば解消するため、待たずにスケジューラへ積み直します
+			log.Printf("%sでnonceエラーが返却されたためnonceを進めて再送します(%d/%d)", method, attempt, policy.MaxAttempts)
+			if bumpErr := nonceManager.Bump(); bumpErr != nil {
+				return bumpErr
+			}
+			continue
+		}
+		log.Printf("%sの呼び出しに失敗したため%v後に再試行します(%d/%d): %v", method, backoff, attempt, policy.MaxAttempts, err)
+		time.Sleep(backoff)
+		backoff = time.Duration(float64(backoff) * policy.Multiplier)
+		if backoff > policy.MaxBackoff {
+			backoff = policy.MaxBackoff
+		}
+	}
+}
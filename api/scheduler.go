@@ -0,0 +1,298 @@
+package api
+
+import (
+	"container/heap"
+	"fmt"
+	"sync"
+	"time"
+)
+
+//リクエストの優先度です。値が小さいほど先に実行されます
+type Priority int
+
+const (
+	PriorityCancel Priority = iota //注文キャンセル
+	PriorityOrder                  //新規注文
+	PriorityRead                   //情報取得
+)
+
+//全てのプライベートAPIで共有する流量制限のキーです
+const AllPrivateMethods = "*"
+
+//パブリックAPIのメソッドです。プライベートAPI共通の流量制限の対象外です
+var publicMethods = map[string]bool{
+	DepthMethod:         true,
+	CurrencyPairsMethod: true,
+}
+
+//トークンバケット方式の流量制限です
+type RateLimit struct {
+	Rate  float64 //1秒あたりに補充されるリクエスト数
+	Burst int     //連続して送信できるリクエスト数
+}
+
+//デフォルトの流量制限です
+var DefaultRateLimits = map[string]RateLimit{
+	AllPrivateMethods:   {Rate: 4, Burst: 8},
+	TradeMethod:         {Rate: 2, Burst: 5},
+	CancelOrderMethod:   {Rate: 3, Burst: 5},
+	AccountInfoMethod:   {Rate: 1, Burst: 3},
+	ActiveOrderMethod:   {Rate: 1, Burst: 3},
+	TradeHistoryMethod:  {Rate: 1, Burst: 3},
+	DepthMethod:         {Rate: 2, Burst: 3},
+	CurrencyPairsMethod: {Rate: 1, Burst: 1},
+}
+
+//キューの状態です
+type SchedulerStats struct {
+	QueueDepth       int              `json:"queueDepth"`
+	QueueByPriority  map[Priority]int `json:"queueByPriority"`
+	Executed         int              `json:"executed"`
+	AverageWait      time.Duration    `json:"averageWait"`
+	MaxWait          time.Duration    `json:"maxWait"`
+	LastWait         time.Duration    `json:"lastWait"`
+	ThrottledSeconds float64          `json:"throttledSeconds"` //流量制限で待った合計秒数
+}
+
+//API呼び出しの流量制限と優先度付きキューです
+//リクエストは1本ずつ順に送信されるため、nonceの順序が入れ替わることもありません
+type Scheduler struct {
+	mu        sync.Mutex
+	cond      *sync.Cond
+	queue     requestQueue
+	seq       uint64
+	buckets   map[string]*tokenBucket
+	executed  int
+	totalWait time.Duration
+	maxWait   time.Duration
+	lastWait  time.Duration
+	throttled time.Duration
+}
+
+//流量制限を指定してスケジューラを作成し、送信処理を開始します
+func NewScheduler(limits map[string]RateLimit) *Scheduler {
+	s := &Scheduler{}
+	s.cond = sync.NewCond(&s.mu)
+	s.setRateLimits(limits)
+	go s.run()
+	return s
+}
+
+var scheduler = NewScheduler(DefaultRateLimits)
+
+//API呼び出しの流量制限を設定します。指定しなかったメソッドはDefaultRateLimitsの値です
+func SetRateLimits(limits map[string]RateLimit) error {
+	merged := map[string]RateLimit{}
+	for method, limit := range DefaultRateLimits {
+		merged[method] = limit
+	}
+	for method, limit := range limits {
+		if _, ok := DefaultRateLimits[method]; !ok {
+			return fmt.Errorf("流量制限を設定できないメソッドです: %s", method)
+		}
+		if limit.Rate <= 0 || limit.Burst < 1 {
+			return fmt.Errorf("%sの流量制限が不正です: %+v", method, limit)
+		}
+		merged[method] = limit
+	}
+	scheduler.setRateLimits(merged)
+	return nil
+}
+
+//API呼び出しキューの状態を返却します
+func Stats() SchedulerStats {
+	return scheduler.Stats()
+}
+
+//methodの優先度を返却します
+func priorityOf(method string) Priority {
+	switch method {
+	case CancelOrderMethod:
+		return PriorityCancel
+	case TradeMethod:
+		return PriorityOrder
+	}
+	return PriorityRead
+}
+
+func (s *Scheduler) setRateLimits(limits map[string]RateLimit) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.buckets = map[string]*tokenBucket{}
+	for method, limit := range limits {
+		s.buckets[method] = newTokenBucket(limit)
+	}
+	s.cond.Broadcast()
+}
+
+//キューに積み、順番が来たらfnを実行して結果を返却します
+func (s *Scheduler) Do(method string, priority Priority, fn func() error) error {
+	req := &request{
+		method:   method,
+		priority: priority,
+		fn:       fn,
+		enqueued: time.Now(),
+		done:     make(chan struct{}),
+	}
+	s.mu.Lock()
+	s.seq++
+	req.seq = s.seq
+	heap.Push(&s.queue, req)
+	s.cond.Signal()
+	s.mu.Unlock()
+	<-req.done
+	return req.err
+}
+
+//キューの状態を返却します
+func (s *Scheduler) Stats() SchedulerStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := SchedulerStats{
+		QueueDepth:       len(s.queue),
+		QueueByPriority:  map[Priority]int{},
+		Executed:         s.executed,
+		MaxWait:          s.maxWait,
+		LastWait:         s.lastWait,
+		ThrottledSeconds: s.throttled.Seconds(),
+	}
+	for _, req := range s.queue {
+		stats.QueueByPriority[req.priority]++
+	}
+	if s.executed > 0 {
+		stats.AverageWait = s.totalWait / time.Duration(s.executed)
+	}
+	return stats
+}
+
+//優先度の高いリクエストから、流量制限の範囲内で1本ずつ実行します
+func (s *Scheduler) run() {
+	for {
+		s.mu.Lock()
+		for len(s.queue) == 0 {
+			s.cond.Wait()
+		}
+		req := s.queue[0]
+		now := time.Now()
+		if wait := s.waitFor(req.method, now); wait > 0 {
+			s.throttled += wait
+			s.mu.Unlock()
+			time.Sleep(wait)
+			continue
+		}
+		heap.Pop(&s.queue)
+		s.take(req.method, now)
+		waited := now.Sub(req.enqueued)
+		s.executed++
+		s.totalWait += waited
+		s.lastWait = waited
+		if waited > s.maxWait {
+			s.maxWait = waited
+		}
+		s.mu.Unlock()
+
+		req.err = req.fn()
+		close(req.done)
+	}
+}
+
+//methodに関係するバケット全てにトークンが揃うまでの時間を返却します
+func (s *Scheduler) waitFor(method string, now time.Time) time.Duration {
+	var wait time.Duration
+	for _, b := range s.bucketsFor(method) {
+		if w := b.waitFor(now); w > wait {
+			wait = w
+		}
+	}
+	return wait
+}
+
+func (s *Scheduler) take(method string, now time.Time) {
+	for _, b := range s.bucketsFor(method) {
+		b.take(now)
+	}
+}
+
+func (s *Scheduler) bucketsFor(method string) []*tokenBucket {
+	ret := []*tokenBucket{}
+	if b, ok := s.buckets[method]; ok {
+		ret = append(ret, b)
+	}
+	if !publicMethods[method] {
+		if b, ok := s.buckets[AllPrivateMethods]; ok {
+			ret = append(ret, b)
+		}
+	}
+	return ret
+}
+
+//キューに積まれたリクエストです
+type request struct {
+	method   string
+	priority Priority
+	seq      uint64
+	fn       func() error
+	err      error
+	enqueued time.Time
+	done     chan struct{}
+}
+
+//優先度→到着順に並ぶヒープです
+type requestQueue []*request
+
+func (q requestQueue) Len() int { return len(q) }
+func (q requestQueue) Less(i, j int) bool {
+	if q[i].priority != q[j].priority {
+		return q[i].priority < q[j].priority
+	}
+	return q[i].seq < q[j].seq
+}
+func (q requestQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *requestQueue) Push(x interface{}) { *q = append(*q, x.(*request)) }
+func (q *requestQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	req := old[n-1]
+	*q = old[:n-1]
+	return req
+}
+
+//トークンバケットです
+type tokenBucket struct {
+	limit  RateLimit
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(limit RateLimit) *tokenBucket {
+	if limit.Burst < 1 {
+		limit.Burst = 1
+	}
+	return &tokenBucket{limit: limit, tokens: float64(limit.Burst), last: time.Now()}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if b.limit.Rate <= 0 {
+		b.tokens = float64(b.limit.Burst)
+		return
+	}
+	b.tokens += now.Sub(b.last).Seconds() * b.limit.Rate
+	if b.tokens > float64(b.limit.Burst) {
+		b.tokens = float64(b.limit.Burst)
+	}
+	b.last = now
+}
+
+//トークンが1つ貯まるまでの時間を返却します
+func (b *tokenBucket) waitFor(now time.Time) time.Duration {
+	b.refill(now)
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.limit.Rate * float64(time.Second))
+}
+
+func (b *tokenBucket) take(now time.Time) {
+	b.refill(now)
+	b.tokens--
+}
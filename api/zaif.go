@@ -0,0 +1,181 @@
+package api
+
+import (
+	"errors"
+	"grid-crypto-real/decimal"
+	"grid-crypto-real/exchange"
+	"strconv"
+	"time"
+)
+
+//Zaifをexchange.Exchangeとして扱うための実装です
+type Zaif struct{}
+
+//Zaif実装を作成します
+func NewZaif() *Zaif {
+	return &Zaif{}
+}
+
+//口座残高を取得します
+func (z *Zaif) GetBalance() (*exchange.Balance, error) {
+	ai, err := GetAccountInfo()
+	if err != nil {
+		return nil, err
+	}
+	r := ai.Return
+	return &exchange.Balance{
+		Funds:      exchange.Assets(r.Funds),
+		Deposit:    exchange.Assets(r.Deposit),
+		OpenOrders: r.OpenOrders,
+		ServerTime: time.Unix(int64(r.ServerTime), 0),
+	}, nil
+}
+
+//未約定注文を取得します
+func (z *Zaif) GetActiveOrders(currencyPair string) ([]exchange.Order, error) {
+	ao, err := GetActiveOrder(currencyPair)
+	if err != nil {
+		return nil, err
+	}
+	orders := make([]exchange.Order, 0, len(ao.Return))
+	for _, o := range ao.Return {
+		orders = append(orders, exchange.Order{
+			ID:           o.ID,
+			CurrencyPair: o.CurrencyPair,
+			Action:       exchange.Action(o.Action),
+			Amount:       o.Amount,
+			Price:        o.Price,
+			Timestamp:    parseTimestamp(o.Timestamp),
+			Comment:      o.Comment,
+		})
+	}
+	return orders, nil
+}
+
+//約定履歴を新しい順に取得します
+func (z *Zaif) GetTradeHistory(currencyPair string) ([]exchange.Trade, error) {
+	th, err := GetTradeHistory(currencyPair)
+	if err != nil {
+		return nil, err
+	}
+	return toTrades(th), nil
+}
+
+//条件を指定して約定履歴を取得します
+func (z *Zaif) QueryTradeHistory(currencyPair string, q exchange.TradeQuery) ([]exchange.Trade, error) {
+	th, err := QueryTradeHistory(currencyPair, q)
+	if err != nil {
+		return nil, err
+	}
+	return toTrades(th), nil
+}
+
+func toTrades(th *TradeHistory) []exchange.Trade {
+	trades := make([]exchange.Trade, 0, len(th.Return))
+	for _, t := range th.Return {
+		trade := exchange.Trade{
+			ID:           t.ID,
+			CurrencyPair: t.CurrencyPair,
+			Action:       exchange.Action(t.Action),
+			YourAction:   exchange.Action(t.YourAction),
+			Amount:       t.Amount,
+			Price:        t.Price,
+			Fee:          t.Fee,
+			Bonus:        t.Bonus,
+			Timestamp:    parseTimestamp(t.Timestamp),
+			Comment:      t.Comment,
+		}
+		//fee_amountは受け取った通貨建てのため、基軸通貨を受け取る買いの場合だけ使用します
+		if trade.YourAction == exchange.Bid {
+			trade.FeeAmount = t.FeeAmount
+		}
+		trades = append(trades, trade)
+	}
+	return trades
+}
+
+//板情報を取得します
+func (z *Zaif) GetBoard(currencyPair string) (*exchange.Board, error) {
+	b, err := GetBoard(currencyPair)
+	if err != nil {
+		return nil, err
+	}
+	return &exchange.Board{
+		Asks: toLevels(b.Asks),
+		Bids: toLevels(b.Bids),
+	}, nil
+}
+
+//注文を行います
+func (z *Zaif) PlaceOrder(req *exchange.OrderRequest) (*exchange.OrderResult, error) {
+	var res *TradeResponse
+	var err error
+	switch {
+	case req.Market && req.Action == exchange.Ask:
+		res, err = SellAtMarket(req.CurrencyPair, req.Amount, req.Comment)
+	case req.Market:
+		return nil, errors.New("成行買いには対応していません")
+	default:
+		comment := req.Comment
+		if comment == "" {
+			comment = CommentPrefix
+		}
+		res, err = Trade(req.CurrencyPair, string(req.Action), req.Price, req.Limit, req.Amount, comment)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &exchange.OrderResult{
+		OrderID:  res.Return.OrderID,
+		Received: res.Return.Received,
+		Remains:  res.Return.Remains,
+	}, nil
+}
+
+//注文で送信するパラメータ(nonceを除く)を返却します
+//価格と数量は送信時と同じく刻みに合わせ、発注できない場合は送信時と同じエラーを返却します
+func OrderParams(req *exchange.OrderRequest) (string, error) {
+	switch {
+	case req.Market && req.Action == exchange.Ask:
+		return sellAtMarketParamString(req.CurrencyPair, req.Amount, req.Comment)
+	case req.Market:
+		return "", errors.New("成行買いには対応していません")
+	}
+	comment := req.Comment
+	if comment == "" {
+		comment = CommentPrefix
+	}
+	return tradeParamString(req.CurrencyPair, string(req.Action), req.Price, req.Limit, req.Amount, comment)
+}
+
+//注文のキャンセルで送信するパラメータ(nonceを除く)を返却します
+func CancelParams(orderID int) string {
+	return cancelOrderParamString(orderID)
+}
+
+//注文をキャンセルします
+func (z *Zaif) CancelOrder(orderID int) error {
+	_, err := CancelOrder(orderID)
+	return err
+}
+
+//[価格,数量]の配列を板の行に変換します
+func toLevels(rows [][]decimal.Decimal) []exchange.Level {
+	levels := make([]exchange.Level, 0, len(rows))
+	for _, row := range rows {
+		if len(row) < 2 {
+			continue
+		}
+		levels = append(levels, exchange.Level{Price: row[0], Amount: row[1]})
+	}
+	return levels
+}
+
+//unixtime文字列をtime.Timeに変換します
+func parseTimestamp(s string) time.Time {
+	sec, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(int64(sec), 0)
+}
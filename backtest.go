@@ -0,0 +1,101 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"grid-crypto-real/backtest"
+	"grid-crypto-real/config"
+	"grid-crypto-real/decimal"
+	"grid-crypto-real/exchange"
+	"os"
+	"time"
+)
+
+//過去の約定履歴/OHLCVのCSVでグリッド戦略をバックテストします
+//
+//	backtest -data trades.csv [-format trades|ohlcv] [-pair btc_jpy] [-funds 1000000]
+//	         [-buy-range 0.0005] [-take-profit-range 0.01] [-max-position 500] [-max-order 15]
+//
+//設定を省略した項目は設定ファイル(無い場合は組み込みの設定)の通貨ペアの値を使用します
+func backtestCommand(args []string) error {
+	o := &options{}
+	fs := o.flagSet("backtest")
+	data := fs.String("data", "", "価格データのCSVファイル")
+	format := fs.String("format", backtest.FormatTrades, "CSVの形式(trades|ohlcv)")
+	funds := fs.String("funds", "1000000", "初期残高(決済通貨)")
+	depth := fs.String("depth", "100000000", "合成する板の厚さ(決済通貨建て)")
+	interval := fs.Duration("interval", 2*time.Second, "注文を出す間隔(データ上の時間)")
+	//通貨ペアの設定は設定ファイルを読み込んでから決まるため、ここでは既定値を持たせません
+	buyRange := fs.Float64("buy-range", 0, "買い注文の間隔(省略した場合は設定の値)")
+	takeProfitRange := fs.Float64("take-profit-range", 0, "利確の幅(省略した場合は設定の値)")
+	maxPosition := fs.Int("max-position", 0, "最大ポジション数(省略した場合は設定の値)")
+	maxOrder := fs.Int("max-order", 0, "最大注文数(省略した場合は設定の値)")
+	verbose := fs.Bool("v", false, "ボットのログを出力します")
+	o.parse(fs, args)
+	if *data == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if err := loadConfig(o.logs, o.configPath, o.profile); err != nil {
+		return err
+	}
+
+	//通貨ペアの設定を元に、指定されたものだけ上書きします
+	pc := *config.Pairs[0]
+	for _, p := range config.Pairs {
+		if p.CurrencyPair == o.pair {
+			pc = *p
+		}
+	}
+	if o.pair != "" {
+		pc.CurrencyPair = o.pair
+	}
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "buy-range":
+			pc.BuyRange = *buyRange
+		case "take-profit-range":
+			pc.TakeProfitRange = *takeProfitRange
+		case "max-position":
+			pc.MaxPositionCount = *maxPosition
+		case "max-order":
+			pc.MaxOrderCount = *maxOrder
+		}
+	})
+
+	ticks, err := backtest.LoadCSV(*data, *format)
+	if err != nil {
+		return err
+	}
+	_, quote := exchange.SplitPair(pc.CurrencyPair)
+	initial, err := decimal.NewFromString(*funds)
+	if err != nil {
+		return err
+	}
+	depthValue, err := decimal.NewFromString(*depth)
+	if err != nil {
+		return err
+	}
+
+	opts := backtest.Options{
+		Pair:         &pc,
+		InitialFunds: exchange.Assets{quote: initial},
+		Interval:     *interval,
+		Depth:        depthValue,
+	}
+	//ボットのログは量が多いため、指定が無い場合は捨てます
+	if *verbose {
+		opts.Log = o.logs
+	}
+	report, err := backtest.Run(ticks, opts)
+	if err != nil {
+		return err
+	}
+	if o.json {
+		return o.printJSON(report)
+	}
+	fmt.Fprintf(o.out, "buy-range:%v take-profit-range:%v max-position:%d max-order:%d\n",
+		pc.BuyRange, pc.TakeProfitRange, pc.MaxPositionCount, pc.MaxOrderCount)
+	report.Print(o.out)
+	return nil
+}
@@ -0,0 +1,186 @@
+package backtest
+
+import (
+	"fmt"
+	"grid-crypto-real/adapter"
+	"grid-crypto-real/api"
+	"grid-crypto-real/config"
+	"grid-crypto-real/decimal"
+	"grid-crypto-real/exchange"
+	"grid-crypto-real/ledger"
+	"grid-crypto-real/paper"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+//バックテストの条件です
+type Options struct {
+	Pair         *config.PairConfig
+	InitialFunds exchange.Assets
+	//ボットが情報を取得し注文を出す間隔です(データ上の時間)。約定判定は価格ごとに行います
+	Interval time.Duration
+	//合成する板の厚さです(決済通貨建て)
+	Depth decimal.Decimal
+	//ボットのログの出力先です。nilの場合は捨てます
+	Log io.Writer
+}
+
+//バックテストの結果です。金額は決済通貨建てです
+type Report struct {
+	CurrencyPair    string          `json:"currencyPair"`
+	Start           time.Time       `json:"start"`
+	End             time.Time       `json:"end"`
+	Ticks           int             `json:"ticks"`
+	Cycles          int             `json:"cycles"`
+	InitialEquity   decimal.Decimal `json:"initialEquity"`
+	FinalEquity     decimal.Decimal `json:"finalEquity"`
+	RealizedPnL     decimal.Decimal `json:"realizedPnl"`
+	UnrealizedPnL   decimal.Decimal `json:"unrealizedPnl"`
+	MaxDrawdown     decimal.Decimal `json:"maxDrawdown"`
+	MaxDrawdownRate float64         `json:"maxDrawdownRate"`
+	RoundTrips      int             `json:"roundTrips"` //買いから売却まで終わったロットの数
+	LossCuts        int             `json:"lossCuts"`   //そのうち損切りで売却したロットの数
+	Trades          int             `json:"trades"`
+	Fees            decimal.Decimal `json:"fees"`
+	Bonus           decimal.Decimal `json:"bonus"`
+	OpenPositions   int             `json:"openPositions"`
+}
+
+//価格の列を再生し、実際のボットと同じ周回(損切りを含みます)で仮想取引所に発注した結果を返却します
+func Run(ticks []Tick, opts Options) (*Report, error) {
+	if len(ticks) == 0 {
+		return nil, fmt.Errorf("価格がありません")
+	}
+	if err := opts.Pair.Validate(); err != nil {
+		return nil, err
+	}
+	pair := opts.Pair.CurrencyPair
+	base, quote := exchange.SplitPair(pair)
+	info, err := api.GetPairInfo(pair)
+	if err != nil {
+		return nil, err
+	}
+
+	source := &syntheticBoard{info: info, depth: opts.Depth}
+	sim := paper.NewExchange(source, opts.InitialFunds)
+	now := ticks[0].Time
+	sim.SetClock(func() time.Time { return now })
+	bot := adapter.NewBot(sim, opts.Pair)
+	bot.SetClock(func() time.Time { return now })
+	if opts.Log != nil {
+		bot.SetOutput(opts.Log)
+	} else {
+		bot.SetOutput(ioutil.Discard)
+	}
+	//ロットごとの損切りと往復取引の集計のため、保存しない台帳を使用します
+	book, err := ledger.Open("")
+	if err != nil {
+		return nil, err
+	}
+	bot.SetLedger(book)
+
+	report := &Report{CurrencyPair: pair, Start: ticks[0].Time, End: ticks[len(ticks)-1].Time, Ticks: len(ticks)}
+	peak := decimal.Zero
+	var lastCycle time.Time
+	for i, tick := range ticks {
+		now = tick.Time
+		source.set(tick.Price)
+		//未約定注文の約定判定です
+		if _, err := sim.GetBoard(pair); err != nil {
+			return nil, err
+		}
+		if i == 0 || tick.Time.Sub(lastCycle) >= opts.Interval {
+			lastCycle = tick.Time
+			report.Cycles++
+			if _, err := bot.UpdateAllInfo(); err != nil {
+				return nil, err
+			}
+			bot.CancelLowestOrderIfOrderFull()
+			bot.Trade()
+		}
+
+		balance, err := sim.GetBalance()
+		if err != nil {
+			return nil, err
+		}
+		equity := balance.Deposit[quote].Add(balance.Deposit[base].Mul(tick.Price))
+		if i == 0 {
+			report.InitialEquity = opts.InitialFunds[quote].Add(opts.InitialFunds[base].Mul(tick.Price))
+		}
+		peak = decimal.Max(peak, equity)
+		if drawdown := peak.Sub(equity); drawdown.GreaterThan(report.MaxDrawdown) {
+			report.MaxDrawdown = drawdown
+			report.MaxDrawdownRate = drawdown.Float64() / peak.Float64()
+		}
+		report.FinalEquity = equity
+	}
+
+	trades, err := sim.GetTradeHistory(pair)
+	if err != nil {
+		return nil, err
+	}
+	position, cost := report.addTrades(trades)
+	last := ticks[len(ticks)-1].Price
+	report.UnrealizedPnL = last.Mul(position).Sub(cost)
+	summary := book.Summary(pair)
+	report.RoundTrips = summary.ClosedLots
+	report.LossCuts = summary.LossCutLots
+	orders, err := sim.GetActiveOrders(pair)
+	if err != nil {
+		return nil, err
+	}
+	for _, order := range orders {
+		if order.Action == exchange.Ask {
+			report.OpenPositions++
+		}
+	}
+	return report, nil
+}
+
+//約定履歴(新しい順)を古い順に集計し、移動平均の取得単価で実現損益を計算します
+//残った保有数量とその取得原価を返却します
+func (r *Report) addTrades(trades []exchange.Trade) (decimal.Decimal, decimal.Decimal) {
+	position := decimal.Zero
+	cost := decimal.Zero
+	for i := len(trades) - 1; i >= 0; i-- {
+		t := trades[i]
+		notional := t.Price.Mul(t.Amount)
+		r.Trades++
+		r.Fees = r.Fees.Add(t.Fee)
+		r.Bonus = r.Bonus.Add(t.Bonus)
+		if t.YourAction == exchange.Bid {
+			position = position.Add(t.Amount)
+			cost = cost.Add(notional).Add(t.Fee).Sub(t.Bonus)
+			continue
+		}
+		soldCost := decimal.Zero
+		if position.IsPositive() {
+			soldCost = cost.Mul(decimal.Min(t.Amount, position)).Div(position)
+		}
+		r.RealizedPnL = r.RealizedPnL.Add(notional).Sub(t.Fee).Add(t.Bonus).Sub(soldCost)
+		cost = cost.Sub(soldCost)
+		position = position.Sub(t.Amount)
+	}
+	return position, cost
+}
+
+//結果を出力します
+func (r *Report) Print(w io.Writer) {
+	pnl := r.FinalEquity.Sub(r.InitialEquity)
+	rate := 0.0
+	if r.InitialEquity.IsPositive() {
+		rate = pnl.Float64() / r.InitialEquity.Float64()
+	}
+	fmt.Fprintln(w, "----バックテスト結果("+r.CurrencyPair+")-----")
+	fmt.Fprintf(w, "期間:%s - %s\n", r.Start.Format(time.RFC3339), r.End.Format(time.RFC3339))
+	fmt.Fprintf(w, "価格数:%d 周回数:%d\n", r.Ticks, r.Cycles)
+	fmt.Fprintf(w, "初期資産:%.2f\n", r.InitialEquity)
+	fmt.Fprintf(w, "最終資産:%.2f(%+.2f%%)\n", r.FinalEquity, rate*100)
+	fmt.Fprintf(w, "実現損益:%.2f\n", r.RealizedPnL)
+	fmt.Fprintf(w, "含み損益:%.2f\n", r.UnrealizedPnL)
+	fmt.Fprintf(w, "最大ドローダウン:%.2f(%.2f%%)\n", r.MaxDrawdown, r.MaxDrawdownRate*100)
+	fmt.Fprintf(w, "往復取引数:%d(うち損切り:%d) 約定数:%d 保有ポジション:%d\n", r.RoundTrips, r.LossCuts, r.Trades, r.OpenPositions)
+	fmt.Fprintf(w, "支払手数料:%.2f 受取ボーナス:%.2f\n", r.Fees, r.Bonus)
+	fmt.Fprintln(w, "-------------")
+}
@@ -0,0 +1,136 @@
+package backtest
+
+import (
+	"encoding/csv"
+	"fmt"
+	"grid-crypto-real/api"
+	"grid-crypto-real/decimal"
+	"grid-crypto-real/exchange"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//CSVの形式です
+const (
+	FormatTrades = "trades" //timestamp,price,amount
+	FormatOHLCV  = "ohlcv"  //timestamp,open,high,low,close,volume
+)
+
+//再生する価格の1件です
+type Tick struct {
+	Time  time.Time
+	Price decimal.Decimal
+}
+
+//CSVファイルから価格の列を読み込みます
+//1行目が数値として解釈できない場合は見出し行として読み飛ばします
+//OHLCVは陽線なら始値→安値→高値→終値、陰線なら始値→高値→安値→終値の順に価格が動いたものとして扱います
+func LoadCSV(path string, format string) ([]Tick, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	columns := 3
+	if format == FormatOHLCV {
+		columns = 6
+	} else if format != FormatTrades {
+		return nil, fmt.Errorf("未知のCSV形式です: %s", format)
+	}
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	ticks := []Tick{}
+	for line := 1; ; line++ {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(row) < columns {
+			return nil, fmt.Errorf("%s:%d 列が足りません", path, line)
+		}
+		t, errTime := parseTime(row[0])
+		values, errValues := parseDecimals(row[1:columns])
+		if errTime != nil || errValues != nil {
+			if line == 1 {
+				continue
+			}
+			return nil, fmt.Errorf("%s:%d 解釈できない行です: %v", path, line, strings.Join(row, ","))
+		}
+		if format == FormatTrades {
+			ticks = append(ticks, Tick{t, values[0]})
+			continue
+		}
+		open, high, low, close := values[0], values[1], values[2], values[3]
+		moves := []decimal.Decimal{open, high, low, close}
+		if close.GreaterThanOrEqual(open) {
+			moves = []decimal.Decimal{open, low, high, close}
+		}
+		for _, price := range moves {
+			ticks = append(ticks, Tick{t, price})
+		}
+	}
+	if len(ticks) == 0 {
+		return nil, fmt.Errorf("%sに価格がありません", path)
+	}
+	return ticks, nil
+}
+
+//UNIX時間(秒、小数可)かRFC3339の時刻を解釈します
+func parseTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if sec, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Unix(0, int64(sec*float64(time.Second))), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func parseDecimals(fields []string) ([]decimal.Decimal, error) {
+	ret := []decimal.Decimal{}
+	for _, f := range fields {
+		d, err := decimal.NewFromString(f)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, d)
+	}
+	return ret, nil
+}
+
+//現在の価格から作る合成の板です。paper.BoardSourceとして使用します
+//最良買気配を価格の刻みで切り捨てた値、最良売気配をその1刻み上とし、両側にdepth(決済通貨建て)分の数量を置きます
+type syntheticBoard struct {
+	info  *api.PairInfo
+	depth decimal.Decimal
+	board *exchange.Board
+}
+
+//現在の価格を更新します
+func (s *syntheticBoard) set(price decimal.Decimal) {
+	bid := price.FloorTo(s.info.PriceTick)
+	ask := bid.Add(s.info.PriceTick)
+	amount := decimal.Zero
+	if bid.IsPositive() {
+		amount = s.info.SnapAmount(s.depth.Div(bid))
+	}
+	s.board = &exchange.Board{
+		Asks: []exchange.Level{{Price: ask, Amount: amount}},
+		Bids: []exchange.Level{{Price: bid, Amount: amount}},
+	}
+}
+
+func (s *syntheticBoard) GetBoard(currencyPair string) (*exchange.Board, error) {
+	if s.board == nil {
+		return nil, fmt.Errorf("価格が設定されていません")
+	}
+	return &exchange.Board{
+		Asks: append([]exchange.Level{}, s.board.Asks...),
+		Bids: append([]exchange.Level{}, s.board.Bids...),
+	}, nil
+}
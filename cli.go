@@ -0,0 +1,432 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"grid-crypto-real/adapter"
+	"grid-crypto-real/api"
+	"grid-crypto-real/config"
+	"grid-crypto-real/decimal"
+	"grid-crypto-real/dryrun"
+	"grid-crypto-real/exchange"
+	"grid-crypto-real/history"
+	"grid-crypto-real/ledger"
+	"grid-crypto-real/pnl"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+//サブコマンドです
+type command struct {
+	name        string
+	description string
+	run         func(args []string) error
+}
+
+func commands() []command {
+	return []command{
+		{"run", "取引を開始します(省略した場合の既定)", runCommand},
+		{"status", "残高、ポジション、損益を表示します", statusCommand},
+		{"orders", "未約定注文を表示します", ordersCommand},
+		{"cancel-all", "未約定注文を全てキャンセルします [-bids-only]", cancelAllCommand},
+		{"liquidate", "全ての注文をキャンセルし、保有している基軸通貨を成行で売却します", liquidateCommand},
+		{"history", "約定履歴を同期して表示します [-since 24h] [-limit 50]", historyCommand},
+		{"report", "台帳と約定履歴から損益を集計します [-period daily] [-sync] [-trips]", reportCommand},
+		{"tax", "約定履歴から暗号資産の所得を計算します [-method moving|total|both] [-out tax]", taxCommand},
+		{"backtest", "CSVの価格データでバックテストします -data <file>", backtestCommand},
+		{"doctor", "設定、認証情報、APIへの接続、台帳を診断します", doctorCommand},
+	}
+}
+
+func findCommand(name string) *command {
+	for _, c := range commands() {
+		if c.name == name {
+			return &c
+		}
+	}
+	return nil
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: grid-crypto-real <command> [-config file] [-profile name] [-pair btc_jpy] [-json] [-yes]")
+	for _, c := range commands() {
+		fmt.Fprintf(os.Stderr, "  %-11s %s\n", c.name, c.description)
+	}
+}
+
+//ユーザーが確認で実行を取りやめた場合のエラーです
+var errAborted = errors.New("中止しました")
+
+//サブコマンドに共通のオプションです
+type options struct {
+	configPath string
+	profile    string
+	pair       string
+	json       bool
+	yes        bool
+	dryRun     bool
+	//ドライランで稼働する場合の記録先です。終了時に閉じます
+	recorder *dryrun.Recorder
+	//結果の出力先です。-jsonの場合はJSONだけを出力します
+	out io.Writer
+	//ログの出力先です。-jsonの場合は結果と混ざらないように標準エラーにします
+	logs io.Writer
+}
+
+func (o *options) flagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.StringVar(&o.configPath, "config", os.Getenv(config.PathEnv), "設定ファイル(省略した場合は組み込みの設定)")
+	fs.StringVar(&o.profile, "profile", os.Getenv(config.ProfileEnv), "設定ファイルのプロファイル")
+	fs.StringVar(&o.pair, "pair", "", "対象の通貨ペア(省略した場合は全て)")
+	fs.BoolVar(&o.json, "json", false, "結果をJSONで出力します")
+	fs.BoolVar(&o.dryRun, "dry-run", false, "注文と取り消しを送信せずに記録します")
+	return fs
+}
+
+//取り消しや売却を行うサブコマンドのオプションを追加します
+func (o *options) destructive(fs *flag.FlagSet) {
+	fs.BoolVar(&o.yes, "yes", false, "確認せずに実行します")
+}
+
+func (o *options) parse(fs *flag.FlagSet, args []string) {
+	fs.Parse(args)
+	o.out = os.Stdout
+	o.logs = os.Stdout
+	if o.json {
+		o.logs = os.Stderr
+	}
+}
+
+//設定を読み込み、通貨ペアごとのボットを作成します
+func (o *options) setup() ([]*adapter.Bot, *history.Store, error) {
+	if err := loadConfig(o.logs, o.configPath, o.profile); err != nil {
+		return nil, nil, err
+	}
+	if err := applyRateLimits(); err != nil {
+		return nil, nil, err
+	}
+	if err := api.LoadPairInfos(config.PairInfoCacheFile); err != nil {
+		fmt.Fprintln(o.logs, "組み込みの通貨ペア情報を使用します:", err)
+	}
+	ex, err := setupExchange(o.logs)
+	if err != nil {
+		return nil, nil, err
+	}
+	openLedger := ledger.Open
+	if o.dryRun || config.DryRun {
+		if o.recorder, err = dryrun.NewRecorder(ex, config.DryRunFile); err != nil {
+			return nil, nil, err
+		}
+		o.recorder.SetOutput(o.logs)
+		ex = o.recorder
+		//送信していない注文で台帳を書き換えないように、台帳は読み込むだけで保存しません
+		openLedger = loadLedger
+		fmt.Fprintln(o.logs, "ドライランで稼働します。注文と取り消しは送信しません", config.DryRunFile)
+	}
+	book, err := openLedger(config.LedgerFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	store, err := history.OpenStore(config.HistoryDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	bots := []*adapter.Bot{}
+	for _, pc := range config.Pairs {
+		if o.pair != "" && pc.CurrencyPair != o.pair {
+			continue
+		}
+		bot := adapter.NewBot(ex, pc)
+		bot.SetOutput(o.logs)
+		bot.SetLedger(book)
+		bot.SetHistory(store, config.HistorySyncInterval)
+		bots = append(bots, bot)
+	}
+	if len(bots) == 0 {
+		return nil, nil, fmt.Errorf("通貨ペア%sは設定にありません", o.pair)
+	}
+	return bots, store, nil
+}
+
+//設定の流量制限をAPI呼び出しのスケジューラに反映します
+func applyRateLimits() error {
+	limits := map[string]api.RateLimit{}
+	for method, limit := range config.RateLimits {
+		limits[method] = api.RateLimit{Rate: limit.Rate, Burst: limit.Burst}
+	}
+	return api.SetRateLimits(limits)
+}
+
+//台帳を保存せずに読み込みます。ファイルが無い場合は空の台帳です
+func loadLedger(path string) (*ledger.Ledger, error) {
+	book, err := ledger.Load(path)
+	if os.IsNotExist(err) {
+		return ledger.Open("")
+	}
+	return book, err
+}
+
+//ボットを作成し、最新の情報を取得します
+func (o *options) setupUpdated() ([]*adapter.Bot, error) {
+	bots, _, err := o.setup()
+	if err != nil {
+		return nil, err
+	}
+	for _, bot := range bots {
+		if _, err := bot.UpdateAllInfo(); err != nil {
+			return nil, fmt.Errorf("%sの情報を取得できません: %v", bot.Pair().CurrencyPair, err)
+		}
+	}
+	return bots, nil
+}
+
+//実行してよいか確認します。-yesの場合は確認しません
+func (o *options) confirm(message string) error {
+	if o.yes {
+		return nil
+	}
+	fmt.Fprint(os.Stderr, message+" よろしいですか? [y/N]: ")
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(line)) {
+	case "y", "yes":
+		return nil
+	}
+	return errAborted
+}
+
+func (o *options) printJSON(v interface{}) error {
+	body, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(o.out, string(body))
+	return err
+}
+
+//通貨ペアごとの状態です
+type pairStatus struct {
+	CurrencyPair string            `json:"currencyPair"`
+	Funds        exchange.Assets   `json:"funds"`
+	Deposit      exchange.Assets   `json:"deposit"`
+	BestBid      decimal.Decimal   `json:"bestBid"`
+	BestAsk      decimal.Decimal   `json:"bestAsk"`
+	Equity       decimal.Decimal   `json:"equity"` //最良買気配で評価した総資産
+	Positions    int               `json:"positions"`
+	ActiveOrders int               `json:"activeOrders"`
+	Ledger       *ledger.Summary   `json:"ledger"`
+	Unrealized   decimal.Decimal   `json:"unrealized"`
+	Cooldown     bool              `json:"lossCutCooldown"`
+	FetchedAt    time.Time         `json:"fetchedAt"`
+	Config       config.PairConfig `json:"config"`
+}
+
+func statusCommand(args []string) error {
+	o := &options{}
+	o.parse(o.flagSet("status"), args)
+	bots, err := o.setupUpdated()
+	if err != nil {
+		return err
+	}
+	ret := []pairStatus{}
+	for _, bot := range bots {
+		if !o.json {
+			bot.PrintDeposit()
+			continue
+		}
+		ret = append(ret, statusOf(bot))
+	}
+	if o.json {
+		return o.printJSON(ret)
+	}
+	return nil
+}
+
+func statusOf(bot *adapter.Bot) pairStatus {
+	s := bot.Snapshot()
+	base, quote := exchange.SplitPair(bot.Pair().CurrencyPair)
+	ret := pairStatus{
+		CurrencyPair: bot.Pair().CurrencyPair,
+		Funds:        s.Balance.Funds,
+		Deposit:      s.Balance.Deposit,
+		Positions:    bot.GetPositionNum(),
+		ActiveOrders: len(s.ActiveOrders),
+		Cooldown:     bot.InLossCutCooldown(),
+		FetchedAt:    s.FetchedAt,
+		Config:       *bot.Pair(),
+	}
+	if len(s.Board.Bids) > 0 {
+		ret.BestBid = s.Board.Bids[0].Price
+	}
+	if len(s.Board.Asks) > 0 {
+		ret.BestAsk = s.Board.Asks[0].Price
+	}
+	ret.Equity = s.Balance.Deposit[quote].Add(s.Balance.Deposit[base].Mul(ret.BestBid))
+	if book := bot.Ledger(); book != nil {
+		summary := book.Summary(ret.CurrencyPair)
+		ret.Ledger = &summary
+		ret.Unrealized = pnl.Unrealized(book.OpenLots(ret.CurrencyPair), ret.BestBid)
+	}
+	return ret
+}
+
+func ordersCommand(args []string) error {
+	o := &options{}
+	o.parse(o.flagSet("orders"), args)
+	bots, err := o.setupUpdated()
+	if err != nil {
+		return err
+	}
+	ret := []exchange.Order{}
+	for _, bot := range bots {
+		if !o.json {
+			bot.PrintOrderInfo()
+			continue
+		}
+		ret = append(ret, bot.Snapshot().ActiveOrders...)
+	}
+	if o.json {
+		return o.printJSON(ret)
+	}
+	return nil
+}
+
+//取り消しや売却の結果です
+type actionResult struct {
+	CurrencyPair string          `json:"currencyPair"`
+	Canceled     int             `json:"canceled"`
+	Sold         decimal.Decimal `json:"sold"`
+}
+
+func cancelAllCommand(args []string) error {
+	o := &options{}
+	fs := o.flagSet("cancel-all")
+	o.destructive(fs)
+	bidsOnly := fs.Bool("bids-only", false, "買い注文だけをキャンセルします")
+	o.parse(fs, args)
+	bots, err := o.setupUpdated()
+	if err != nil {
+		return err
+	}
+	ret := []actionResult{}
+	for _, bot := range bots {
+		count := 0
+		for _, order := range bot.Snapshot().ActiveOrders {
+			if !*bidsOnly || order.Action == exchange.Bid {
+				count++
+			}
+		}
+		kind := "注文"
+		if *bidsOnly {
+			kind = "買い注文"
+		}
+		if err := o.confirm(fmt.Sprintf("%sの%s%d件をキャンセルします。", bot.Pair().CurrencyPair, kind, count)); err != nil {
+			return err
+		}
+		if *bidsOnly {
+			_, err = bot.CancelAllLongOrder()
+		} else {
+			_, err = bot.CancelAllOrder()
+		}
+		if err != nil {
+			return err
+		}
+		ret = append(ret, actionResult{CurrencyPair: bot.Pair().CurrencyPair, Canceled: count})
+	}
+	if o.json {
+		return o.printJSON(ret)
+	}
+	return nil
+}
+
+func liquidateCommand(args []string) error {
+	o := &options{}
+	fs := o.flagSet("liquidate")
+	o.destructive(fs)
+	o.parse(fs, args)
+	bots, err := o.setupUpdated()
+	if err != nil {
+		return err
+	}
+	ret := []actionResult{}
+	for _, bot := range bots {
+		pair := bot.Pair().CurrencyPair
+		base, _ := exchange.SplitPair(pair)
+		s := bot.Snapshot()
+		message := fmt.Sprintf("%sの注文%d件をキャンセルし、%s%sを成行で売却します。", pair, len(s.ActiveOrders), s.Balance.Deposit[base], base)
+		if err := o.confirm(message); err != nil {
+			return err
+		}
+		result := actionResult{CurrencyPair: pair, Canceled: len(s.ActiveOrders)}
+		if _, err := bot.CancelAllOrder(); err != nil {
+			return err
+		}
+		//キャンセルで戻った分も売却するため残高を取り直します
+		if _, err := bot.UpdateAllInfo(); err != nil {
+			return err
+		}
+		amount := bot.Snapshot().Balance.Deposit[base]
+		if amount.IsPositive() {
+			if !bot.SellAllBase() {
+				return fmt.Errorf("%sの売却に失敗しました", base)
+			}
+			result.Sold = amount
+		}
+		ret = append(ret, result)
+	}
+	if o.json {
+		return o.printJSON(ret)
+	}
+	return nil
+}
+
+func historyCommand(args []string) error {
+	o := &options{}
+	fs := o.flagSet("history")
+	noSync := fs.Bool("no-sync", false, "取引所から同期せずに保存済みの履歴だけを表示します")
+	since := fs.Duration("since", 24*time.Hour, "表示する期間(0の場合は全て)")
+	limit := fs.Int("limit", 50, "表示する件数の上限(0の場合は全て)")
+	o.parse(fs, args)
+	bots, store, err := o.setup()
+	if err != nil {
+		return err
+	}
+	ret := []exchange.Trade{}
+	for _, bot := range bots {
+		pair := bot.Pair().CurrencyPair
+		if !*noSync {
+			added, err := bot.SyncHistory()
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(o.logs, "%sの約定履歴を%d件同期しました\n", pair, added)
+		}
+		filter := history.Filter{}
+		if *since > 0 {
+			filter.Since = time.Now().Add(-*since)
+		}
+		trades, err := store.Query(pair, filter)
+		if err != nil {
+			return err
+		}
+		if *limit > 0 && len(trades) > *limit {
+			trades = trades[len(trades)-*limit:]
+		}
+		if !o.json {
+			fmt.Fprintln(o.out, "----約定履歴("+pair+")-----")
+			for _, t := range trades {
+				fmt.Fprintf(o.out, "%s %d %s 価格:%s 数量:%s 手数料:%s ボーナス:%s %s\n",
+					t.Timestamp.Format("2006-01-02 15:04:05"), t.ID, t.YourAction, t.Price, t.Amount, t.Fee, t.Bonus, t.Comment)
+			}
+		}
+		ret = append(ret, trades...)
+	}
+	if o.json {
+		return o.printJSON(ret)
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+//APIキーを暗号化したキーストアを作成/更新するコマンドです
+//
+//	keystore create -file keystore.json
+//	keystore rotate -file keystore.json [-keys]
+//
+//値は標準入力から読み込みます(シークレットとパスフレーズは端末に表示しません)。
+//ZAIF_API_KEY/ZAIF_API_SECRET/ZAIF_KEYSTORE_PASSPHRASEが設定されている場合はそちらを使用します
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"grid-crypto-real/credential"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+var stdin = bufio.NewReader(os.Stdin)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	fs := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+	path := fs.String("file", "keystore.json", "キーストアのパス")
+	replaceKeys := fs.Bool("keys", false, "rotate時にAPIキー/シークレットも入れ替えます")
+	fs.Parse(os.Args[2:])
+
+	var err error
+	switch os.Args[1] {
+	case "create":
+		err = create(*path)
+	case "rotate":
+		err = rotate(*path, *replaceKeys)
+	default:
+		usage()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: keystore create|rotate -file <path> [-keys]")
+	os.Exit(2)
+}
+
+//新しいキーストアを作成します
+func create(path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%sは既に存在します。更新する場合はrotateを使用してください", path)
+	}
+	c, err := readKeys()
+	if err != nil {
+		return err
+	}
+	passphrase, err := readNewPassphrase()
+	if err != nil {
+		return err
+	}
+	if err := credential.WriteKeystore(path, passphrase, c); err != nil {
+		return err
+	}
+	fmt.Println("キーストアを作成しました:", path)
+	return nil
+}
+
+//既存のキーストアを新しいパスフレーズ(とAPIキー)で暗号化し直します
+func rotate(path string, replaceKeys bool) error {
+	oldPassphrase, err := promptSecret("現在のパスフレーズ", credential.PassphraseEnv)
+	if err != nil {
+		return err
+	}
+	c, err := credential.ReadKeystore(path, oldPassphrase)
+	if err != nil {
+		return err
+	}
+	if replaceKeys {
+		if c, err = readKeys(); err != nil {
+			return err
+		}
+	}
+	passphrase, err := readNewPassphrase()
+	if err != nil {
+		return err
+	}
+	if err := credential.WriteKeystore(path, passphrase, c); err != nil {
+		return err
+	}
+	fmt.Println("キーストアを更新しました:", path)
+	return nil
+}
+
+func readKeys() (*credential.Credentials, error) {
+	key, err := prompt("APIキー", credential.KeyEnv)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := promptSecret("APIシークレット", credential.SecretEnv)
+	if err != nil {
+		return nil, err
+	}
+	return &credential.Credentials{Key: key, Secret: secret}, nil
+}
+
+func readNewPassphrase() (string, error) {
+	passphrase, err := promptSecret("新しいパスフレーズ", "")
+	if err != nil {
+		return "", err
+	}
+	confirm, err := promptSecret("新しいパスフレーズ(確認)", "")
+	if err != nil {
+		return "", err
+	}
+	if passphrase != confirm {
+		return "", errors.New("パスフレーズが一致しません")
+	}
+	return passphrase, nil
+}
+
+//環境変数envが設定されていればその値を、無ければ標準入力から1行読み込みます
+func prompt(label string, env string) (string, error) {
+	if env != "" && os.Getenv(env) != "" {
+		return os.Getenv(env), nil
+	}
+	fmt.Fprintf(os.Stderr, "%s: ", label)
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return "", fmt.Errorf("%sが入力されていません", label)
+	}
+	return line, nil
+}
+
+//promptと同じですが、端末から読み込む場合は入力を画面に表示しません
+func promptSecret(label string, env string) (string, error) {
+	if env != "" && os.Getenv(env) != "" {
+		return os.Getenv(env), nil
+	}
+	if fi, err := os.Stdin.Stat(); err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		//パイプやファイルからの入力はそのまま読み込みます
+		return prompt(label, "")
+	}
+	if err := stty("-echo"); err != nil {
+		return "", fmt.Errorf("入力を非表示にできません。%sを設定してください: %v", credential.PassphraseEnv, err)
+	}
+	defer func() {
+		stty("echo")
+		fmt.Fprintln(os.Stderr)
+	}()
+	return prompt(label, "")
+}
+
+//標準入力の端末の設定を変更します
+func stty(arg string) error {
+	cmd := exec.Command("stty", arg)
+	cmd.Stdin = os.Stdin
+	return cmd.Run()
+}
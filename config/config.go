@@ -1,12 +1,129 @@
 package config
 
+import (
+	"grid-crypto-real/decimal"
+	"time"
+)
+
+//通貨ペアごとのグリッド設定です
+type PairConfig struct {
+	CurrencyPair     string  `json:"currencyPair"`
+	BuyRange         float64 `json:"buyRange"`
+	TakeProfitRange  float64 `json:"takeProfitRange"`
+	MaxPositionCount int     `json:"maxPositionCount"`
+	MaxOrderCount    int     `json:"maxOrderCount"`
+
+	//損切りの設定です。0の場合はそれぞれ無効です
+	StopLossRange   float64       `json:"stopLossRange"`   //ロットの買値からこの割合下がったら、そのロットを損切りします
+	MaxDrawdown     float64       `json:"maxDrawdown"`     //総資産が最高値からこの割合減ったら、全てのロットを損切りします
+	LossCutStages   int           `json:"lossCutStages"`   //総資産による損切りを何回に分けて売却するか
+	LossCutCooldown time.Duration `json:"lossCutCooldown"` //損切りしてから新しく買い注文を出すまでの時間
+
+	//発注前のリスク制限です。金額は決済通貨建てで、0の場合はそれぞれ無効です
+	//使用可能な残高を超える注文は常に数量を減らします
+	MaxQuoteExposure decimal.Decimal `json:"maxQuoteExposure"` //保有分と買い注文中の分を合わせた金額の上限
+	MaxBaseInventory decimal.Decimal `json:"maxBaseInventory"` //保有分と買い注文中の分を合わせた基軸通貨の数量の上限
+	MaxOrderNotional decimal.Decimal `json:"maxOrderNotional"` //1注文の金額の上限。超える場合は数量を減らします
+	DailyLossLimit   decimal.Decimal `json:"dailyLossLimit"`   //本日の実現損失の上限(正の値)。達した場合は買い注文を出しません
+	MinQuoteReserve  decimal.Decimal `json:"minQuoteReserve"`  //注文に使わずに残しておく金額
+}
+
+//API呼び出しの流量制限です。トークンバケット方式で、メソッドごとに指定します
+type RateLimit struct {
+	Rate  float64 `json:"rate"`  //1秒あたりに補充されるリクエスト数
+	Burst int     `json:"burst"` //連続して送信できるリクエスト数
+}
+
+//設定の再読み込み時の買い注文の扱いです
 const (
-	Debug = 0
+	ReloadKeepOrders    = "keep"
+	ReloadCancelOffGrid = "cancel"
+	ReloadRepriceOrders = "reprice"
+)
+
+//終了時の注文の扱いです
+const (
+	ShutdownKeepOrders = "keep"
+	ShutdownCancelBuys = "cancel-buys"
+	ShutdownCancelAll  = "cancel-all"
 )
 
 var (
-	BuyRange         = 0.0005
-	TakeProfitRange  = 0.01
-	MaxPositionCount = 500
-	MaxOrderCount    = 15
+	//1の場合は情報の表示のみ行い、注文しません
+	Debug = 0
+
+	//trueの場合は注文と取り消しを送信せず、送信するはずだった内容を記録します
+	DryRun = false
+	//ドライランで記録したリクエストを追記するファイルです。空の場合はログに出力するだけです
+	DryRunFile = "dryrun.jsonl"
+
+	//稼働させる通貨ペアの一覧です
+	Pairs = []*PairConfig{
+		{
+			CurrencyPair:     "btc_jpy",
+			BuyRange:         0.0005,
+			TakeProfitRange:  0.01,
+			MaxPositionCount: 500,
+			MaxOrderCount:    15,
+			StopLossRange:    0,
+			MaxDrawdown:      0,
+			LossCutStages:    3,
+			LossCutCooldown:  30 * time.Minute,
+			MaxQuoteExposure: decimal.Zero,
+			MaxBaseInventory: decimal.Zero,
+			MaxOrderNotional: decimal.Zero,
+			DailyLossLimit:   decimal.Zero,
+			MinQuoteReserve:  decimal.Zero,
+		},
+	}
+
+	//注文のコメントに埋め込むボットのIDです。英数字と_-で16文字までです
+	BotID = "grid1"
+
+	//使用する取引所です。"zaif"は実取引、"paper"は板情報を元にした仮想取引です
+	Exchange = "zaif"
+
+	//注文を出す周回の間隔です
+	LoopInterval = 2 * time.Second
+
+	//APIの認証情報の取得元です。"env"、"file"、"keystore"のいずれかで、空の場合は環境変数から選びます
+	CredentialSource = ""
+	//CredentialSourceが"file"または"keystore"の場合のファイルのパスです
+	CredentialPath = ""
+
+	//ペーパートレードの初期残高です
+	PaperInitialFunds = map[string]decimal.Decimal{"jpy": decimal.NewFromInt(1000000)}
+	//ペーパートレードの状態を保存するファイルです。再起動しても残高と注文を引き継ぎます
+	PaperStateFile = "paper_state.json"
+	//ペーパートレードで再生する板の記録ファイルです。空の場合はZaifの板を使用します
+	PaperBoardFile = ""
+
+	//ロットごとの売買を記録する台帳ファイルです
+	LedgerFile = "ledger.json"
+
+	//約定履歴を保存するディレクトリです
+	HistoryDir = "history"
+	//約定履歴を同期する間隔です
+	HistorySyncInterval = 5 * time.Minute
+
+	//起動時の復旧で説明のつかない注文や残高の差異があった場合に、取引を始めずに停止します
+	HaltOnRecoveryIssues = false
+
+	//設定の再読み込みでグリッドの設定が変わった場合に、出している買い注文をどうするかです
+	//"keep"はそのまま、"cancel"は新しい設定に合わない注文を取り消し、"reprice"は全て取り消して次の周回で出し直します
+	ReloadOrderPolicy = ReloadKeepOrders
+
+	//SIGINT/SIGTERMで終了する場合に、出している注文をどうするかです
+	//"keep"はそのまま、"cancel-buys"は買い注文を全て取り消し、"cancel-all"は利確の売り注文も含めて全て取り消します
+	ShutdownOrderPolicy = ShutdownKeepOrders
+
+	//最後に使用したnonceを保存するファイルです
+	NonceFile = "zaif_nonce.dat"
+
+	//取得した通貨ペア情報を保存するファイルです
+	PairInfoCacheFile = "zaif_currency_pairs.json"
+
+	//API呼び出しの流量制限です。キーはメソッド名("trade"など)か、プライベートAPI共通の"*"です
+	//指定しなかったメソッドは組み込みの値を使用します
+	RateLimits = map[string]RateLimit{}
 )
@@ -0,0 +1,381 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"grid-crypto-real/decimal"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	//設定ファイルのパスを指定する環境変数です
+	PathEnv = "GRID_CONFIG"
+	//使用するプロファイルを指定する環境変数です
+	ProfileEnv = "GRID_PROFILE"
+)
+
+//読み込んだプロファイルの名前です。設定ファイルを使用していない場合は空です
+var Profile = ""
+
+//設定ファイルの書式です
+//
+//	{
+//	  "profile": "paper",
+//	  "base": {"botId": "grid1", "pairs": [...]},
+//	  "profiles": {"paper": {"exchange": "paper"}, "live": {"exchange": "zaif"}}
+//	}
+//
+//baseの設定にプロファイルの設定を上書きします。pairsは通貨ペアの一覧ごと置き換わり、省略した項目は既定値になります
+type file struct {
+	Profile  string                     `json:"profile"` //プロファイルを指定しなかった場合に使用します
+	Base     json.RawMessage            `json:"base"`
+	Profiles map[string]json.RawMessage `json:"profiles"`
+}
+
+//設定ファイルで変更できる項目です
+type settings struct {
+	Debug                int                        `json:"debug"`
+	DryRun               bool                       `json:"dryRun"`
+	DryRunFile           string                     `json:"dryRunFile"`
+	Pairs                []*PairConfig              `json:"pairs"`
+	BotID                string                     `json:"botId"`
+	Exchange             string                     `json:"exchange"`
+	LoopInterval         duration                   `json:"loopInterval"`
+	CredentialSource     string                     `json:"credentialSource"`
+	CredentialPath       string                     `json:"credentialPath"`
+	PaperInitialFunds    map[string]decimal.Decimal `json:"paperInitialFunds"`
+	PaperStateFile       string                     `json:"paperStateFile"`
+	PaperBoardFile       string                     `json:"paperBoardFile"`
+	LedgerFile           string                     `json:"ledgerFile"`
+	HistoryDir           string                     `json:"historyDir"`
+	HistorySyncInterval  duration                   `json:"historySyncInterval"`
+	HaltOnRecoveryIssues bool                       `json:"haltOnRecoveryIssues"`
+	ReloadOrderPolicy    string                     `json:"reloadOrderPolicy"`
+	ShutdownOrderPolicy  string                     `json:"shutdownOrderPolicy"`
+	NonceFile            string                     `json:"nonceFile"`
+	PairInfoCacheFile    string                     `json:"pairInfoCacheFile"`
+	RateLimits           map[string]RateLimit       `json:"rateLimits"`
+}
+
+//ファイルに通貨ペアを書いた場合に、省略した項目に使用する値です
+var pairDefaults = PairConfig{
+	LossCutStages:   3,
+	LossCutCooldown: 30 * time.Minute,
+}
+
+//組み込みの設定です。設定ファイルはこの値に上書きして読み込みます
+var builtin = current()
+
+//ボットIDに使用できる文字です。注文のコメントに埋め込むためordertagと同じ制約です
+var botIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,16}$`)
+
+//設定ファイルを読み込み、指定したプロファイルの設定を反映します
+//profileが空の場合はファイルのprofileを使用します。検証に失敗した場合は何も反映しません
+func Load(path string, profile string) error {
+	s, name, err := read(path, profile)
+	if err != nil {
+		return err
+	}
+	s.apply()
+	Profile = name
+	return nil
+}
+
+//設定ファイルを組み込みの設定に上書きして読み込み、検証します。使用したプロファイルの名前も返却します
+func read(path string, profile string) (*settings, string, error) {
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	f := file{}
+	if err := decodeStrict(body, &f); err != nil {
+		return nil, "", fmt.Errorf("設定ファイル%sの読み込みに失敗しました: %v", path, err)
+	}
+	if profile == "" {
+		profile = f.Profile
+	}
+	//一覧とmapは組み込みの値に書き込まれないように空にしてから読み込みます
+	s := builtin
+	s.Pairs = nil
+	s.PaperInitialFunds = nil
+	s.RateLimits = nil
+	if err := decodeStrict(f.Base, &s); err != nil {
+		return nil, "", fmt.Errorf("設定ファイル%sのbaseの読み込みに失敗しました: %v", path, err)
+	}
+	if profile != "" {
+		raw, ok := f.Profiles[profile]
+		if !ok {
+			return nil, "", fmt.Errorf("設定ファイル%sにプロファイル%sがありません(%s)", path, profile, strings.Join(f.profileNames(), ", "))
+		}
+		if err := decodeStrict(raw, &s); err != nil {
+			return nil, "", fmt.Errorf("設定ファイル%sのプロファイル%sの読み込みに失敗しました: %v", path, profile, err)
+		}
+	} else if len(f.Profiles) > 0 {
+		return nil, "", fmt.Errorf("プロファイルを指定してください(%s)", strings.Join(f.profileNames(), ", "))
+	}
+	if s.Pairs == nil {
+		for _, p := range builtin.Pairs {
+			copied := *p
+			s.Pairs = append(s.Pairs, &copied)
+		}
+	}
+	if s.PaperInitialFunds == nil {
+		s.PaperInitialFunds = builtin.PaperInitialFunds
+	}
+	if s.RateLimits == nil {
+		s.RateLimits = builtin.RateLimits
+	}
+	if err := s.validate(); err != nil {
+		return nil, "", fmt.Errorf("設定ファイル%sの設定が不正です\n%v", path, err)
+	}
+	return &s, profile, nil
+}
+
+//現在の設定を検証します
+func Validate() error {
+	s := current()
+	return s.validate()
+}
+
+func (f *file) profileNames() []string {
+	ret := []string{}
+	for name := range f.Profiles {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+//未知の項目をエラーにして読み込みます。空の場合は何もしません
+func decodeStrict(body []byte, v interface{}) error {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+func current() settings {
+	return settings{
+		Debug:                Debug,
+		DryRun:               DryRun,
+		DryRunFile:           DryRunFile,
+		Pairs:                Pairs,
+		BotID:                BotID,
+		Exchange:             Exchange,
+		LoopInterval:         duration(LoopInterval),
+		CredentialSource:     CredentialSource,
+		CredentialPath:       CredentialPath,
+		PaperInitialFunds:    PaperInitialFunds,
+		PaperStateFile:       PaperStateFile,
+		PaperBoardFile:       PaperBoardFile,
+		LedgerFile:           LedgerFile,
+		HistoryDir:           HistoryDir,
+		HistorySyncInterval:  duration(HistorySyncInterval),
+		HaltOnRecoveryIssues: HaltOnRecoveryIssues,
+		ReloadOrderPolicy:    ReloadOrderPolicy,
+		ShutdownOrderPolicy:  ShutdownOrderPolicy,
+		NonceFile:            NonceFile,
+		PairInfoCacheFile:    PairInfoCacheFile,
+		RateLimits:           RateLimits,
+	}
+}
+
+func (s *settings) apply() {
+	Debug = s.Debug
+	DryRun = s.DryRun
+	DryRunFile = s.DryRunFile
+	Pairs = s.Pairs
+	BotID = s.BotID
+	Exchange = s.Exchange
+	LoopInterval = time.Duration(s.LoopInterval)
+	CredentialSource = s.CredentialSource
+	CredentialPath = s.CredentialPath
+	PaperInitialFunds = s.PaperInitialFunds
+	PaperStateFile = s.PaperStateFile
+	PaperBoardFile = s.PaperBoardFile
+	LedgerFile = s.LedgerFile
+	HistoryDir = s.HistoryDir
+	HistorySyncInterval = time.Duration(s.HistorySyncInterval)
+	HaltOnRecoveryIssues = s.HaltOnRecoveryIssues
+	ReloadOrderPolicy = s.ReloadOrderPolicy
+	ShutdownOrderPolicy = s.ShutdownOrderPolicy
+	NonceFile = s.NonceFile
+	PairInfoCacheFile = s.PairInfoCacheFile
+	RateLimits = s.RateLimits
+}
+
+//設定の矛盾をまとめて返却します
+func (s *settings) validate() error {
+	problems := []string{}
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	if s.Debug != 0 && s.Debug != 1 {
+		add("debugは0か1です: %d", s.Debug)
+	}
+	if !botIDPattern.MatchString(s.BotID) {
+		add("botIdは英数字と_-で16文字までです: %q", s.BotID)
+	}
+	switch s.Exchange {
+	case "zaif", "paper":
+	default:
+		add("exchangeはzaifかpaperです: %q", s.Exchange)
+	}
+	if s.LoopInterval <= 0 {
+		add("loopIntervalは正の値です: %s", time.Duration(s.LoopInterval))
+	}
+	if s.HistorySyncInterval <= 0 {
+		add("historySyncIntervalは正の値です: %s", time.Duration(s.HistorySyncInterval))
+	}
+	switch s.CredentialSource {
+	case "", "env":
+	case "file", "keystore":
+		if s.CredentialPath == "" {
+			add("credentialSourceが%sの場合はcredentialPathが必要です", s.CredentialSource)
+		}
+	default:
+		add("credentialSourceはenv、file、keystoreのいずれかです: %q", s.CredentialSource)
+	}
+	switch s.ReloadOrderPolicy {
+	case ReloadKeepOrders, ReloadCancelOffGrid, ReloadRepriceOrders:
+	default:
+		add("reloadOrderPolicyはkeep、cancel、repriceのいずれかです: %q", s.ReloadOrderPolicy)
+	}
+	switch s.ShutdownOrderPolicy {
+	case ShutdownKeepOrders, ShutdownCancelBuys, ShutdownCancelAll:
+	default:
+		add("shutdownOrderPolicyはkeep、cancel-buys、cancel-allのいずれかです: %q", s.ShutdownOrderPolicy)
+	}
+	for currency, amount := range s.PaperInitialFunds {
+		if amount.IsNegative() {
+			add("paperInitialFundsの%sが負の値です", currency)
+		}
+	}
+	for _, name := range []string{s.LedgerFile, s.HistoryDir, s.NonceFile, s.PairInfoCacheFile} {
+		if name == "" {
+			add("ledgerFile、historyDir、nonceFile、pairInfoCacheFileは省略できません")
+			break
+		}
+	}
+	for method, limit := range s.RateLimits {
+		if limit.Rate <= 0 || limit.Burst < 1 {
+			add("rateLimitsの%sはrateが正の値、burstが1以上です: %v, %d", method, limit.Rate, limit.Burst)
+		}
+	}
+	if len(s.Pairs) == 0 {
+		add("pairsが空です")
+	}
+	seen := map[string]bool{}
+	for i, p := range s.Pairs {
+		if seen[p.CurrencyPair] {
+			add("pairs[%d]: %sが重複しています", i, p.CurrencyPair)
+		}
+		seen[p.CurrencyPair] = true
+		for _, problem := range p.problems() {
+			add("pairs[%d](%s): %s", i, p.CurrencyPair, problem)
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(problems, "\n"))
+}
+
+//通貨ペア単体の設定を検証します。設定ファイルを介さずに作成した設定(バックテストなど)に使用します
+func (p *PairConfig) Validate() error {
+	problems := p.problems()
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%s: %s", p.CurrencyPair, strings.Join(problems, "\n"))
+}
+
+//通貨ペアの設定の矛盾を返却します
+func (p *PairConfig) problems() []string {
+	ret := []string{}
+	if parts := strings.Split(p.CurrencyPair, "_"); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		ret = append(ret, fmt.Sprintf("currencyPairはbtc_jpyの形式です: %q", p.CurrencyPair))
+	}
+	if p.BuyRange <= 0 || p.BuyRange >= 1 {
+		ret = append(ret, fmt.Sprintf("buyRangeは0より大きく1より小さい値です: %v", p.BuyRange))
+	}
+	if p.TakeProfitRange <= 0 {
+		ret = append(ret, fmt.Sprintf("takeProfitRangeは正の値です: %v", p.TakeProfitRange))
+	}
+	if p.TakeProfitRange <= p.BuyRange {
+		ret = append(ret, fmt.Sprintf("takeProfitRange(%v)はbuyRange(%v)より大きくしてください", p.TakeProfitRange, p.BuyRange))
+	}
+	if p.MaxPositionCount <= 0 || p.MaxOrderCount <= 0 {
+		ret = append(ret, fmt.Sprintf("maxPositionCountとmaxOrderCountは正の値です: %d, %d", p.MaxPositionCount, p.MaxOrderCount))
+	}
+	if p.MaxOrderCount > p.MaxPositionCount {
+		ret = append(ret, fmt.Sprintf("maxOrderCount(%d)はmaxPositionCount(%d)以下にしてください", p.MaxOrderCount, p.MaxPositionCount))
+	}
+	if p.StopLossRange < 0 || p.StopLossRange >= 1 {
+		ret = append(ret, fmt.Sprintf("stopLossRangeは0以上1未満です: %v", p.StopLossRange))
+	}
+	if p.MaxDrawdown < 0 || p.MaxDrawdown >= 1 {
+		ret = append(ret, fmt.Sprintf("maxDrawdownは0以上1未満です: %v", p.MaxDrawdown))
+	}
+	if p.MaxDrawdown > 0 && p.LossCutStages < 1 {
+		ret = append(ret, fmt.Sprintf("lossCutStagesは1以上です: %d", p.LossCutStages))
+	}
+	if p.LossCutCooldown < 0 {
+		ret = append(ret, fmt.Sprintf("lossCutCooldownが負の値です: %s", p.LossCutCooldown))
+	}
+	limits := []struct {
+		name  string
+		value decimal.Decimal
+	}{
+		{"maxQuoteExposure", p.MaxQuoteExposure},
+		{"maxBaseInventory", p.MaxBaseInventory},
+		{"maxOrderNotional", p.MaxOrderNotional},
+		{"dailyLossLimit", p.DailyLossLimit},
+		{"minQuoteReserve", p.MinQuoteReserve},
+	}
+	for _, l := range limits {
+		if l.value.IsNegative() {
+			ret = append(ret, fmt.Sprintf("%sが負の値です: %s", l.name, l.value))
+		}
+	}
+	return ret
+}
+
+//省略した項目を既定値にして読み込みます。lossCutCooldownは"30m"のような文字列で指定します
+func (p *PairConfig) UnmarshalJSON(b []byte) error {
+	type plain PairConfig
+	*p = pairDefaults
+	aux := struct {
+		*plain
+		LossCutCooldown duration `json:"lossCutCooldown"`
+	}{(*plain)(p), duration(pairDefaults.LossCutCooldown)}
+	if err := decodeStrict(b, &aux); err != nil {
+		return err
+	}
+	p.LossCutCooldown = time.Duration(aux.LossCutCooldown)
+	return nil
+}
+
+//"2s"や"5m"のような文字列で指定する時間です
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("時間は\"30s\"のような文字列で指定してください: %s", b)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
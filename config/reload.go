@@ -0,0 +1,122 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+//稼働中に変更できる項目です。これ以外の変更は再起動するまで反映しません
+var reloadable = map[string]bool{
+	"debug":               true,
+	"loopInterval":        true,
+	"historySyncInterval": true,
+	"reloadOrderPolicy":   true,
+	"shutdownOrderPolicy": true,
+}
+
+//設定の再読み込みの結果です
+type ReloadResult struct {
+	Changes []string //反映した変更(項目: 旧 -> 新)
+	Ignored []string //再起動が必要なため反映しなかった変更
+	//グリッドの設定(buyRange、takeProfitRange、maxPositionCount、maxOrderCount)が変わった通貨ペアです
+	GridChanged []string
+}
+
+//変更が無かった場合はtrueを返却します
+func (r *ReloadResult) Empty() bool {
+	return len(r.Changes) == 0 && len(r.Ignored) == 0
+}
+
+//設定ファイルを読み直し、稼働中に変更できる項目を反映します
+//通貨ペアの設定はボットが参照している*PairConfigを書き換えるため、次の周回から反映されます
+//検証に失敗した場合は何も反映しません
+func Reload(path string, profile string) (*ReloadResult, error) {
+	next, _, err := read(path, profile)
+	if err != nil {
+		return nil, err
+	}
+	r := &ReloadResult{}
+	cur := current()
+	merged := cur
+	curValue := reflect.ValueOf(&cur).Elem()
+	nextValue := reflect.ValueOf(next).Elem()
+	mergedValue := reflect.ValueOf(&merged).Elem()
+	for i := 0; i < curValue.NumField(); i++ {
+		name := jsonName(curValue.Type().Field(i))
+		if name == "pairs" {
+			continue
+		}
+		before, after := curValue.Field(i).Interface(), nextValue.Field(i).Interface()
+		if reflect.DeepEqual(before, after) {
+			continue
+		}
+		change := fmt.Sprintf("%s: %s -> %s", name, format(before), format(after))
+		if !reloadable[name] {
+			r.Ignored = append(r.Ignored, change)
+			continue
+		}
+		mergedValue.Field(i).Set(nextValue.Field(i))
+		r.Changes = append(r.Changes, change)
+	}
+	r.reloadPairs(next.Pairs)
+	merged.apply()
+	return r, nil
+}
+
+//通貨ペアごとの設定を書き換えます。通貨ペアの追加と削除は再起動が必要です
+func (r *ReloadResult) reloadPairs(next []*PairConfig) {
+	found := map[string]bool{}
+	for _, p := range next {
+		found[p.CurrencyPair] = true
+		var cur *PairConfig
+		for _, c := range Pairs {
+			if c.CurrencyPair == p.CurrencyPair {
+				cur = c
+			}
+		}
+		if cur == nil {
+			r.Ignored = append(r.Ignored, "pairs: "+p.CurrencyPair+"の追加")
+			continue
+		}
+		curValue := reflect.ValueOf(cur).Elem()
+		nextValue := reflect.ValueOf(p).Elem()
+		grid := false
+		for i := 0; i < curValue.NumField(); i++ {
+			before, after := curValue.Field(i).Interface(), nextValue.Field(i).Interface()
+			if reflect.DeepEqual(before, after) {
+				continue
+			}
+			name := jsonName(curValue.Type().Field(i))
+			r.Changes = append(r.Changes, fmt.Sprintf("pairs[%s].%s: %s -> %s", p.CurrencyPair, name, format(before), format(after)))
+			switch name {
+			case "buyRange", "takeProfitRange", "maxPositionCount", "maxOrderCount":
+				grid = true
+			}
+		}
+		if grid {
+			r.GridChanged = append(r.GridChanged, p.CurrencyPair)
+		}
+		*cur = *p
+	}
+	for _, c := range Pairs {
+		if !found[c.CurrencyPair] {
+			r.Ignored = append(r.Ignored, "pairs: "+c.CurrencyPair+"の削除")
+		}
+	}
+}
+
+func jsonName(f reflect.StructField) string {
+	return strings.Split(f.Tag.Get("json"), ",")[0]
+}
+
+func format(v interface{}) string {
+	switch t := v.(type) {
+	case duration:
+		return time.Duration(t).String()
+	case string:
+		return fmt.Sprintf("%q", t)
+	}
+	return fmt.Sprintf("%v", v)
+}
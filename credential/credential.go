@@ -0,0 +1,198 @@
+package credential
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	KeyEnv              = "ZAIF_API_KEY"
+	SecretEnv           = "ZAIF_API_SECRET"
+	FileEnv             = "ZAIF_CREDENTIALS_FILE"
+	KeystoreEnv         = "ZAIF_KEYSTORE"
+	PassphraseEnv       = "ZAIF_KEYSTORE_PASSPHRASE"
+	redactedPlaceholder = "********"
+)
+
+//APIの認証情報です。String/MarshalJSONでは値を伏せ字にします
+type Credentials struct {
+	Key    string `json:"key"`
+	Secret string `json:"secret"`
+}
+
+func (c Credentials) String() string {
+	return "Credentials{Key:" + redactedPlaceholder + ", Secret:" + redactedPlaceholder + "}"
+}
+
+func (c Credentials) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]string{"key": redactedPlaceholder, "secret": redactedPlaceholder})
+}
+
+//キーとシークレットが揃っているかを検証します
+func (c *Credentials) validate() error {
+	if c.Key == "" || c.Secret == "" {
+		return errors.New("APIキーまたはシークレットが空です")
+	}
+	return nil
+}
+
+//認証情報の取得元です
+type Provider interface {
+	Load() (*Credentials, error)
+}
+
+//環境変数から認証情報を取得します
+type EnvProvider struct {
+	KeyVar    string
+	SecretVar string
+}
+
+func (p *EnvProvider) Load() (*Credentials, error) {
+	c := &Credentials{Key: os.Getenv(p.KeyVar), Secret: os.Getenv(p.SecretVar)}
+	if err := c.validate(); err != nil {
+		return nil, fmt.Errorf("環境変数%s/%sが設定されていません", p.KeyVar, p.SecretVar)
+	}
+	return c, nil
+}
+
+//JSONファイル({"key":"...","secret":"..."})から認証情報を取得します
+//ファイルは所有者以外が読み書きできないパーミッションである必要があります
+type FileProvider struct {
+	Path string
+}
+
+func (p *FileProvider) Load() (*Credentials, error) {
+	if err := checkPermission(p.Path); err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadFile(p.Path)
+	if err != nil {
+		return nil, err
+	}
+	c := &Credentials{}
+	if err := json.Unmarshal(body, c); err != nil {
+		return nil, fmt.Errorf("認証情報ファイルの読み込みに失敗しました: %v", err)
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+//パスフレーズで暗号化されたキーストアから認証情報を取得します
+type KeystoreProvider struct {
+	Path       string
+	Passphrase func() (string, error)
+}
+
+func (p *KeystoreProvider) Load() (*Credentials, error) {
+	if err := checkPermission(p.Path); err != nil {
+		return nil, err
+	}
+	passphrase, err := p.Passphrase()
+	if err != nil {
+		return nil, err
+	}
+	c, err := ReadKeystore(p.Path, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+//環境変数を元に取得元を選び認証情報を取得します
+//優先順位は ZAIF_API_KEY/ZAIF_API_SECRET > ZAIF_CREDENTIALS_FILE > ZAIF_KEYSTORE です
+func Resolve() (*Credentials, error) {
+	var p Provider
+	switch {
+	case os.Getenv(KeyEnv) != "" || os.Getenv(SecretEnv) != "":
+		p = &EnvProvider{KeyVar: KeyEnv, SecretVar: SecretEnv}
+	case os.Getenv(FileEnv) != "":
+		p = &FileProvider{Path: os.Getenv(FileEnv)}
+	case os.Getenv(KeystoreEnv) != "":
+		p = &KeystoreProvider{Path: os.Getenv(KeystoreEnv), Passphrase: EnvPassphrase}
+	default:
+		return nil, fmt.Errorf("認証情報がありません。%s/%s、%s、%sのいずれかを設定してください", KeyEnv, SecretEnv, FileEnv, KeystoreEnv)
+	}
+	return load(p)
+}
+
+//取得元の種類とパスを指定して認証情報を取得します。sourceが空の場合はResolveと同じです
+//
+//	env:ZAIF_API_KEY/ZAIF_API_SECRET file:JSONファイル keystore:キーストア(パスフレーズはZAIF_KEYSTORE_PASSPHRASE)
+func ResolveFrom(source string, path string) (*Credentials, error) {
+	switch source {
+	case "":
+		return Resolve()
+	case "env":
+		return load(&EnvProvider{KeyVar: KeyEnv, SecretVar: SecretEnv})
+	case "file":
+		return load(&FileProvider{Path: path})
+	case "keystore":
+		return load(&KeystoreProvider{Path: path, Passphrase: EnvPassphrase})
+	}
+	return nil, fmt.Errorf("未知の認証情報の取得元です: %s", source)
+}
+
+func load(p Provider) (*Credentials, error) {
+	c, err := p.Load()
+	if err != nil {
+		return nil, err
+	}
+	Register(c)
+	return c, nil
+}
+
+//環境変数からキーストアのパスフレーズを取得します
+func EnvPassphrase() (string, error) {
+	passphrase := os.Getenv(PassphraseEnv)
+	if passphrase == "" {
+		return "", fmt.Errorf("環境変数%sが設定されていません", PassphraseEnv)
+	}
+	return passphrase, nil
+}
+
+//所有者以外に権限が付与されていないかを確認します
+func checkPermission(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("%sのパーミッション(%o)が広すぎます。chmod 600 してください", path, info.Mode().Perm())
+	}
+	return nil
+}
+
+//伏せ字にする文字列の一覧です
+var secrets struct {
+	sync.RWMutex
+	values []string
+}
+
+//認証情報をログ出力時の伏せ字対象として登録します
+func Register(c *Credentials) {
+	secrets.Lock()
+	defer secrets.Unlock()
+	secrets.values = append(secrets.values, c.Key, c.Secret)
+}
+
+//登録済みの認証情報を伏せ字に置き換えます
+func Redact(s string) string {
+	secrets.RLock()
+	defer secrets.RUnlock()
+	for _, v := range secrets.values {
+		if v != "" {
+			s = strings.Replace(s, v, redactedPlaceholder, -1)
+		}
+	}
+	return s
+}
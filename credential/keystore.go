@@ -0,0 +1,159 @@
+package credential
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const (
+	keystoreVersion    = 1
+	keystoreKDF        = "pbkdf2-sha256"
+	keystoreIterations = 200000
+	keystoreKeyLength  = 32
+)
+
+//キーストアファイルの中身です
+type keystoreFile struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	Iterations int    `json:"iterations"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+//キーストアを復号し認証情報を返却します
+func ReadKeystore(path string, passphrase string) (*Credentials, error) {
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ks := &keystoreFile{}
+	if err := json.Unmarshal(body, ks); err != nil {
+		return nil, fmt.Errorf("キーストアの読み込みに失敗しました: %v", err)
+	}
+	if ks.Version != keystoreVersion || ks.KDF != keystoreKDF {
+		return nil, fmt.Errorf("未対応のキーストア形式です(version=%d kdf=%s)", ks.Version, ks.KDF)
+	}
+	gcm, err := newGCM(passphrase, ks.Salt, ks.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := gcm.Open(nil, ks.Nonce, ks.Ciphertext, nil)
+	if err != nil {
+		return nil, errors.New("キーストアの復号に失敗しました。パスフレーズを確認してください")
+	}
+	c := &Credentials{}
+	if err := json.Unmarshal(plain, c); err != nil {
+		return nil, fmt.Errorf("キーストアの内容が不正です: %v", err)
+	}
+	return c, nil
+}
+
+//認証情報をパスフレーズで暗号化しキーストアとして書き出します
+//既存のファイルは一時ファイルからのrenameで置き換えるため、途中で失敗しても壊れません
+func WriteKeystore(path string, passphrase string, c *Credentials) error {
+	if passphrase == "" {
+		return errors.New("パスフレーズが空です")
+	}
+	if err := c.validate(); err != nil {
+		return err
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	gcm, err := newGCM(passphrase, salt, keystoreIterations)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	plain, err := json.Marshal(struct {
+		Key    string `json:"key"`
+		Secret string `json:"secret"`
+	}{c.Key, c.Secret})
+	if err != nil {
+		return err
+	}
+	body, err := json.MarshalIndent(&keystoreFile{
+		Version:    keystoreVersion,
+		KDF:        keystoreKDF,
+		Iterations: keystoreIterations,
+		Salt:       salt,
+		Nonce:      nonce,
+		Ciphertext: gcm.Seal(nil, nonce, plain, nil),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".keystore")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+//パスフレーズから導出した鍵でAES-GCMを作成します
+func newGCM(passphrase string, salt []byte, iterations int) (cipher.AEAD, error) {
+	if iterations <= 0 || len(salt) == 0 {
+		return nil, errors.New("キーストアの鍵導出パラメータが不正です")
+	}
+	block, err := aes.NewCipher(pbkdf2([]byte(passphrase), salt, iterations, keystoreKeyLength))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+//RFC 2898のPBKDF2(HMAC-SHA256)です
+func pbkdf2(password []byte, salt []byte, iterations int, keyLength int) []byte {
+	prf := hmac.New(sha256.New, password)
+	hashLength := prf.Size()
+	blocks := (keyLength + hashLength - 1) / hashLength
+	key := make([]byte, 0, blocks*hashLength)
+	buf := make([]byte, 4)
+	for block := 1; block <= blocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(buf, uint32(block))
+		prf.Write(buf)
+		u := prf.Sum(nil)
+		t := make([]byte, len(u))
+		copy(t, u)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLength]
+}
@@ -0,0 +1,371 @@
+package decimal
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+const (
+	//小数点以下の桁数です。Zaifの最小単位(1satoshi)を表せる桁数にしています
+	Precision = 8
+	scale     = 100000000
+)
+
+//金額・数量を扱う固定小数点の10進数です
+//内部では10^8倍した整数で保持するため、小数点以下8桁までの加減算は誤差なく行えます
+//表せるのは絶対値がmath.MaxInt64/10^8(約920億)までで、超える場合はErrOverflowです
+//文字列やJSONからの読み込みはエラーを返却し、演算はCheckedAddなどがエラーを、Addなどがpanicを返します
+type Decimal struct {
+	v int64
+}
+
+//0です
+var Zero = Decimal{}
+
+//桁あふれした場合のエラーです
+var ErrOverflow = errors.New("decimal: 桁あふれしました")
+
+var bigScale = big.NewInt(scale)
+
+//内部の整数で表せる絶対値の上限です。-math.MaxInt64までとすることで符号の反転が桁あふれしません
+var bigMax = big.NewInt(math.MaxInt64)
+
+//value*10^expを返却します。小数点以下8桁を超える部分は四捨五入します。桁あふれした場合はpanicします
+func New(value int64, exp int32) Decimal {
+	r := new(big.Rat).SetInt64(value)
+	p := new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(abs32(exp))), nil))
+	if exp >= 0 {
+		r.Mul(r, p)
+	} else {
+		r.Quo(r, p)
+	}
+	return must(fromRat(r))
+}
+
+//整数から作成します。桁あふれした場合はpanicします
+func NewFromInt(i int64) Decimal {
+	return must(checkedMul(i, scale))
+}
+
+//floatから作成します。floatを最短で表現した10進数として扱います
+func NewFromFloat(f float64) Decimal {
+	d, err := NewFromString(strconv.FormatFloat(f, 'g', -1, 64))
+	if err != nil {
+		return Zero
+	}
+	return d
+}
+
+//"123.45"や"1e-05"のような文字列から作成します。小数点以下8桁を超える部分は四捨五入します
+//表せる範囲を超える場合はErrOverflowを返却します
+func NewFromString(s string) (Decimal, error) {
+	r, ok := new(big.Rat).SetString(strings.TrimSpace(s))
+	if !ok {
+		return Zero, fmt.Errorf("数値として解釈できません: %q", s)
+	}
+	d, err := fromRat(r)
+	if err != nil {
+		return Zero, fmt.Errorf("%w: %q", err, s)
+	}
+	return d, nil
+}
+
+//文字列から作成します。解釈できない場合はpanicします(定数の定義用です)
+func RequireFromString(s string) Decimal {
+	d, err := NewFromString(s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+//有理数を10^8倍して四捨五入した値にします
+func fromRat(r *big.Rat) (Decimal, error) {
+	num := new(big.Int).Mul(r.Num(), bigScale)
+	v, err := roundDiv(num, r.Denom())
+	return Decimal{v}, err
+}
+
+//num/denを四捨五入(0から遠い方向)します
+func roundDiv(num *big.Int, den *big.Int) (int64, error) {
+	q, m := new(big.Int).QuoRem(num, den, new(big.Int))
+	m.Abs(m).Lsh(m, 1)
+	if m.Cmp(new(big.Int).Abs(den)) >= 0 {
+		if num.Sign()*den.Sign() < 0 {
+			q.Sub(q, big.NewInt(1))
+		} else {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+	if q.CmpAbs(bigMax) > 0 {
+		return 0, ErrOverflow
+	}
+	return q.Int64(), nil
+}
+
+//a+bを返却します
+func checkedAdd(a int64, b int64) (int64, error) {
+	if (b > 0 && a > math.MaxInt64-b) || (b < 0 && a < -math.MaxInt64-b) {
+		return 0, ErrOverflow
+	}
+	return a + b, nil
+}
+
+//内部の整数a*bをDecimalにします
+func checkedMul(a int64, b int64) (Decimal, error) {
+	v, err := roundDiv(new(big.Int).Mul(big.NewInt(a), big.NewInt(b)), big.NewInt(1))
+	return Decimal{v}, err
+}
+
+//演算の結果を返却します。桁あふれした場合はpanicします
+func must(d Decimal, err error) Decimal {
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+//足し算です。桁あふれした場合はpanicします
+func (d Decimal) Add(o Decimal) Decimal { return must(d.CheckedAdd(o)) }
+
+//引き算です。桁あふれした場合はpanicします
+func (d Decimal) Sub(o Decimal) Decimal { return must(d.CheckedSub(o)) }
+
+//掛け算です。小数点以下8桁を超える部分は四捨五入します。桁あふれした場合はpanicします
+func (d Decimal) Mul(o Decimal) Decimal { return must(d.CheckedMul(o)) }
+
+//割り算です。小数点以下8桁を超える部分は四捨五入します。0で割った場合と桁あふれした場合はpanicします
+func (d Decimal) Div(o Decimal) Decimal {
+	if o.v == 0 {
+		panic("decimal: 0で除算しました")
+	}
+	return must(d.CheckedDiv(o))
+}
+
+func (d Decimal) Neg() Decimal { return Decimal{-d.v} }
+
+//足し算です。桁あふれした場合はErrOverflowを返却します
+func (d Decimal) CheckedAdd(o Decimal) (Decimal, error) {
+	v, err := checkedAdd(d.v, o.v)
+	return Decimal{v}, err
+}
+
+//引き算です。桁あふれした場合はErrOverflowを返却します
+func (d Decimal) CheckedSub(o Decimal) (Decimal, error) {
+	v, err := checkedAdd(d.v, -o.v)
+	return Decimal{v}, err
+}
+
+//掛け算です。桁あふれした場合はErrOverflowを返却します
+func (d Decimal) CheckedMul(o Decimal) (Decimal, error) {
+	num := new(big.Int).Mul(big.NewInt(d.v), big.NewInt(o.v))
+	v, err := roundDiv(num, bigScale)
+	return Decimal{v}, err
+}
+
+//割り算です。0で割った場合はエラー、桁あふれした場合はErrOverflowを返却します
+func (d Decimal) CheckedDiv(o Decimal) (Decimal, error) {
+	if o.v == 0 {
+		return Zero, errors.New("decimal: 0で除算しました")
+	}
+	num := new(big.Int).Mul(big.NewInt(d.v), bigScale)
+	v, err := roundDiv(num, big.NewInt(o.v))
+	return Decimal{v}, err
+}
+
+//n乗を返却します(nは0以上)
+func (d Decimal) Pow(n int) Decimal {
+	ret := NewFromInt(1)
+	for i := 0; i < n; i++ {
+		ret = ret.Mul(d)
+	}
+	return ret
+}
+
+func (d Decimal) Abs() Decimal {
+	if d.v < 0 {
+		return Decimal{-d.v}
+	}
+	return d
+}
+
+//d<oなら-1、d==oなら0、d>oなら1を返却します
+func (d Decimal) Cmp(o Decimal) int {
+	switch {
+	case d.v < o.v:
+		return -1
+	case d.v > o.v:
+		return 1
+	}
+	return 0
+}
+
+func (d Decimal) Equal(o Decimal) bool              { return d.v == o.v }
+func (d Decimal) LessThan(o Decimal) bool           { return d.v < o.v }
+func (d Decimal) LessThanOrEqual(o Decimal) bool    { return d.v <= o.v }
+func (d Decimal) GreaterThan(o Decimal) bool        { return d.v > o.v }
+func (d Decimal) GreaterThanOrEqual(o Decimal) bool { return d.v >= o.v }
+func (d Decimal) IsZero() bool                      { return d.v == 0 }
+func (d Decimal) IsPositive() bool                  { return d.v > 0 }
+func (d Decimal) IsNegative() bool                  { return d.v < 0 }
+
+//符号を-1,0,1で返却します
+func (d Decimal) Sign() int {
+	return d.Cmp(Zero)
+}
+
+//小さい方を返却します
+func Min(a Decimal, b Decimal) Decimal {
+	if a.v < b.v {
+		return a
+	}
+	return b
+}
+
+//大きい方を返却します
+func Max(a Decimal, b Decimal) Decimal {
+	if a.v > b.v {
+		return a
+	}
+	return b
+}
+
+//stepの倍数に切り捨てます(負の方向)
+func (d Decimal) FloorTo(step Decimal) Decimal {
+	if step.v <= 0 {
+		return d
+	}
+	q := d.v / step.v
+	if d.v%step.v != 0 && d.v < 0 {
+		q--
+	}
+	return must(checkedMul(q, step.v))
+}
+
+//stepの倍数に切り上げます(正の方向)
+func (d Decimal) CeilTo(step Decimal) Decimal {
+	if step.v <= 0 {
+		return d
+	}
+	q := d.v / step.v
+	if d.v%step.v != 0 && d.v > 0 {
+		q++
+	}
+	return must(checkedMul(q, step.v))
+}
+
+//小数点以下places桁に四捨五入します。桁あふれした場合はpanicします
+func (d Decimal) Round(places int) Decimal {
+	if places >= Precision {
+		return d
+	}
+	unit := pow10(Precision - places)
+	q, err := roundDiv(big.NewInt(d.v), big.NewInt(unit))
+	if err != nil {
+		panic(err)
+	}
+	return must(checkedMul(q, unit))
+}
+
+//小数点以下の有効桁数を返却します(0.0001なら4)
+func (d Decimal) Places() int {
+	places := Precision
+	v := d.v
+	for places > 0 && v%10 == 0 {
+		v /= 10
+		places--
+	}
+	return places
+}
+
+//float64に変換します。表示や比率の計算以外には使用しないでください
+func (d Decimal) Float64() float64 {
+	return float64(d.v) / scale
+}
+
+//末尾の0を除いた文字列を返却します
+func (d Decimal) String() string {
+	return d.StringFixed(d.Places())
+}
+
+//小数点以下places桁に四捨五入した文字列を返却します
+func (d Decimal) StringFixed(places int) string {
+	if places > Precision {
+		places = Precision
+	}
+	if places < 0 {
+		places = 0
+	}
+	r := d.Round(places)
+	v := r.v
+	sign := ""
+	if v < 0 {
+		sign = "-"
+		v = -v
+	}
+	intPart := strconv.FormatInt(v/scale, 10)
+	if places == 0 {
+		return sign + intPart
+	}
+	frac := fmt.Sprintf("%08d", v%scale)[:places]
+	return sign + intPart + "." + frac
+}
+
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+//JSONの数値と文字列のどちらにも対応します。nullは0として扱います
+func (d *Decimal) UnmarshalJSON(b []byte) error {
+	s := strings.TrimSpace(string(b))
+	if s == "null" || s == `""` {
+		*d = Zero
+		return nil
+	}
+	if strings.HasPrefix(s, `"`) {
+		unquoted, err := strconv.Unquote(s)
+		if err != nil {
+			return err
+		}
+		s = unquoted
+	}
+	v, err := NewFromString(s)
+	if err != nil {
+		return err
+	}
+	*d = v
+	return nil
+}
+
+//%vや%sでは末尾の0を除いた文字列、%.2fのように桁数を指定した場合はその桁数で出力します
+func (d Decimal) Format(f fmt.State, verb rune) {
+	switch verb {
+	case 'f':
+		if p, ok := f.Precision(); ok {
+			fmt.Fprint(f, d.StringFixed(p))
+			return
+		}
+		fmt.Fprint(f, d.StringFixed(6))
+	default:
+		fmt.Fprint(f, d.String())
+	}
+}
+
+func pow10(n int) int64 {
+	ret := int64(1)
+	for i := 0; i < n; i++ {
+		ret *= 10
+	}
+	return ret
+}
+
+func abs32(i int32) int32 {
+	if i < 0 {
+		return -i
+	}
+	return i
+}
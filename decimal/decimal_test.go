@@ -0,0 +1,257 @@
+package decimal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestNewFromString(t *testing.T) {
+	cases := []struct {
+		in   string
+		want string
+	}{
+		{"0", "0"},
+		{"123.45", "123.45"},
+		{"-0.5", "-0.5"},
+		{"1e-05", "0.00001"},
+		{"0.00000001", "0.00000001"},
+		//9桁目は四捨五入します(0から遠い方向)
+		{"0.000000005", "0.00000001"},
+		{"-0.000000005", "-0.00000001"},
+		{"0.000000004", "0"},
+		{" 5123456.5 ", "5123456.5"},
+		{"92233720368.54775807", "92233720368.54775807"},
+	}
+	for _, c := range cases {
+		d, err := NewFromString(c.in)
+		if err != nil {
+			t.Errorf("%q: %v", c.in, err)
+			continue
+		}
+		if d.String() != c.want {
+			t.Errorf("%q = %s, want %s", c.in, d, c.want)
+		}
+	}
+	for _, in := range []string{"", "abc", "1.2.3", "1,000"} {
+		if _, err := NewFromString(in); err == nil {
+			t.Errorf("%q: expected error", in)
+		}
+	}
+}
+
+//取引所から受け取った大きすぎる値はpanicせずにエラーになること
+func TestNewFromStringOverflow(t *testing.T) {
+	for _, in := range []string{"92233720368.54775808", "-92233720368.54775808", "1e20", "-1e11"} {
+		_, err := NewFromString(in)
+		if !errors.Is(err, ErrOverflow) {
+			t.Errorf("%q: err = %v, want ErrOverflow", in, err)
+		}
+	}
+	var d Decimal
+	if err := json.Unmarshal([]byte(`1e30`), &d); !errors.Is(err, ErrOverflow) {
+		t.Errorf("json: err = %v, want ErrOverflow", err)
+	}
+}
+
+func TestArithmetic(t *testing.T) {
+	a := RequireFromString("0.1")
+	b := RequireFromString("0.2")
+	if got := a.Add(b); !got.Equal(RequireFromString("0.3")) {
+		t.Errorf("0.1+0.2 = %s", got)
+	}
+	if got := a.Sub(b); got.String() != "-0.1" {
+		t.Errorf("0.1-0.2 = %s", got)
+	}
+	if got := RequireFromString("1000000").Mul(RequireFromString("0.0001")); got.String() != "100" {
+		t.Errorf("1000000*0.0001 = %s", got)
+	}
+	//1/3は9桁目で四捨五入します
+	if got := NewFromInt(1).Div(NewFromInt(3)); got.String() != "0.33333333" {
+		t.Errorf("1/3 = %s", got)
+	}
+	if got := NewFromInt(2).Div(NewFromInt(3)); got.String() != "0.66666667" {
+		t.Errorf("2/3 = %s", got)
+	}
+	if got := NewFromInt(-2).Div(NewFromInt(3)); got.String() != "-0.66666667" {
+		t.Errorf("-2/3 = %s", got)
+	}
+	if got := RequireFromString("0.999").Pow(3); got.String() != "0.997003" {
+		t.Errorf("0.999^3 = %s", got)
+	}
+	if got := RequireFromString("-1.5").Abs(); got.String() != "1.5" {
+		t.Errorf("|-1.5| = %s", got)
+	}
+	if got := RequireFromString("1.5").Neg(); got.String() != "-1.5" {
+		t.Errorf("-(1.5) = %s", got)
+	}
+}
+
+//加減乗除はいずれも同じ範囲で桁あふれを検出すること
+func TestOverflow(t *testing.T) {
+	max := Decimal{math.MaxInt64}
+	min := Decimal{-math.MaxInt64}
+	one := NewFromInt(1)
+	tiny := Decimal{1}
+	checks := []struct {
+		name string
+		fn   func() (Decimal, error)
+	}{
+		{"max+tiny", func() (Decimal, error) { return max.CheckedAdd(tiny) }},
+		{"min-tiny", func() (Decimal, error) { return min.CheckedSub(tiny) }},
+		{"min+min", func() (Decimal, error) { return min.CheckedAdd(min) }},
+		{"max-min", func() (Decimal, error) { return max.CheckedSub(min) }},
+		{"max*2", func() (Decimal, error) { return max.CheckedMul(NewFromInt(2)) }},
+		{"max/0.5", func() (Decimal, error) { return max.CheckedDiv(RequireFromString("0.5")) }},
+		{"1e6*1e6", func() (Decimal, error) { return NewFromInt(1000000).CheckedMul(NewFromInt(1000000)) }},
+	}
+	for _, c := range checks {
+		if _, err := c.fn(); !errors.Is(err, ErrOverflow) {
+			t.Errorf("%s: err = %v, want ErrOverflow", c.name, err)
+		}
+	}
+	//境界の値は桁あふれしません
+	if got, err := max.Sub(tiny).CheckedAdd(tiny); err != nil || !got.Equal(max) {
+		t.Errorf("max-tiny+tiny = %s, %v", got, err)
+	}
+	if got, err := min.CheckedAdd(max); err != nil || !got.IsZero() {
+		t.Errorf("min+max = %s, %v", got, err)
+	}
+	if got, err := max.CheckedMul(one); err != nil || !got.Equal(max) {
+		t.Errorf("max*1 = %s, %v", got, err)
+	}
+	if _, err := one.CheckedDiv(Zero); err == nil || errors.Is(err, ErrOverflow) {
+		t.Errorf("1/0: err = %v", err)
+	}
+
+	panics := map[string]func(){
+		"Add":        func() { max.Add(tiny) },
+		"Sub":        func() { min.Sub(tiny) },
+		"Mul":        func() { max.Mul(NewFromInt(2)) },
+		"Div":        func() { max.Div(RequireFromString("0.5")) },
+		"NewFromInt": func() { NewFromInt(math.MaxInt64 / 10) },
+		"CeilTo":     func() { max.CeilTo(NewFromInt(7)) },
+	}
+	for name, fn := range panics {
+		func() {
+			defer func() {
+				r := recover()
+				if err, ok := r.(error); !ok || !errors.Is(err, ErrOverflow) {
+					t.Errorf("%s: recovered %v, want ErrOverflow", name, r)
+				}
+			}()
+			fn()
+		}()
+	}
+}
+
+func TestCompare(t *testing.T) {
+	a := RequireFromString("1.00000001")
+	b := RequireFromString("1")
+	if a.Cmp(b) != 1 || b.Cmp(a) != -1 || a.Cmp(a) != 0 {
+		t.Error("Cmp")
+	}
+	if !b.LessThan(a) || !a.GreaterThan(b) || !a.GreaterThanOrEqual(a) || !b.LessThanOrEqual(b) {
+		t.Error("comparison")
+	}
+	if !Min(a, b).Equal(b) || !Max(a, b).Equal(a) {
+		t.Error("Min/Max")
+	}
+	if Zero.Sign() != 0 || a.Sign() != 1 || a.Neg().Sign() != -1 {
+		t.Error("Sign")
+	}
+}
+
+func TestStep(t *testing.T) {
+	step := RequireFromString("0.0001")
+	cases := []struct {
+		in, floor, ceil string
+	}{
+		{"0.12345", "0.1234", "0.1235"},
+		{"0.1234", "0.1234", "0.1234"},
+		{"-0.12345", "-0.1235", "-0.1234"},
+	}
+	for _, c := range cases {
+		d := RequireFromString(c.in)
+		if got := d.FloorTo(step); got.String() != c.floor {
+			t.Errorf("FloorTo(%s) = %s, want %s", c.in, got, c.floor)
+		}
+		if got := d.CeilTo(step); got.String() != c.ceil {
+			t.Errorf("CeilTo(%s) = %s, want %s", c.in, got, c.ceil)
+		}
+	}
+	price := RequireFromString("999905.5")
+	if got := price.FloorTo(NewFromInt(5)); got.String() != "999905" {
+		t.Errorf("FloorTo(5) = %s", got)
+	}
+	if got := price.FloorTo(Zero); !got.Equal(price) {
+		t.Errorf("FloorTo(0) = %s", got)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	d := RequireFromString("1234.5678")
+	cases := []struct {
+		got, want string
+	}{
+		{d.String(), "1234.5678"},
+		{d.StringFixed(2), "1234.57"},
+		{d.StringFixed(0), "1235"},
+		{d.Round(1).String(), "1234.6"},
+		{fmt.Sprintf("%v", d), "1234.5678"},
+		{fmt.Sprintf("%f", d), "1234.567800"},
+		{fmt.Sprintf("%.3f", d.Neg()), "-1234.568"},
+		{RequireFromString("0.00001").String(), "0.00001"},
+		{RequireFromString("-0.00001").StringFixed(8), "-0.00001000"},
+	}
+	for _, c := range cases {
+		if c.got != c.want {
+			t.Errorf("got %s, want %s", c.got, c.want)
+		}
+	}
+	if d.Places() != 4 || NewFromInt(10).Places() != 0 {
+		t.Errorf("Places = %d, %d", d.Places(), NewFromInt(10).Places())
+	}
+}
+
+func TestJSON(t *testing.T) {
+	var v struct {
+		A Decimal `json:"a"`
+		B Decimal `json:"b"`
+		C Decimal `json:"c"`
+		D Decimal `json:"d"`
+	}
+	if err := json.Unmarshal([]byte(`{"a": 0.1, "b": "2.5", "c": null, "d": ""}`), &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.A.String() != "0.1" || v.B.String() != "2.5" || !v.C.IsZero() || !v.D.IsZero() {
+		t.Errorf("decoded %+v", v)
+	}
+	body, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != `{"a":0.1,"b":2.5,"c":0,"d":0}` {
+		t.Errorf("encoded %s", body)
+	}
+	if err := json.Unmarshal([]byte(`{"a": "abc"}`), &v); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestNewAndFloat(t *testing.T) {
+	if got := New(12345, -2); got.String() != "123.45" {
+		t.Errorf("New(12345,-2) = %s", got)
+	}
+	if got := New(5, 3); got.String() != "5000" {
+		t.Errorf("New(5,3) = %s", got)
+	}
+	if got := NewFromFloat(0.1); got.String() != "0.1" {
+		t.Errorf("NewFromFloat(0.1) = %s", got)
+	}
+	if got := NewFromFloat(0.0005).Float64(); got != 0.0005 {
+		t.Errorf("Float64 = %v", got)
+	}
+}
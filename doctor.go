@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"grid-crypto-real/api"
+	"grid-crypto-real/config"
+	"grid-crypto-real/credential"
+	"grid-crypto-real/history"
+	"grid-crypto-real/ledger"
+	"grid-crypto-real/paper"
+	"os"
+)
+
+//診断項目1件の結果です
+type check struct {
+	Name    string `json:"name"`
+	OK      bool   `json:"ok"`
+	Message string `json:"message"`
+}
+
+//稼働に必要な設定と接続を順に診断します。失敗した項目がある場合はエラーを返却します
+func doctorCommand(args []string) error {
+	o := &options{}
+	o.parse(o.flagSet("doctor"), args)
+	checks := []check{}
+	add := func(name string, err error, ok string) {
+		c := check{Name: name, OK: err == nil, Message: ok}
+		if err != nil {
+			c.Message = err.Error()
+		}
+		checks = append(checks, c)
+	}
+
+	err := loadConfig(o.logs, o.configPath, o.profile)
+	add("設定", err, fmt.Sprintf("exchange:%s botId:%s 通貨ペア:%d件", config.Exchange, config.BotID, len(config.Pairs)))
+	if err == nil {
+		//取得できない場合も組み込みの情報で稼働できるため問題にはしません
+		if err := api.LoadPairInfos(config.PairInfoCacheFile); err != nil {
+			add("通貨ペア情報", nil, "組み込みの通貨ペア情報を使用します: "+err.Error())
+		} else {
+			add("通貨ペア情報", nil, config.PairInfoCacheFile)
+		}
+		var source paper.BoardSource = api.NewZaif()
+		if config.Exchange == "paper" && config.PaperBoardFile != "" {
+			recorded, err := paper.LoadRecordedBoards(config.PaperBoardFile)
+			add("板の記録", err, config.PaperBoardFile)
+			source = nil
+			if err == nil {
+				source = recorded
+			}
+		}
+		for _, pc := range config.Pairs {
+			if source == nil {
+				break
+			}
+			if o.pair != "" && pc.CurrencyPair != o.pair {
+				continue
+			}
+			board, err := source.GetBoard(pc.CurrencyPair)
+			if err == nil && len(board.Bids) == 0 {
+				err = fmt.Errorf("板が空です")
+			}
+			message := ""
+			if err == nil {
+				message = fmt.Sprintf("最良買気配:%s", board.Bids[0].Price)
+			}
+			add("板の取得("+pc.CurrencyPair+")", err, message)
+		}
+		if config.Exchange == "zaif" {
+			doctorPrivateAPI(add)
+		}
+		_, err = ledger.Load(config.LedgerFile)
+		if os.IsNotExist(err) {
+			add("台帳", nil, config.LedgerFile+"はまだありません")
+		} else {
+			add("台帳", err, config.LedgerFile)
+		}
+		if store, err := history.OpenStore(config.HistoryDir); err != nil {
+			add("約定履歴", err, "")
+		} else {
+			for _, pc := range config.Pairs {
+				lastID, err := store.LastID(pc.CurrencyPair)
+				add("約定履歴("+pc.CurrencyPair+")", err, fmt.Sprintf("最後の約定ID:%d", lastID))
+			}
+		}
+	}
+
+	failed := 0
+	for _, c := range checks {
+		if !c.OK {
+			failed++
+		}
+	}
+	if o.json {
+		if err := o.printJSON(checks); err != nil {
+			return err
+		}
+	} else {
+		for _, c := range checks {
+			mark := "OK"
+			if !c.OK {
+				mark = "NG"
+			}
+			fmt.Fprintf(o.out, "[%s] %s %s\n", mark, c.Name, c.Message)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d件の項目で問題が見つかりました", failed)
+	}
+	return nil
+}
+
+//認証情報、nonceファイル、残高の取得を診断します
+func doctorPrivateAPI(add func(name string, err error, ok string)) {
+	creds, err := credential.ResolveFrom(config.CredentialSource, config.CredentialPath)
+	add("認証情報", err, "取得元:"+config.CredentialSource)
+	if err != nil {
+		return
+	}
+	api.SetCredentials(creds)
+	err = api.SetNonceFile(config.NonceFile)
+	add("nonceファイル", err, config.NonceFile)
+	if err != nil {
+		return
+	}
+	balance, err := api.NewZaif().GetBalance()
+	message := ""
+	if err == nil {
+		message = fmt.Sprintf("未約定注文:%d件", balance.OpenOrders)
+	}
+	add("残高の取得", err, message)
+}
@@ -0,0 +1,229 @@
+//発注と取り消しを取引所に送信せずに記録するドライランの取引所です
+//
+//参照系の呼び出しは元の取引所にそのまま渡すため、ボットは実際の口座と板を元に判断します
+//記録した注文は未約定注文と使用可能な残高に反映するため、次の周回も送信済みとして判断が続きます(約定はしません)
+package dryrun
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"grid-crypto-real/api"
+	"grid-crypto-real/exchange"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+//送信するはずだったリクエストです
+type Request struct {
+	Time    time.Time              `json:"time"`
+	Method  string                 `json:"method"`
+	Order   *exchange.OrderRequest `json:"order,omitempty"`
+	OrderID int                    `json:"orderId,omitempty"` //取り消す注文、または記録した注文のID
+	Params  string                 `json:"params"`            //送信するパラメータ(nonceを除く)
+}
+
+//変更系の呼び出しを記録するexchange.Exchangeです。記録した注文には負のIDを振ります
+type Recorder struct {
+	mu       sync.Mutex
+	ex       exchange.Exchange
+	file     *os.File
+	now      func() time.Time
+	nextID   int
+	orders   []exchange.Order       //記録した未約定注文
+	canceled map[int]exchange.Order //取り消したことにした実際の注文
+	known    map[int]exchange.Order //最後に取得した実際の未約定注文
+	out      io.Writer              //ログの出力先
+}
+
+//exへの変更系の呼び出しを記録するRecorderを作成します。pathが空でない場合はJSON Linesで追記します
+func NewRecorder(ex exchange.Exchange, path string) (*Recorder, error) {
+	r := &Recorder{ex: ex, now: time.Now, nextID: -1, canceled: map[int]exchange.Order{}, known: map[int]exchange.Order{}, out: os.Stdout}
+	if path != "" {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return nil, err
+		}
+		r.file = file
+	}
+	return r, nil
+}
+
+//記録に使用する時計を差し替えます
+func (r *Recorder) SetClock(now func() time.Time) {
+	r.now = now
+}
+
+//ログの出力先を差し替えます。既定は標準出力で、nilの場合は出力しません
+func (r *Recorder) SetOutput(w io.Writer) {
+	if w == nil {
+		w = ioutil.Discard
+	}
+	r.out = w
+}
+
+//記録ファイルを閉じます
+func (r *Recorder) Close() error {
+	if r.file == nil {
+		return nil
+	}
+	return r.file.Close()
+}
+
+//実際の残高から、記録した注文に使う分を除き、取り消したことにした注文の分を戻して返却します
+func (r *Recorder) GetBalance() (*exchange.Balance, error) {
+	balance, err := r.ex.GetBalance()
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ret := *balance
+	ret.Funds = exchange.Assets{}
+	for currency, amount := range balance.Funds {
+		ret.Funds[currency] = amount
+	}
+	for _, order := range r.orders {
+		reserve(ret.Funds, order, true)
+	}
+	for _, order := range r.canceled {
+		reserve(ret.Funds, order, false)
+	}
+	ret.OpenOrders += len(r.orders) - len(r.canceled)
+	return &ret, nil
+}
+
+//注文が拘束する残高をfundsから引きます。lockがfalseの場合は戻します
+func reserve(funds exchange.Assets, order exchange.Order, lock bool) {
+	base, quote := exchange.SplitPair(order.CurrencyPair)
+	currency, amount := base, order.Amount
+	if order.Action == exchange.Bid {
+		currency, amount = quote, order.Price.Mul(order.Amount)
+	}
+	if lock {
+		amount = amount.Neg()
+	}
+	funds[currency] = funds[currency].Add(amount)
+}
+
+//実際の未約定注文から取り消したことにした注文を除き、記録した注文を加えて返却します
+func (r *Recorder) GetActiveOrders(currencyPair string) ([]exchange.Order, error) {
+	orders, err := r.ex.GetActiveOrders(currencyPair)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ret := []exchange.Order{}
+	active := map[int]bool{}
+	for _, order := range orders {
+		active[order.ID] = true
+		r.known[order.ID] = order
+		if _, ok := r.canceled[order.ID]; !ok {
+			ret = append(ret, order)
+		}
+	}
+	//既に約定や取り消しで無くなった注文は残高に戻さないようにします
+	for id, order := range r.canceled {
+		if order.CurrencyPair == currencyPair && !active[id] {
+			delete(r.canceled, id)
+		}
+	}
+	for _, order := range r.orders {
+		if order.CurrencyPair == currencyPair {
+			ret = append(ret, order)
+		}
+	}
+	return ret, nil
+}
+
+func (r *Recorder) GetTradeHistory(currencyPair string) ([]exchange.Trade, error) {
+	return r.ex.GetTradeHistory(currencyPair)
+}
+
+//元の取引所が条件付きの取得に対応している場合はそのまま渡します
+func (r *Recorder) QueryTradeHistory(currencyPair string, q exchange.TradeQuery) ([]exchange.Trade, error) {
+	pager, ok := r.ex.(exchange.TradeHistoryPager)
+	if !ok {
+		return nil, errors.New("取引所が約定履歴の条件付き取得に対応していません")
+	}
+	return pager.QueryTradeHistory(currencyPair, q)
+}
+
+func (r *Recorder) GetBoard(currencyPair string) (*exchange.Board, error) {
+	return r.ex.GetBoard(currencyPair)
+}
+
+//注文を送信せずに記録します。送信できない注文は実際と同じエラーを返却します
+//指値の注文は未約定として保持し、成行の売却は即時に全量約定したものとして扱います
+func (r *Recorder) PlaceOrder(req *exchange.OrderRequest) (*exchange.OrderResult, error) {
+	params, err := api.OrderParams(req)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	copied := *req
+	if req.Market {
+		if err := r.record(Request{Method: api.TradeMethod, Order: &copied, Params: params}); err != nil {
+			return nil, err
+		}
+		return &exchange.OrderResult{Received: req.Amount}, nil
+	}
+	id := r.nextID
+	r.nextID--
+	amount := req.Amount
+	price := req.Price
+	if info, err := api.GetPairInfo(req.CurrencyPair); err == nil {
+		amount = info.SnapAmount(amount)
+		price = info.SnapPrice(price, string(req.Action))
+	}
+	if err := r.record(Request{Method: api.TradeMethod, Order: &copied, OrderID: id, Params: params}); err != nil {
+		return nil, err
+	}
+	r.orders = append(r.orders, exchange.Order{
+		ID:           id,
+		CurrencyPair: req.CurrencyPair,
+		Action:       req.Action,
+		Amount:       amount,
+		Price:        price,
+		Timestamp:    r.now(),
+		Comment:      req.Comment,
+	})
+	return &exchange.OrderResult{OrderID: id, Remains: amount}, nil
+}
+
+//注文の取り消しを送信せずに記録します
+func (r *Recorder) CancelOrder(orderID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.record(Request{Method: api.CancelOrderMethod, OrderID: orderID, Params: api.CancelParams(orderID)}); err != nil {
+		return err
+	}
+	for i, order := range r.orders {
+		if order.ID == orderID {
+			r.orders = append(r.orders[:i], r.orders[i+1:]...)
+			return nil
+		}
+	}
+	if order, ok := r.known[orderID]; ok {
+		r.canceled[orderID] = order
+	}
+	return nil
+}
+
+//リクエストをログに出力し、ファイルに追記します
+func (r *Recorder) record(req Request) error {
+	req.Time = r.now()
+	fmt.Fprintln(r.out, "[dry-run]", req.Method, req.Params)
+	if r.file == nil {
+		return nil
+	}
+	//パラメータの&を読めるまま残します
+	enc := json.NewEncoder(r.file)
+	enc.SetEscapeHTML(false)
+	return enc.Encode(req)
+}
@@ -0,0 +1,139 @@
+package exchange
+
+import (
+	"grid-crypto-real/decimal"
+	"strings"
+	"time"
+)
+
+//売買種別です
+type Action string
+
+const (
+	Bid Action = "bid" //買い
+	Ask Action = "ask" //売り
+)
+
+//通貨ごとの残高です。キーは"jpy"や"btc"などの通貨名です
+type Assets map[string]decimal.Decimal
+
+//口座残高です
+type Balance struct {
+	Funds      Assets    `json:"funds"`   //注文に使用可能な残高
+	Deposit    Assets    `json:"deposit"` //未約定注文を含めた残高
+	OpenOrders int       `json:"openOrders"`
+	ServerTime time.Time `json:"serverTime"`
+}
+
+//未約定注文です
+type Order struct {
+	ID           int             `json:"id"`
+	CurrencyPair string          `json:"currencyPair"`
+	Action       Action          `json:"action"`
+	Amount       decimal.Decimal `json:"amount"`
+	Price        decimal.Decimal `json:"price"`
+	Timestamp    time.Time       `json:"timestamp"`
+	Comment      string          `json:"comment"`
+}
+
+//約定履歴です
+type Trade struct {
+	ID           int             `json:"id"`
+	CurrencyPair string          `json:"currencyPair"`
+	Action       Action          `json:"action"`
+	YourAction   Action          `json:"yourAction"`
+	Amount       decimal.Decimal `json:"amount"`
+	Price        decimal.Decimal `json:"price"`
+	Fee          decimal.Decimal `json:"fee"`       //手数料(決済通貨建て)
+	FeeAmount    decimal.Decimal `json:"feeAmount"` //買いの手数料を基軸通貨で支払った場合の数量。決済通貨で支払った場合は0
+	Bonus        decimal.Decimal `json:"bonus"`
+	Timestamp    time.Time       `json:"timestamp"`
+	Comment      string          `json:"comment"`
+}
+
+//板の1行です
+type Level struct {
+	Price  decimal.Decimal `json:"price"`
+	Amount decimal.Decimal `json:"amount"`
+}
+
+//板情報です。Asksは安い順、Bidsは高い順に並びます
+type Board struct {
+	Asks []Level `json:"asks"`
+	Bids []Level `json:"bids"`
+}
+
+//発注内容です
+type OrderRequest struct {
+	CurrencyPair string          `json:"currencyPair"`
+	Action       Action          `json:"action"`
+	Price        decimal.Decimal `json:"price"`
+	Limit        decimal.Decimal `json:"limit"` //0の場合は利確注文を付けません
+	Amount       decimal.Decimal `json:"amount"`
+	Comment      string          `json:"comment"`
+	Market       bool            `json:"market"` //成行相当で発注する場合true(Priceは無視されます)
+}
+
+//発注結果です
+type OrderResult struct {
+	OrderID  int             `json:"orderId"` //即時に全量約定した場合は0
+	Received decimal.Decimal `json:"received"`
+	Remains  decimal.Decimal `json:"remains"`
+}
+
+//取引所の操作を抽象化したinterfaceです
+type Exchange interface {
+	//口座残高を取得します
+	GetBalance() (*Balance, error)
+	//通貨ペアの未約定注文を取得します
+	GetActiveOrders(currencyPair string) ([]Order, error)
+	//通貨ペアの約定履歴を新しい順に取得します
+	GetTradeHistory(currencyPair string) ([]Trade, error)
+	//通貨ペアの板情報を取得します
+	GetBoard(currencyPair string) (*Board, error)
+	//注文を行います
+	PlaceOrder(req *OrderRequest) (*OrderResult, error)
+	//注文をキャンセルします
+	CancelOrder(orderID int) error
+}
+
+//約定履歴の取得条件です。ゼロ値の項目は条件に含めません
+type TradeQuery struct {
+	FromID    int       //このID以上
+	EndID     int       //このID以下
+	Since     time.Time //この時刻以降
+	End       time.Time //この時刻以前
+	Count     int       //最大件数
+	Ascending bool      //trueの場合は古い順、falseの場合は新しい順
+}
+
+//条件を指定して約定履歴を取得できる取引所です。全履歴の同期や再起動時の復旧に使用します
+type TradeHistoryPager interface {
+	QueryTradeHistory(currencyPair string, q TradeQuery) ([]Trade, error)
+}
+
+//約定が条件に一致するかを返却します。件数と順序は含みません
+func (q *TradeQuery) Match(t *Trade) bool {
+	if q.FromID > 0 && t.ID < q.FromID {
+		return false
+	}
+	if q.EndID > 0 && t.ID > q.EndID {
+		return false
+	}
+	if !q.Since.IsZero() && t.Timestamp.Before(q.Since) {
+		return false
+	}
+	if !q.End.IsZero() && t.Timestamp.After(q.End) {
+		return false
+	}
+	return true
+}
+
+//"btc_jpy"のような通貨ペアを基軸通貨と決済通貨に分割します
+func SplitPair(currencyPair string) (base string, quote string) {
+	i := strings.Index(currencyPair, "_")
+	if i < 0 {
+		return currencyPair, ""
+	}
+	return currencyPair[:i], currencyPair[i+1:]
+}
@@ -0,0 +1,217 @@
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"grid-crypto-real/exchange"
+	"grid-crypto-real/ordertag"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+//1回の同期で取得する件数です
+const syncPageSize = 1000
+
+//通貨ペアごとの約定履歴を追記のみのファイルに保存するストアです
+//ファイルは<dir>/<通貨ペア>.jsonlで、1行に1件ずつ古い順に保存します
+type Store struct {
+	mu    sync.Mutex
+	dir   string
+	pairs map[string]*pairLog
+}
+
+//読み込み済みの通貨ペアの履歴です
+type pairLog struct {
+	trades []exchange.Trade
+	lastID int
+}
+
+//ストアを開きます。ディレクトリが存在しない場合は作成します
+func OpenStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Store{dir: dir, pairs: map[string]*pairLog{}}, nil
+}
+
+func (s *Store) path(currencyPair string) string {
+	return filepath.Join(s.dir, currencyPair+".jsonl")
+}
+
+//通貨ペアの履歴を読み込みます。書き込み途中で終了した末尾の行は切り詰めます
+func (s *Store) load(currencyPair string) (*pairLog, error) {
+	if pl, ok := s.pairs[currencyPair]; ok {
+		return pl, nil
+	}
+	pl := &pairLog{}
+	path := s.path(currencyPair)
+	body, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	valid := 0
+	for valid < len(body) {
+		end := bytes.IndexByte(body[valid:], '\n')
+		if end < 0 {
+			break
+		}
+		line := body[valid : valid+end]
+		t := exchange.Trade{}
+		if err := json.Unmarshal(line, &t); err != nil {
+			break
+		}
+		pl.trades = append(pl.trades, t)
+		if t.ID > pl.lastID {
+			pl.lastID = t.ID
+		}
+		valid += end + 1
+	}
+	if valid < len(body) {
+		log.Printf("%sの末尾%dバイトが不完全なため切り詰めます", path, len(body)-valid)
+		if err := os.Truncate(path, int64(valid)); err != nil {
+			return nil, err
+		}
+	}
+	s.pairs[currencyPair] = pl
+	return pl, nil
+}
+
+//同期済みの最後の約定IDを返却します
+func (s *Store) LastID(currencyPair string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pl, err := s.load(currencyPair)
+	if err != nil {
+		return 0, err
+	}
+	return pl.lastID, nil
+}
+
+//前回同期した約定の次から最新までを取得して追記し、追加した件数を返却します
+func (s *Store) Sync(ex exchange.TradeHistoryPager, currencyPair string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pl, err := s.load(currencyPair)
+	if err != nil {
+		return 0, err
+	}
+	added := 0
+	for {
+		page, err := ex.QueryTradeHistory(currencyPair, exchange.TradeQuery{
+			FromID:    pl.lastID + 1,
+			Count:     syncPageSize,
+			Ascending: true,
+		})
+		if err != nil {
+			return added, err
+		}
+		n, err := s.append(currencyPair, pl, page)
+		added += n
+		if err != nil {
+			return added, err
+		}
+		if len(page) < syncPageSize || n == 0 {
+			return added, nil
+		}
+	}
+}
+
+//古い順の約定のうち未保存のものを追記します
+func (s *Store) append(currencyPair string, pl *pairLog, trades []exchange.Trade) (int, error) {
+	buf := &bytes.Buffer{}
+	added := []exchange.Trade{}
+	lastID := pl.lastID
+	for _, t := range trades {
+		if t.ID <= lastID {
+			continue
+		}
+		body, err := json.Marshal(&t)
+		if err != nil {
+			return 0, err
+		}
+		buf.Write(body)
+		buf.WriteByte('\n')
+		added = append(added, t)
+		lastID = t.ID
+	}
+	if len(added) == 0 {
+		return 0, nil
+	}
+	file, err := os.OpenFile(s.path(currencyPair), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return 0, err
+	}
+	w := bufio.NewWriter(file)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		file.Close()
+		return 0, err
+	}
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return 0, err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return 0, err
+	}
+	if err := file.Close(); err != nil {
+		return 0, err
+	}
+	pl.trades = append(pl.trades, added...)
+	pl.lastID = lastID
+	return len(added), nil
+}
+
+//保存済みの約定の絞り込み条件です。ゼロ値の項目は条件に含めません
+type Filter struct {
+	Since   time.Time       //この時刻以降
+	Until   time.Time       //この時刻より前
+	Action  exchange.Action //自分の売買種別
+	BotID   string          //このボットのコメントが付いた約定
+	Tagged  bool            //ボットのコメントが付いた約定
+	AfterID int             //このIDより後の約定
+}
+
+func (f *Filter) match(t *exchange.Trade) bool {
+	if !f.Since.IsZero() && t.Timestamp.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !t.Timestamp.Before(f.Until) {
+		return false
+	}
+	if f.Action != "" && t.YourAction != f.Action {
+		return false
+	}
+	if f.BotID != "" && !ordertag.IsOwnedBy(t.Comment, f.BotID) {
+		return false
+	}
+	if f.Tagged && !ordertag.IsBot(t.Comment) {
+		return false
+	}
+	if f.AfterID > 0 && t.ID <= f.AfterID {
+		return false
+	}
+	return true
+}
+
+//条件に一致する約定を古い順に返却します
+func (s *Store) Query(currencyPair string, f Filter) ([]exchange.Trade, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pl, err := s.load(currencyPair)
+	if err != nil {
+		return nil, err
+	}
+	ret := []exchange.Trade{}
+	for i := range pl.trades {
+		if f.match(&pl.trades[i]) {
+			ret = append(ret, pl.trades[i])
+		}
+	}
+	return ret, nil
+}
@@ -0,0 +1,547 @@
+package ledger
+
+import (
+	"encoding/json"
+	"fmt"
+	"grid-crypto-real/decimal"
+	"grid-crypto-real/exchange"
+	"grid-crypto-real/ordertag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+//ロットの状態です
+type Status string
+
+const (
+	StatusOrdered   Status = "ordered"   //買い注文中で約定していない
+	StatusOpen      Status = "open"      //買い約定済みで利確待ち
+	StatusClosed    Status = "closed"    //買った数量を全て売却済み
+	StatusCancelled Status = "cancelled" //約定せずにキャンセルされた
+)
+
+//約定1件分です
+type Fill struct {
+	TradeID   int             `json:"tradeId"`
+	Action    exchange.Action `json:"action"`
+	Price     decimal.Decimal `json:"price"`
+	Amount    decimal.Decimal `json:"amount"`
+	Fee       decimal.Decimal `json:"fee"`
+	FeeAmount decimal.Decimal `json:"feeAmount"` //基軸通貨で支払った手数料の数量(Feeはその評価額)
+	Bonus     decimal.Decimal `json:"bonus"`
+	Time      time.Time       `json:"time"`
+}
+
+//買い約定で受け取った数量です。手数料を基軸通貨で支払った場合はその分を除きます
+func (f Fill) Net() decimal.Decimal {
+	return f.Amount.Sub(f.FeeAmount)
+}
+
+//買い約定の取得原価です
+//手数料を基軸通貨で支払った場合は受け取る数量が減った分が手数料のため、Feeは加えません
+func (f Fill) BuyCost() decimal.Decimal {
+	cost := f.Price.Mul(f.Amount).Sub(f.Bonus)
+	if f.FeeAmount.IsPositive() {
+		return cost
+	}
+	return cost.Add(f.Fee)
+}
+
+//グリッドの1ロットです。買い注文から利確の売却までを記録します
+//金額は決済通貨建てです
+type Lot struct {
+	ID              int             `json:"id"`
+	CurrencyPair    string          `json:"currencyPair"`
+	Status          Status          `json:"status"`
+	BuyOrderID      int             `json:"buyOrderId"`      //0の場合は即時約定したか、注文に由来しない約定です
+	OrderPrice      decimal.Decimal `json:"orderPrice"`      //買い注文の価格
+	OrderedAmount   decimal.Decimal `json:"orderedAmount"`   //買い注文の数量
+	TakeProfitPrice decimal.Decimal `json:"takeProfitPrice"` //limitで指定した利確価格
+	Amount          decimal.Decimal `json:"amount"`          //買い約定した数量
+	FeeAmount       decimal.Decimal `json:"feeAmount"`       //買いの手数料として基軸通貨で支払った数量
+	BuyCost         decimal.Decimal `json:"buyCost"`         //買い約定の代金+手数料-ボーナス
+	SoldAmount      decimal.Decimal `json:"soldAmount"`
+	SellProceeds    decimal.Decimal `json:"sellProceeds"` //売り約定の代金
+	Fees            decimal.Decimal `json:"fees"`
+	Bonus           decimal.Decimal `json:"bonus"`
+	RealizedProfit  decimal.Decimal `json:"realizedProfit"`
+	OrderedAt       time.Time       `json:"orderedAt"`
+	OpenedAt        time.Time       `json:"openedAt"`
+	ClosedAt        time.Time       `json:"closedAt"`
+	Comment         string          `json:"comment"`
+	LossCut         bool            `json:"lossCut"` //損切りで売却したロット
+	Fills           []Fill          `json:"fills"`
+}
+
+//保有している数量を返却します
+func (l *Lot) Held() decimal.Decimal {
+	return l.Net().Sub(l.SoldAmount)
+}
+
+//買い約定で受け取った数量を返却します。取得原価はこの数量で按分します
+func (l *Lot) Net() decimal.Decimal {
+	return l.Amount.Sub(l.FeeAmount)
+}
+
+//買いの平均約定価格を返却します
+func (l *Lot) BuyPrice() decimal.Decimal {
+	if !l.Amount.IsPositive() {
+		return decimal.Zero
+	}
+	notional := decimal.Zero
+	for _, f := range l.Fills {
+		if f.Action == exchange.Bid {
+			notional = notional.Add(f.Price.Mul(f.Amount))
+		}
+	}
+	return notional.Div(l.Amount)
+}
+
+//買い注文がまだ約定しうるかを返却します
+func (l *Lot) buying() bool {
+	return l.Status != StatusCancelled && l.Status != StatusClosed && l.Amount.LessThan(l.OrderedAmount)
+}
+
+//ロットごとの売買を記録する台帳です。更新のたびにファイルへ保存します
+//Batchの中での更新は終了時にまとめて保存します
+type Ledger struct {
+	mu    sync.Mutex
+	path  string
+	data  data
+	batch int  //実行中のBatchの数
+	dirty bool //Batchの終了まで保存を保留している変更がある
+}
+
+//永続化する内容です
+type data struct {
+	NextID int    `json:"nextId"`
+	Lots   []*Lot `json:"lots"`
+	//通貨ペアごとの反映済みの最後の約定IDです。約定IDは増え続けるため、これ以下の約定は反映済みです
+	//次に取得する約定の起点にも使用します
+	LastTradeIDs map[string]int `json:"lastTradeIds"`
+	//どのロットにも割り当てられなかった売り約定です
+	Unmatched []Fill `json:"unmatched"`
+}
+
+//台帳ファイルを開きます。存在しない場合は空の台帳を作成します
+//pathが空の場合は保存しません
+func Open(path string) (*Ledger, error) {
+	l := &Ledger{path: path, data: data{NextID: 1, LastTradeIDs: map[string]int{}}}
+	if path == "" {
+		return l, nil
+	}
+	body, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return l, l.save()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return l, l.decode(body)
+}
+
+//台帳ファイルを読み込みます。変更してもファイルには保存しないため、レポートの作成に使用します
+func Load(path string) (*Ledger, error) {
+	l, err := Open("")
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return l, l.decode(body)
+}
+
+func (l *Ledger) decode(body []byte) error {
+	l.data.LastTradeIDs = nil
+	if err := json.Unmarshal(body, &l.data); err != nil {
+		return fmt.Errorf("台帳ファイルが不正です: %v", err)
+	}
+	if l.data.LastTradeIDs == nil {
+		//反映済みの約定IDを持たない古い台帳は、ロットに記録した約定から求めます
+		l.data.LastTradeIDs = map[string]int{}
+		for _, lot := range l.data.Lots {
+			for _, f := range lot.Fills {
+				if f.TradeID > l.data.LastTradeIDs[lot.CurrencyPair] {
+					l.data.LastTradeIDs[lot.CurrencyPair] = f.TradeID
+				}
+			}
+		}
+	}
+	return nil
+}
+
+//fnの中で行った変更を、終了時にまとめて1度だけ保存します
+//周回の中で何度も台帳を更新する発注などを囲んで使用します
+func (l *Ledger) Batch(fn func()) error {
+	l.mu.Lock()
+	l.batch++
+	l.mu.Unlock()
+	fn()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.batch--
+	if l.batch > 0 || !l.dirty {
+		return nil
+	}
+	return l.save()
+}
+
+//買い注文を記録します。価格と数量は刻みに合わせた後の値を渡してください
+func (l *Ledger) RecordOrder(currencyPair string, orderID int, price decimal.Decimal, takeProfit decimal.Decimal, amount decimal.Decimal, comment string, at time.Time) (*Lot, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lot := l.newLot(currencyPair)
+	lot.Status = StatusOrdered
+	lot.BuyOrderID = orderID
+	lot.OrderPrice = price
+	lot.OrderedAmount = amount
+	lot.TakeProfitPrice = takeProfit
+	lot.OrderedAt = at
+	lot.Comment = comment
+	return copyLot(lot), l.save()
+}
+
+//約定履歴を台帳に反映します。反映済みの約定は無視するため、同じ履歴を何度渡しても構いません
+//約定は古い順に反映するため、履歴の並び順は問いません
+func (l *Ledger) ApplyTrades(trades []exchange.Trade) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	sorted := append([]exchange.Trade{}, trades...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+	changed := false
+	for _, t := range sorted {
+		if t.ID <= l.data.LastTradeIDs[t.CurrencyPair] {
+			continue
+		}
+		l.data.LastTradeIDs[t.CurrencyPair] = t.ID
+		fill := Fill{
+			TradeID: t.ID,
+			Action:  t.YourAction,
+			Price:   t.Price,
+			Amount:  t.Amount,
+			Fee:     t.Fee,
+			Bonus:   t.Bonus,
+			Time:    t.Timestamp,
+		}
+		if t.YourAction == exchange.Bid {
+			fill.FeeAmount = t.FeeAmount
+		}
+		if t.YourAction == exchange.Bid {
+			l.applyBuy(t.CurrencyPair, fill)
+		} else {
+			tag, ok := ordertag.Parse(t.Comment)
+			l.applySell(t.CurrencyPair, fill, ok && tag.Level == ordertag.LossCutLevel)
+		}
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return l.save()
+}
+
+//通貨ペアの反映済みの最後の約定IDを返却します。まだ反映していない場合は0です
+func (l *Ledger) LastTradeID(currencyPair string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.data.LastTradeIDs[currencyPair]
+}
+
+//買い約定を、約定価格以上で買い注文中のロットのうち最も注文価格が近いものに割り当てます
+//該当するロットが無い場合は新しいロットとして記録します
+func (l *Ledger) applyBuy(currencyPair string, fill Fill) {
+	var lot *Lot
+	for _, candidate := range l.data.Lots {
+		if candidate.CurrencyPair != currencyPair || !candidate.buying() || candidate.OrderPrice.LessThan(fill.Price) {
+			continue
+		}
+		if lot == nil || candidate.OrderPrice.LessThan(lot.OrderPrice) {
+			lot = candidate
+		}
+	}
+	if lot == nil {
+		lot = l.newLot(currencyPair)
+		lot.OrderPrice = fill.Price
+		lot.OrderedAmount = fill.Amount
+	}
+	if lot.Status == StatusOrdered || lot.OpenedAt.IsZero() {
+		lot.OpenedAt = fill.Time
+	}
+	lot.Status = StatusOpen
+	lot.Amount = lot.Amount.Add(fill.Amount)
+	lot.FeeAmount = lot.FeeAmount.Add(fill.FeeAmount)
+	lot.BuyCost = lot.BuyCost.Add(fill.BuyCost())
+	lot.Fees = lot.Fees.Add(fill.Fee)
+	lot.Bonus = lot.Bonus.Add(fill.Bonus)
+	lot.Fills = append(lot.Fills, fill)
+}
+
+//売り約定を保有中のロットに割り当てます
+//損切りの注文の約定は損切り中のロットに優先して割り当てます
+//それ以外は利確価格が約定価格以下のロットのうち最も利確価格が高いもの、損切り中のロットの順に優先し、残りは古いロットから順に割り当てます
+//手数料とボーナスは数量で按分します
+func (l *Ledger) applySell(currencyPair string, fill Fill, lossCut bool) {
+	lots := []*Lot{}
+	for _, lot := range l.data.Lots {
+		if lot.CurrencyPair == currencyPair && lot.Held().IsPositive() {
+			lots = append(lots, lot)
+		}
+	}
+	sort.SliceStable(lots, func(i, j int) bool {
+		if lossCut && lots[i].LossCut != lots[j].LossCut {
+			return lots[i].LossCut
+		}
+		iTake := lots[i].TakeProfitPrice.IsPositive() && lots[i].TakeProfitPrice.LessThanOrEqual(fill.Price)
+		jTake := lots[j].TakeProfitPrice.IsPositive() && lots[j].TakeProfitPrice.LessThanOrEqual(fill.Price)
+		if iTake != jTake {
+			return iTake
+		}
+		if iTake {
+			return lots[i].TakeProfitPrice.GreaterThan(lots[j].TakeProfitPrice)
+		}
+		return lots[i].LossCut && !lots[j].LossCut
+	})
+
+	remain := fill.Amount
+	for _, lot := range lots {
+		if !remain.IsPositive() {
+			break
+		}
+		amount := decimal.Min(remain, lot.Held())
+		part := fill
+		part.Amount = amount
+		if amount.LessThan(fill.Amount) {
+			part.Fee = fill.Fee.Mul(amount).Div(fill.Amount)
+			part.Bonus = fill.Bonus.Mul(amount).Div(fill.Amount)
+		}
+		soldCost := lot.BuyCost.Mul(amount).Div(lot.Net())
+		lot.SoldAmount = lot.SoldAmount.Add(amount)
+		lot.SellProceeds = lot.SellProceeds.Add(part.Price.Mul(amount))
+		lot.Fees = lot.Fees.Add(part.Fee)
+		lot.Bonus = lot.Bonus.Add(part.Bonus)
+		lot.RealizedProfit = lot.RealizedProfit.Add(part.Price.Mul(amount)).Sub(part.Fee).Add(part.Bonus).Sub(soldCost)
+		lot.Fills = append(lot.Fills, part)
+		if !lot.Held().IsPositive() && !lot.buying() {
+			lot.Status = StatusClosed
+			lot.ClosedAt = fill.Time
+		}
+		remain = remain.Sub(amount)
+	}
+	if remain.IsPositive() {
+		rest := fill
+		rest.Amount = remain
+		rest.Fee = fill.Fee.Mul(remain).Div(fill.Amount)
+		rest.Bonus = fill.Bonus.Mul(remain).Div(fill.Amount)
+		l.data.Unmatched = append(l.data.Unmatched, rest)
+	}
+}
+
+//買い注文のキャンセルを記録します。一部約定していた場合は約定した数量で買い終えたものとします
+func (l *Ledger) CancelOrder(orderID int) error {
+	if orderID == 0 {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, lot := range l.data.Lots {
+		if lot.BuyOrderID != orderID || !lot.buying() {
+			continue
+		}
+		if lot.Status == StatusOrdered {
+			lot.Status = StatusCancelled
+		} else {
+			lot.OrderedAmount = lot.Amount
+			if !lot.Held().IsPositive() {
+				lot.Status = StatusClosed
+			}
+		}
+		return l.save()
+	}
+	return nil
+}
+
+//ロットを損切り中として記録します。以降の売り約定はこれらのロットに優先して割り当てます
+func (l *Ledger) MarkLossCut(lotIDs ...int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	changed := false
+	for _, lot := range l.data.Lots {
+		for _, id := range lotIDs {
+			if lot.ID == id && !lot.LossCut {
+				lot.LossCut = true
+				changed = true
+			}
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return l.save()
+}
+
+//買い注文が台帳に記録されているかを返却します
+func (l *Ledger) HasOrder(orderID int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, lot := range l.data.Lots {
+		if orderID != 0 && lot.BuyOrderID == orderID {
+			return true
+		}
+	}
+	return false
+}
+
+//未約定注文に含まれなくなった買い注文を終了したものとして扱い、その件数を返却します
+//再起動中にキャンセルされた注文を反映するため、約定履歴を反映した後に呼び出してください
+func (l *Ledger) ExpireOrders(currencyPair string, active map[int]bool) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	expired := 0
+	for _, lot := range l.data.Lots {
+		if lot.CurrencyPair != currencyPair || lot.BuyOrderID == 0 || !lot.buying() || active[lot.BuyOrderID] {
+			continue
+		}
+		if lot.Status == StatusOrdered {
+			lot.Status = StatusCancelled
+		} else {
+			lot.OrderedAmount = lot.Amount
+			if !lot.Held().IsPositive() {
+				lot.Status = StatusClosed
+			}
+		}
+		expired++
+	}
+	if expired == 0 {
+		return 0, nil
+	}
+	return expired, l.save()
+}
+
+//通貨ペアのロットを古い順に返却します。statusを指定しない場合は全てのロットを返却します
+func (l *Ledger) Lots(currencyPair string, status ...Status) []Lot {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ret := []Lot{}
+	for _, lot := range l.data.Lots {
+		if lot.CurrencyPair != currencyPair {
+			continue
+		}
+		if len(status) > 0 && !containsStatus(status, lot.Status) {
+			continue
+		}
+		ret = append(ret, *copyLot(lot))
+	}
+	return ret
+}
+
+//保有中のロットを古い順に返却します
+func (l *Ledger) OpenLots(currencyPair string) []Lot {
+	return l.Lots(currencyPair, StatusOpen)
+}
+
+//どのロットにも割り当てられなかった売り約定を返却します
+func (l *Ledger) Unmatched() []Fill {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]Fill{}, l.data.Unmatched...)
+}
+
+//通貨ペアごとの集計です
+type Summary struct {
+	CurrencyPair   string          `json:"currencyPair"`
+	OrderedLots    int             `json:"orderedLots"`
+	OpenLots       int             `json:"openLots"`
+	ClosedLots     int             `json:"closedLots"`
+	LossCutLots    int             `json:"lossCutLots"`
+	LossCutProfit  decimal.Decimal `json:"lossCutProfit"` //損切りしたロットの損益
+	Held           decimal.Decimal `json:"held"`
+	HeldCost       decimal.Decimal `json:"heldCost"`
+	RealizedProfit decimal.Decimal `json:"realizedProfit"`
+	Fees           decimal.Decimal `json:"fees"`
+	Bonus          decimal.Decimal `json:"bonus"`
+}
+
+//通貨ペアの集計を返却します
+func (l *Ledger) Summary(currencyPair string) Summary {
+	s := Summary{CurrencyPair: currencyPair}
+	for _, lot := range l.Lots(currencyPair) {
+		switch lot.Status {
+		case StatusOrdered:
+			s.OrderedLots++
+		case StatusOpen:
+			s.OpenLots++
+		case StatusClosed:
+			s.ClosedLots++
+		}
+		if lot.Net().IsPositive() {
+			s.Held = s.Held.Add(lot.Held())
+			s.HeldCost = s.HeldCost.Add(lot.BuyCost.Mul(lot.Held()).Div(lot.Net()))
+		}
+		s.RealizedProfit = s.RealizedProfit.Add(lot.RealizedProfit)
+		if lot.LossCut {
+			s.LossCutLots++
+			s.LossCutProfit = s.LossCutProfit.Add(lot.RealizedProfit)
+		}
+		s.Fees = s.Fees.Add(lot.Fees)
+		s.Bonus = s.Bonus.Add(lot.Bonus)
+	}
+	return s
+}
+
+func (l *Ledger) newLot(currencyPair string) *Lot {
+	lot := &Lot{ID: l.data.NextID, CurrencyPair: currencyPair}
+	l.data.NextID++
+	l.data.Lots = append(l.data.Lots, lot)
+	return lot
+}
+
+//一時ファイルに書き込んでから置き換えるため、途中で落ちても壊れたファイルは残りません
+//Batchの中では保存せず、終了時に保存します
+func (l *Ledger) save() error {
+	if l.path == "" {
+		return nil
+	}
+	if l.batch > 0 {
+		l.dirty = true
+		return nil
+	}
+	l.dirty = false
+	body, err := json.MarshalIndent(&l.data, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(l.path), ".ledger")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), l.path)
+}
+
+func copyLot(lot *Lot) *Lot {
+	ret := *lot
+	ret.Fills = append([]Fill{}, lot.Fills...)
+	return &ret
+}
+
+func containsStatus(list []Status, s Status) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
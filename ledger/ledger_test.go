@@ -0,0 +1,376 @@
+package ledger
+
+import (
+	"grid-crypto-real/decimal"
+	"grid-crypto-real/exchange"
+	"grid-crypto-real/ordertag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var start = time.Date(2018, 5, 1, 0, 0, 0, 0, time.UTC)
+
+func d(s string) decimal.Decimal {
+	return decimal.RequireFromString(s)
+}
+
+func trade(id int, action exchange.Action, price string, amount string) exchange.Trade {
+	return exchange.Trade{
+		ID:           id,
+		CurrencyPair: "btc_jpy",
+		Action:       action,
+		YourAction:   action,
+		Price:        d(price),
+		Amount:       d(amount),
+		Timestamp:    start.Add(time.Duration(id) * time.Minute),
+	}
+}
+
+func lossCutComment(t *testing.T) string {
+	comment, err := ordertag.Encode(ordertag.Tag{BotID: "grid1", Level: ordertag.LossCutLevel})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return comment
+}
+
+func record(t *testing.T, l *Ledger, orderID int, price string, takeProfit string, amount string) {
+	t.Helper()
+	if _, err := l.RecordOrder("btc_jpy", orderID, d(price), d(takeProfit), d(amount), "", start); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func apply(t *testing.T, l *Ledger, trades ...exchange.Trade) {
+	t.Helper()
+	if err := l.ApplyTrades(trades); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func lotByID(t *testing.T, l *Ledger, currencyPair string, id int) Lot {
+	t.Helper()
+	for _, lot := range l.Lots(currencyPair) {
+		if lot.ID == id {
+			return lot
+		}
+	}
+	t.Fatalf("lot %d not found", id)
+	return Lot{}
+}
+
+//買い約定は約定価格以上で買い注文中のロットのうち、最も注文価格が近いものに割り当てること
+func TestApplyBuyMatchesOrder(t *testing.T) {
+	l, _ := Open("")
+	record(t, l, 11, "1000", "0", "0.1")
+	record(t, l, 12, "990", "0", "0.1")
+	record(t, l, 13, "1010", "0", "0.1")
+	cases := []struct {
+		price   string
+		amount  string
+		wantLot int
+	}{
+		{"985", "0.05", 2},
+		{"995", "0.1", 1},
+		//該当する注文が無い場合は新しいロットです
+		{"1020", "0.1", 4},
+		{"985", "0.05", 2},
+		//買い終えたロットには割り当てません
+		{"980", "0.1", 3},
+	}
+	for i, c := range cases {
+		apply(t, l, trade(i+1, exchange.Bid, c.price, c.amount))
+		lot := lotByID(t, l, "btc_jpy", c.wantLot)
+		if len(lot.Fills) == 0 || lot.Fills[len(lot.Fills)-1].TradeID != i+1 {
+			t.Errorf("%s@%s: assigned to another lot, lot %d fills = %+v", c.amount, c.price, c.wantLot, lot.Fills)
+		}
+	}
+	if lot := lotByID(t, l, "btc_jpy", 4); lot.BuyOrderID != 0 || !lot.OrderPrice.Equal(d("1020")) || !lot.OrderedAmount.Equal(d("0.1")) {
+		t.Errorf("new lot = %+v", lot)
+	}
+	if lot := lotByID(t, l, "btc_jpy", 2); !lot.Amount.Equal(d("0.1")) || lot.Status != StatusOpen {
+		t.Errorf("lot 2 = %+v", lot)
+	}
+}
+
+//一部約定した注文は残りの約定を待ち、キャンセルされた場合は約定した数量で買い終えること
+func TestPartialFills(t *testing.T) {
+	l, _ := Open("")
+	record(t, l, 55, "1000", "1100", "0.2")
+	apply(t, l, trade(1, exchange.Bid, "1000", "0.05"))
+	lot := lotByID(t, l, "btc_jpy", 1)
+	if lot.Status != StatusOpen || !lot.Amount.Equal(d("0.05")) || !lot.OpenedAt.Equal(start.Add(time.Minute)) || !lot.buying() {
+		t.Fatalf("after first fill = %+v", lot)
+	}
+	apply(t, l, trade(2, exchange.Bid, "1000", "0.05"))
+	if err := l.CancelOrder(55); err != nil {
+		t.Fatal(err)
+	}
+	lot = lotByID(t, l, "btc_jpy", 1)
+	if lot.Status != StatusOpen || !lot.OrderedAmount.Equal(d("0.1")) || lot.buying() || !lot.OpenedAt.Equal(start.Add(time.Minute)) {
+		t.Fatalf("after cancel = %+v", lot)
+	}
+	//キャンセルした注文の価格での約定は新しいロットになります
+	apply(t, l, trade(3, exchange.Bid, "1000", "0.05"))
+	if lots := l.Lots("btc_jpy"); len(lots) != 2 || !lots[0].Amount.Equal(d("0.1")) {
+		t.Fatalf("lots = %+v", lots)
+	}
+	apply(t, l, trade(4, exchange.Ask, "1100", "0.1"))
+	lot = lotByID(t, l, "btc_jpy", 1)
+	if lot.Status != StatusClosed || !lot.RealizedProfit.Equal(d("10")) || !lot.ClosedAt.Equal(start.Add(4*time.Minute)) {
+		t.Errorf("after sell = %+v", lot)
+	}
+}
+
+//基軸通貨で支払った買いの手数料は保有数量から除き、取得原価には加えないこと
+func TestBuyFeeCurrency(t *testing.T) {
+	cases := []struct {
+		name       string
+		feeAmount  string
+		wantNet    string
+		wantCost   string
+		wantProfit string
+	}{
+		//0.1BTCを1000円で買い、手数料0.0001BTC(0.1円相当)を差し引かれた場合です
+		{"base", "0.0001", "0.0999", "100", "9.89"},
+		//手数料0.1円を決済通貨で支払った場合です
+		{"quote", "0", "0.1", "100.1", "9.9"},
+	}
+	for _, c := range cases {
+		l, _ := Open("")
+		buy := trade(1, exchange.Bid, "1000", "0.1")
+		buy.Fee = d("0.1")
+		buy.FeeAmount = d(c.feeAmount)
+		apply(t, l, buy)
+		lot := lotByID(t, l, "btc_jpy", 1)
+		if !lot.Held().Equal(d(c.wantNet)) || !lot.BuyCost.Equal(d(c.wantCost)) || !lot.Fees.Equal(d("0.1")) {
+			t.Errorf("%s: held/cost/fees = %s/%s/%s", c.name, lot.Held(), lot.BuyCost, lot.Fees)
+		}
+		if s := l.Summary("btc_jpy"); !s.Held.Equal(d(c.wantNet)) || !s.HeldCost.Equal(d(c.wantCost)) {
+			t.Errorf("%s: summary held/cost = %s/%s", c.name, s.Held, s.HeldCost)
+		}
+
+		sell := trade(2, exchange.Ask, "1100", c.wantNet)
+		//売りのfee_amountは基軸通貨の数量ではないため使用しません
+		sell.FeeAmount = d("0.5")
+		apply(t, l, sell)
+		lot = lotByID(t, l, "btc_jpy", 1)
+		if lot.Status != StatusClosed || !lot.RealizedProfit.Equal(d(c.wantProfit)) || len(l.Unmatched()) != 0 {
+			t.Errorf("%s: status/profit = %s/%s, unmatched = %v", c.name, lot.Status, lot.RealizedProfit, l.Unmatched())
+		}
+	}
+}
+
+//売り約定の割り当ての優先順位
+func TestApplySellPriority(t *testing.T) {
+	cases := []struct {
+		name     string
+		lossCut  []int //あらかじめ損切り中にするロット
+		tagged   bool  //損切りの注文の約定かどうか
+		price    string
+		amount   string
+		wantSold map[int]string
+	}{
+		{"利確価格が約定価格以下のロット", nil, false, "1045", "0.1", map[int]string{2: "0.1"}},
+		{"利確価格が最も高いロット", nil, false, "1060", "0.1", map[int]string{1: "0.1"}},
+		{"該当が無ければ古いロット", nil, false, "1000", "0.15", map[int]string{1: "0.1", 2: "0.05"}},
+		{"利確より後は損切り中のロット", []int{3}, false, "1000", "0.1", map[int]string{3: "0.1"}},
+		{"利確は損切り中のロットより優先", []int{3}, false, "1060", "0.1", map[int]string{1: "0.1"}},
+		{"損切りの約定は損切り中のロットを優先", []int{3}, true, "1060", "0.15", map[int]string{3: "0.1", 1: "0.05"}},
+	}
+	for _, c := range cases {
+		l, _ := Open("")
+		record(t, l, 11, "1000", "1050", "0.1")
+		record(t, l, 12, "990", "1040", "0.1")
+		record(t, l, 13, "980", "1100", "0.1")
+		apply(t, l,
+			trade(1, exchange.Bid, "1000", "0.1"),
+			trade(2, exchange.Bid, "990", "0.1"),
+			trade(3, exchange.Bid, "980", "0.1"))
+		if err := l.MarkLossCut(c.lossCut...); err != nil {
+			t.Fatal(err)
+		}
+		sell := trade(4, exchange.Ask, c.price, c.amount)
+		if c.tagged {
+			sell.Comment = lossCutComment(t)
+		}
+		apply(t, l, sell)
+		for _, lot := range l.Lots("btc_jpy") {
+			want := d("0")
+			if s, ok := c.wantSold[lot.ID]; ok {
+				want = d(s)
+			}
+			if !lot.SoldAmount.Equal(want) {
+				t.Errorf("%s: lot %d sold = %s, want %s", c.name, lot.ID, lot.SoldAmount, want)
+			}
+		}
+	}
+}
+
+//保有を超える売りは按分した手数料とともに割り当てなしとして残すこと
+func TestApplySellUnmatched(t *testing.T) {
+	l, _ := Open("")
+	apply(t, l, trade(1, exchange.Bid, "1000", "0.1"), trade(2, exchange.Bid, "1000", "0.1"))
+	sell := trade(3, exchange.Ask, "1100", "0.25")
+	sell.Fee = d("0.5")
+	apply(t, l, sell)
+	for _, lot := range l.Lots("btc_jpy") {
+		if lot.Status != StatusClosed || !lot.Fees.Equal(d("0.2")) || !lot.RealizedProfit.Equal(d("9.8")) {
+			t.Errorf("lot %d = %+v", lot.ID, lot)
+		}
+	}
+	unmatched := l.Unmatched()
+	if len(unmatched) != 1 || !unmatched[0].Amount.Equal(d("0.05")) || !unmatched[0].Fee.Equal(d("0.1")) || unmatched[0].TradeID != 3 {
+		t.Errorf("unmatched = %+v", unmatched)
+	}
+}
+
+func TestMarkLossCut(t *testing.T) {
+	l, _ := Open("")
+	apply(t, l, trade(1, exchange.Bid, "1000", "0.1"), trade(2, exchange.Bid, "1000", "0.1"))
+	//何度呼び出しても、存在しないロットを指定しても構いません
+	for i := 0; i < 2; i++ {
+		if err := l.MarkLossCut(2, 99); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if lot := lotByID(t, l, "btc_jpy", 1); lot.LossCut {
+		t.Errorf("lot 1 = %+v", lot)
+	}
+	if lot := lotByID(t, l, "btc_jpy", 2); !lot.LossCut {
+		t.Errorf("lot 2 = %+v", lot)
+	}
+	sell := trade(3, exchange.Ask, "900", "0.1")
+	sell.Comment = lossCutComment(t)
+	apply(t, l, sell)
+	s := l.Summary("btc_jpy")
+	if s.LossCutLots != 1 || !s.LossCutProfit.Equal(d("-10")) || s.OpenLots != 1 || s.ClosedLots != 1 {
+		t.Errorf("summary = %+v", s)
+	}
+}
+
+//未約定注文に含まれなくなった買い注文だけを終了すること
+func TestExpireOrders(t *testing.T) {
+	l, _ := Open("")
+	record(t, l, 11, "900", "0", "0.1")
+	record(t, l, 12, "950", "0", "0.1")
+	record(t, l, 13, "1000", "1100", "0.1")
+	record(t, l, 14, "1010", "1050", "0.1")
+	if _, err := l.RecordOrder("eth_jpy", 15, d("50000"), d("0"), d("0.1"), "", start); err != nil {
+		t.Fatal(err)
+	}
+	apply(t, l,
+		trade(1, exchange.Bid, "1000", "0.05"),
+		trade(2, exchange.Bid, "1010", "0.05"),
+		trade(3, exchange.Ask, "1060", "0.05"))
+
+	expired, err := l.ExpireOrders("btc_jpy", map[int]bool{11: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expired != 3 {
+		t.Errorf("expired = %d, want 3", expired)
+	}
+	want := []struct {
+		pair    string
+		id      int
+		status  Status
+		ordered string
+	}{
+		{"btc_jpy", 1, StatusOrdered, "0.1"},
+		{"btc_jpy", 2, StatusCancelled, "0.1"},
+		{"btc_jpy", 3, StatusOpen, "0.05"},
+		{"btc_jpy", 4, StatusClosed, "0.05"},
+		{"eth_jpy", 5, StatusOrdered, "0.1"},
+	}
+	for _, w := range want {
+		lot := lotByID(t, l, w.pair, w.id)
+		if lot.Status != w.status || !lot.OrderedAmount.Equal(d(w.ordered)) {
+			t.Errorf("lot %d: status/ordered = %s/%s, want %s/%s", w.id, lot.Status, lot.OrderedAmount, w.status, w.ordered)
+		}
+	}
+	if expired, _ := l.ExpireOrders("btc_jpy", map[int]bool{}); expired != 1 {
+		t.Errorf("second expire = %d, want 1", expired)
+	}
+}
+
+//反映済みの約定は通貨ペアごとの最後の約定IDで判定すること
+func TestApplyTradesOnce(t *testing.T) {
+	l, _ := Open("")
+	batch := []exchange.Trade{
+		trade(3, exchange.Bid, "1000", "0.1"),
+		trade(1, exchange.Bid, "1000", "0.1"),
+		trade(2, exchange.Ask, "1100", "0.1"),
+	}
+	apply(t, l, batch...)
+	apply(t, l, batch...)
+	apply(t, l, trade(2, exchange.Ask, "1100", "0.1"), trade(4, exchange.Ask, "1100", "0.05"))
+	lots := l.Lots("btc_jpy")
+	//古い順に反映するため、ID2の売りはID1の買いに割り当てられます
+	if len(lots) != 2 || lots[0].Status != StatusClosed || !lots[1].SoldAmount.Equal(d("0.05")) {
+		t.Errorf("lots = %+v", lots)
+	}
+	if id := l.LastTradeID("btc_jpy"); id != 4 {
+		t.Errorf("last trade id = %d, want 4", id)
+	}
+	eth := trade(2, exchange.Bid, "50000", "0.1")
+	eth.CurrencyPair = "eth_jpy"
+	apply(t, l, eth)
+	if lots := l.Lots("eth_jpy"); len(lots) != 1 || l.LastTradeID("eth_jpy") != 2 {
+		t.Errorf("eth lots = %+v", lots)
+	}
+}
+
+func TestPersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ledger")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ledger.json")
+
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//Batchの中の変更は終了時にまとめて保存します
+	err = l.Batch(func() {
+		record(t, l, 11, "1000", "1100", "0.1")
+		record(t, l, 12, "990", "1090", "0.1")
+		if saved, _ := Load(path); len(saved.Lots("btc_jpy")) != 0 {
+			t.Errorf("saved inside batch: %+v", saved.Lots("btc_jpy"))
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	apply(t, l, trade(7, exchange.Bid, "1000", "0.1"))
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lots := reopened.Lots("btc_jpy"); len(lots) != 2 || reopened.LastTradeID("btc_jpy") != 7 {
+		t.Fatalf("reopened lots = %+v, last = %d", lots, reopened.LastTradeID("btc_jpy"))
+	}
+	apply(t, reopened, trade(7, exchange.Bid, "1000", "0.1"))
+	if lot := lotByID(t, reopened, "btc_jpy", 1); !lot.Amount.Equal(d("0.1")) {
+		t.Errorf("reapplied lot = %+v", lot)
+	}
+
+	//最後の約定IDを持たない台帳はロットの約定から求めます
+	legacy := `{"nextId": 2, "lots": [{"id": 1, "currencyPair": "btc_jpy", "status": "open", "amount": 0.1, "fills": [{"tradeId": 5, "action": "bid", "price": 1000, "amount": 0.1}]}], "appliedTrades": {"5": true}}`
+	if err := ioutil.WriteFile(path, []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := old.LastTradeID("btc_jpy"); id != 5 {
+		t.Errorf("legacy last trade id = %d, want 5", id)
+	}
+}
@@ -3,35 +3,206 @@ package main
 import (
 	"fmt"
 	"grid-crypto-real/adapter"
+	"grid-crypto-real/api"
 	"grid-crypto-real/config"
+	"grid-crypto-real/credential"
+	"grid-crypto-real/exchange"
+	"grid-crypto-real/history"
+	"grid-crypto-real/paper"
+	"io"
+	"log"
+	"os"
+	"strings"
 	"time"
 )
 
+//メンテナンス中/リクエスト過多の場合に次の周回まで追加で休む時間です
+const backoffOnUnavailable = 30 * time.Second
+
 func main() {
+	name := "run"
+	args := os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	cmd := findCommand(name)
+	if cmd == nil {
+		usage()
+		os.Exit(2)
+	}
+	if err := cmd.run(args); err != nil {
+		if e, ok := err.(*exitError); ok {
+			log.Println(e)
+			os.Exit(e.code)
+		}
+		log.Fatal(err)
+	}
+}
+
+//取引を開始し、SIGINT/SIGTERMを受けるまで周回を続けます
+func runCommand(args []string) error {
+	return run(args, newStopWatcher())
+}
+
+//ボットを準備して状態を復元し、stopで停止の要求を受けるまで周回を続けます
+func run(args []string, stop *stopWatcher) error {
+	o := &options{}
+	o.parse(o.flagSet("run"), args)
+	bots, store, err := o.setup()
+	if err != nil {
+		return err
+	}
+	for _, bot := range bots {
+		if err := recoverState(o.logs, bot); err != nil {
+			return shutdown(stop, bots, o, err)
+		}
+	}
+
+	watcher := newConfigWatcher(o.logs, o.configPath, o.profile)
 	for {
-		time.Sleep(2 * time.Second) // 休む
-		_, err := adapter.UpdateAllInfo()
+		if stop.wait(config.LoopInterval) { // 休む
+			return shutdown(stop, bots, o, nil)
+		}
+		if watcher.requested() {
+			reloadConfig(o.logs, watcher, bots, store)
+		}
+		for _, bot := range bots {
+			if err := runCycle(o.logs, bot); err != nil {
+				return shutdown(stop, bots, o, err)
+			}
+		}
+	}
+}
+
+//設定ファイルを読み込み、設定を検証します
+func loadConfig(w io.Writer, path string, profile string) error {
+	if path == "" {
+		return config.Validate()
+	}
+	if err := config.Load(path, profile); err != nil {
+		return err
+	}
+	if config.Profile == "" {
+		fmt.Fprintf(w, "設定ファイル%sを読み込みました\n", path)
+	} else {
+		fmt.Fprintf(w, "設定ファイル%sのプロファイル%sを読み込みました\n", path, config.Profile)
+	}
+	return nil
+}
+
+//設定に従って使用する取引所を準備します
+func setupExchange(w io.Writer) (exchange.Exchange, error) {
+	switch config.Exchange {
+	case "zaif":
+		creds, err := credential.ResolveFrom(config.CredentialSource, config.CredentialPath)
 		if err != nil {
-			fmt.Println(err)
-			continue
+			return nil, err
+		}
+		api.SetCredentials(creds)
+		if err := api.SetNonceFile(config.NonceFile); err != nil {
+			return nil, err
+		}
+		return api.NewZaif(), nil
+	case "paper":
+		var source paper.BoardSource = api.NewZaif()
+		if config.PaperBoardFile != "" {
+			recorded, err := paper.LoadRecordedBoards(config.PaperBoardFile)
+			if err != nil {
+				return nil, err
+			}
+			source = recorded
+		}
+		sim := paper.NewExchange(source, exchange.Assets(config.PaperInitialFunds))
+		if config.PaperStateFile != "" {
+			if err := sim.SetStateFile(config.PaperStateFile); err != nil {
+				return nil, err
+			}
 		}
-		fmt.Println("==================================================")
-		adapter.CancelLowestOrderIfOrderFull()
-		adapter.PrintOrderInfo()
-		adapter.PrintDeposit()
+		fmt.Fprintln(w, "ペーパートレードで稼働します")
+		return sim, nil
+	}
+	return nil, fmt.Errorf("未知の取引所です: %s", config.Exchange)
+}
 
-		if config.Debug == 1 {
-			continue
+//設定の取引所から通貨ペアの約定履歴をストアに同期します。ボットを動かさずに履歴だけを使うコマンドで使用します
+func syncHistory(w io.Writer, store *history.Store, pairs []string) error {
+	if err := applyRateLimits(); err != nil {
+		return err
+	}
+	ex, err := setupExchange(w)
+	if err != nil {
+		return err
+	}
+	pager, ok := ex.(exchange.TradeHistoryPager)
+	if !ok {
+		return fmt.Errorf("取引所%sは約定履歴の同期に対応していません", config.Exchange)
+	}
+	for _, pair := range pairs {
+		added, err := store.Sync(pager, pair)
+		if err != nil {
+			return err
 		}
+		fmt.Fprintf(w, "%sの約定履歴を%d件同期しました\n", pair, added)
+	}
+	return nil
+}
 
-		orders := adapter.GetOrderFromLastTradePriceAndConfig()
-		for _, order := range orders {
-			if adapter.HasRangeBuyOrder(order.Price) {
-				fmt.Println("すでに同様の注文/もしくは高い注文があるためスキップします", order.Price)
-			} else {
-				adapter.BuyFromOrder(order)
-			}
+//取引を始める前に取引所の履歴から状態を復元します
+//復旧できない場合と、確認が必要な項目があり設定で停止する場合はエラーを返却します
+func recoverState(w io.Writer, bot *adapter.Bot) error {
+	report, err := bot.Recover()
+	if err != nil {
+		return fmt.Errorf("%sの復旧に失敗しました: %v", bot.Pair().CurrencyPair, err)
+	}
+	report.Print(w)
+	if report.HasIssues() && config.HaltOnRecoveryIssues {
+		return fmt.Errorf("%sの復旧時に確認が必要な項目があるため停止します", bot.Pair().CurrencyPair)
+	}
+	return nil
+}
+
+//通貨ペア1つ分の情報取得と注文を行います
+//続行できないエラーの場合だけエラーを返却し、それ以外は次の周回で再試行します
+func runCycle(w io.Writer, bot *adapter.Bot) error {
+	_, err := bot.UpdateAllInfo()
+	if err != nil {
+		fmt.Fprintln(w, bot.Pair().CurrencyPair, err)
+		switch api.KindOf(err) {
+		case api.ErrAuth:
+			return fmt.Errorf("認証エラーのため停止します: %v", err)
+		case api.ErrMaintenance, api.ErrRateLimited:
+			time.Sleep(backoffOnUnavailable)
 		}
+		return nil
+	}
+	fmt.Fprintln(w, "==================================================")
+	if added, err := bot.SyncHistory(); err != nil {
+		fmt.Fprintln(w, "約定履歴の同期に失敗しました", err)
+	} else if added > 0 {
+		fmt.Fprintf(w, "約定履歴を%d件同期しました\n", added)
+	}
+	bot.CancelLowestOrderIfOrderFull()
+	bot.PrintOrderInfo()
+	bot.PrintDeposit()
+	printAPIStats(w)
 
+	if config.Debug == 1 {
+		return nil
 	}
+
+	regrid(w, bot)
+	bot.Trade()
+	return nil
+}
+
+//API呼び出しキューの状態をログに出力します
+func printAPIStats(w io.Writer) {
+	stats := api.Stats()
+	fmt.Fprintf(w, "APIキュー:%d(cancel:%d order:%d read:%d) 平均待ち:%v 最大待ち:%v\n",
+		stats.QueueDepth,
+		stats.QueueByPriority[api.PriorityCancel],
+		stats.QueueByPriority[api.PriorityOrder],
+		stats.QueueByPriority[api.PriorityRead],
+		stats.AverageWait,
+		stats.MaxWait)
 }
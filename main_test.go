@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"grid-crypto-real/config"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+//ペーパートレードの設定ファイルをdirに作成し、パスを返却します
+func writePaperConfig(t *testing.T, dir string) string {
+	//情報を取得するたびに1件ずつ進み、5円ずつ下がる板です
+	boards := ""
+	for i := 0; i < 10; i++ {
+		bid := 1000000 - 5*i
+		boards += fmt.Sprintf(`{"currencyPair": "btc_jpy", "time": "2026-01-01T00:00:%02dZ", "board": {"asks": [{"price": %d, "amount": 100}], "bids": [{"price": %d, "amount": 100}]}}`+"\n", i, bid+5, bid)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "boards.jsonl"), []byte(boards), 0644); err != nil {
+		t.Fatal(err)
+	}
+	settings := map[string]interface{}{
+		"exchange":            "paper",
+		"loopInterval":        "10ms",
+		"paperInitialFunds":   map[string]int{"jpy": 1000000},
+		"paperBoardFile":      filepath.Join(dir, "boards.jsonl"),
+		"paperStateFile":      filepath.Join(dir, "paper_state.json"),
+		"ledgerFile":          filepath.Join(dir, "ledger.json"),
+		"historyDir":          filepath.Join(dir, "history"),
+		"dryRunFile":          filepath.Join(dir, "dryrun.jsonl"),
+		"nonceFile":           filepath.Join(dir, "nonce.dat"),
+		"pairInfoCacheFile":   filepath.Join(dir, "currency_pairs.json"),
+		"shutdownOrderPolicy": config.ShutdownCancelAll,
+		"pairs": []map[string]interface{}{
+			{"currencyPair": "btc_jpy", "buyRange": 0.001, "takeProfitRange": 0.01, "maxPositionCount": 20, "maxOrderCount": 5},
+		},
+	}
+	body, err := json.Marshal(map[string]interface{}{"base": settings})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(path, body, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+//停止の要求を受けている状態で起動し、準備、復旧、終了処理が通ること
+func TestRunPaper(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grid-crypto-real")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := writePaperConfig(t, dir)
+	stop := &stopWatcher{ch: make(chan os.Signal, 1)}
+	stop.ch <- syscall.SIGTERM
+	if err := run([]string{"-config", path}, stop); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(config.HistoryDir); err != nil {
+		t.Error(err)
+	}
+}
+
+//ペーパートレードで1周回分の情報取得と注文ができ、続行できないエラーでは終了処理を経てそのエラーを返却すること
+func TestRunCyclePaper(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grid-crypto-real")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := writePaperConfig(t, dir)
+	o := &options{}
+	o.parse(o.flagSet("run"), []string{"-config", path})
+	bots, _, err := o.setup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bots) != 1 {
+		t.Fatalf("bots = %d", len(bots))
+	}
+	if err := runCycle(o.logs, bots[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bots[0].UpdateAllInfo(); err != nil {
+		t.Fatal(err)
+	}
+	//最良売気配での買いは即時に約定するため、注文が残っているか基軸通貨が増えていればよいです
+	if s := bots[0].Snapshot(); len(s.ActiveOrders) == 0 && !s.Balance.Deposit["btc"].IsPositive() {
+		t.Errorf("no orders: %+v", s.Balance)
+	}
+	cause := errors.New("認証エラーのため停止します")
+	if err := shutdown(&stopWatcher{ch: make(chan os.Signal, 1)}, bots, o, cause); err != cause {
+		t.Errorf("shutdown = %v", err)
+	}
+}
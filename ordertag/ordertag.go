@@ -0,0 +1,85 @@
+//注文のコメントにグリッドの情報を埋め込みます
+//
+//	fromBot:1:<ボットID>:<グリッドの段>:<意図した価格>:<戦略のバージョン>
+//
+//Zaifは価格を刻みに丸めるため、意図した価格をコメントに残して自分の注文を判断できるようにします
+//区切りの無い"fromBot"だけのコメントは以前のボットが出した注文として扱います
+package ordertag
+
+import (
+	"fmt"
+	"grid-crypto-real/api"
+	"grid-crypto-real/decimal"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+//現在の書式のバージョンです
+const Version = 1
+
+const separator = ":"
+
+//損切りの売り注文に使用するグリッドの段です
+const LossCutLevel = -1
+
+//ボットIDに使用できる文字です
+var botIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,16}$`)
+
+//コメントに埋め込む情報です
+type Tag struct {
+	Version  int             //書式のバージョン(以前のボットの注文は0)
+	BotID    string          //注文したボットのID
+	Level    int             //グリッドの段(0が最初のポジション、損切りはLossCutLevel)
+	Price    decimal.Decimal //意図した注文価格
+	Strategy int             //戦略のバージョン
+}
+
+//コメントの文字列にします
+func Encode(t Tag) (string, error) {
+	if !botIDPattern.MatchString(t.BotID) {
+		return "", fmt.Errorf("ボットIDに使用できない文字が含まれています: %q", t.BotID)
+	}
+	return strings.Join([]string{
+		api.CommentPrefix,
+		strconv.Itoa(Version),
+		t.BotID,
+		strconv.Itoa(t.Level),
+		t.Price.String(),
+		strconv.Itoa(t.Strategy),
+	}, separator), nil
+}
+
+//コメントを解釈します。ボットのコメントでない場合はfalseを返却します
+func Parse(comment string) (Tag, bool) {
+	if comment == api.CommentPrefix {
+		return Tag{}, true
+	}
+	fields := strings.Split(comment, separator)
+	if len(fields) < 2 || fields[0] != api.CommentPrefix {
+		return Tag{}, false
+	}
+	version, err := strconv.Atoi(fields[1])
+	if err != nil || version != Version || len(fields) != 6 {
+		return Tag{}, false
+	}
+	level, errLevel := strconv.Atoi(fields[3])
+	price, errPrice := decimal.NewFromString(fields[4])
+	strategy, errStrategy := strconv.Atoi(fields[5])
+	if errLevel != nil || errPrice != nil || errStrategy != nil {
+		return Tag{}, false
+	}
+	return Tag{Version: version, BotID: fields[2], Level: level, Price: price, Strategy: strategy}, true
+}
+
+//ボットが出した注文のコメントかを返却します
+func IsBot(comment string) bool {
+	_, ok := Parse(comment)
+	return ok
+}
+
+//指定したボットが出した注文のコメントかを返却します。以前のボットの注文は全てのボットのものとみなします
+func IsOwnedBy(comment string, botID string) bool {
+	tag, ok := Parse(comment)
+	return ok && (tag.Version == 0 || tag.BotID == botID)
+}
@@ -0,0 +1,102 @@
+package paper
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"grid-crypto-real/exchange"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+//記録した板の1件です。ファイルには1行に1件ずつJSONで保存します
+type BoardRecord struct {
+	Time         time.Time       `json:"time"`
+	CurrencyPair string          `json:"currencyPair"`
+	Board        *exchange.Board `json:"board"`
+}
+
+//記録済みの板を先頭から順に返却する取得元です
+//通貨ペアごとに独立して進み、最後まで読み終えた場合はio.EOFを返却します
+type RecordedBoards struct {
+	mu      sync.Mutex
+	records map[string][]BoardRecord
+	cursor  map[string]int
+}
+
+//記録ファイルを読み込みます
+func LoadRecordedBoards(path string) (*RecordedBoards, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	r := &RecordedBoards{records: map[string][]BoardRecord{}, cursor: map[string]int{}}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		record := BoardRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("%s:%d 板の記録が不正です: %v", path, line, err)
+		}
+		r.records[record.CurrencyPair] = append(r.records[record.CurrencyPair], record)
+	}
+	return r, scanner.Err()
+}
+
+//次の板を返却します
+func (r *RecordedBoards) GetBoard(currencyPair string) (*exchange.Board, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	i := r.cursor[currencyPair]
+	if i >= len(r.records[currencyPair]) {
+		return nil, io.EOF
+	}
+	r.cursor[currencyPair] = i + 1
+	return copyBoard(r.records[currencyPair][i].Board), nil
+}
+
+//取得した板をファイルに追記しながら返却する取得元です。後でRecordedBoardsで再生できます
+type BoardRecorder struct {
+	mu     sync.Mutex
+	source BoardSource
+	file   *os.File
+}
+
+//sourceから取得した板をpathに追記するようにします
+func NewBoardRecorder(source BoardSource, path string) (*BoardRecorder, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &BoardRecorder{source: source, file: file}, nil
+}
+
+func (r *BoardRecorder) GetBoard(currencyPair string) (*exchange.Board, error) {
+	board, err := r.source.GetBoard(currencyPair)
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(&BoardRecord{Time: time.Now(), CurrencyPair: currencyPair, Board: board})
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := r.file.Write(append(body, '\n')); err != nil {
+		return nil, err
+	}
+	return board, nil
+}
+
+//記録ファイルを閉じます
+func (r *BoardRecorder) Close() error {
+	return r.file.Close()
+}
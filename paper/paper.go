@@ -0,0 +1,453 @@
+package paper
+
+import (
+	"encoding/json"
+	"fmt"
+	"grid-crypto-real/api"
+	"grid-crypto-real/decimal"
+	"grid-crypto-real/exchange"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+//板情報の取得元です。api.Zaifや記録済みの板を使用できます
+type BoardSource interface {
+	GetBoard(currencyPair string) (*exchange.Board, error)
+}
+
+//板情報を元に自分の注文を約定させる仮想取引所です
+//exchange.Exchangeを実装しているため、実際の取引所の代わりにボットを動かすことができます
+//板はGetBoardを呼び出すたびに更新され、そのときに板と交差した注文を約定させます
+type Exchange struct {
+	mu        sync.Mutex
+	source    BoardSource
+	boards    map[string]*exchange.Board
+	state     state
+	statePath string
+	now       func() time.Time
+}
+
+//永続化する状態です
+type state struct {
+	Deposit exchange.Assets  `json:"deposit"`
+	Orders  []*order         `json:"orders"`
+	Trades  []exchange.Trade `json:"trades"` //新しい順
+	NextID  int              `json:"nextId"`
+}
+
+//未約定注文です
+type order struct {
+	exchange.Order
+	Limit decimal.Decimal `json:"limit"`
+}
+
+//初期残高を指定して仮想取引所を作成します
+func NewExchange(source BoardSource, initial exchange.Assets) *Exchange {
+	deposit := exchange.Assets{}
+	for currency, amount := range initial {
+		deposit[currency] = amount
+	}
+	return &Exchange{
+		source: source,
+		boards: map[string]*exchange.Board{},
+		state:  state{Deposit: deposit, NextID: 1},
+		now:    time.Now,
+	}
+}
+
+//状態をファイルに保存するようにします。ファイルが既に存在する場合はその状態から再開します
+func (e *Exchange) SetStateFile(path string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.statePath = path
+	body, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return e.save()
+	}
+	if err != nil {
+		return err
+	}
+	loaded := state{}
+	if err := json.Unmarshal(body, &loaded); err != nil {
+		return fmt.Errorf("ペーパートレードの状態ファイルが不正です: %v", err)
+	}
+	if loaded.Deposit == nil {
+		loaded.Deposit = exchange.Assets{}
+	}
+	e.state = loaded
+	return nil
+}
+
+//時刻の取得元を差し替えます。バックテストで使用します
+func (e *Exchange) SetClock(now func() time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.now = now
+}
+
+//口座残高を返却します。使用可能残高は未約定注文で拘束されている分を除いた額です
+func (e *Exchange) GetBalance() (*exchange.Balance, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	deposit := exchange.Assets{}
+	for currency, amount := range e.state.Deposit {
+		deposit[currency] = amount
+	}
+	return &exchange.Balance{
+		Funds:      e.funds(),
+		Deposit:    deposit,
+		OpenOrders: len(e.state.Orders),
+		ServerTime: e.now(),
+	}, nil
+}
+
+//未約定注文を返却します
+func (e *Exchange) GetActiveOrders(currencyPair string) ([]exchange.Order, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	ret := []exchange.Order{}
+	for _, o := range e.state.Orders {
+		if o.CurrencyPair == currencyPair {
+			ret = append(ret, o.Order)
+		}
+	}
+	return ret, nil
+}
+
+//約定履歴を新しい順に返却します
+func (e *Exchange) GetTradeHistory(currencyPair string) ([]exchange.Trade, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	ret := []exchange.Trade{}
+	for _, t := range e.state.Trades {
+		if t.CurrencyPair == currencyPair {
+			ret = append(ret, t)
+		}
+	}
+	return ret, nil
+}
+
+//条件に一致する約定履歴を返却します
+func (e *Exchange) QueryTradeHistory(currencyPair string, q exchange.TradeQuery) ([]exchange.Trade, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	ret := []exchange.Trade{}
+	for i := range e.state.Trades {
+		t := e.state.Trades[i]
+		if q.Ascending {
+			t = e.state.Trades[len(e.state.Trades)-1-i]
+		}
+		if q.Count > 0 && len(ret) >= q.Count {
+			break
+		}
+		if t.CurrencyPair == currencyPair && q.Match(&t) {
+			ret = append(ret, t)
+		}
+	}
+	return ret, nil
+}
+
+//板を更新し、板と交差した未約定注文を約定させた上で板を返却します
+func (e *Exchange) GetBoard(currencyPair string) (*exchange.Board, error) {
+	board, err := e.source.GetBoard(currencyPair)
+	if err != nil {
+		return nil, err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	//約定で消費した数量を反映するため、返却する板とは別に保持します
+	e.boards[currencyPair] = copyBoard(board)
+	if err := e.matchResting(currencyPair); err != nil {
+		return nil, err
+	}
+	return board, nil
+}
+
+//注文を受け付け、板と交差する分は即座に約定させます
+func (e *Exchange) PlaceOrder(req *exchange.OrderRequest) (*exchange.OrderResult, error) {
+	info, err := api.GetPairInfo(req.CurrencyPair)
+	if err != nil {
+		return nil, err
+	}
+	e.mu.Lock()
+	board := e.boards[req.CurrencyPair]
+	e.mu.Unlock()
+	if board == nil {
+		if board, err = e.GetBoard(req.CurrencyPair); err != nil {
+			return nil, err
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	o := &order{Order: exchange.Order{
+		CurrencyPair: req.CurrencyPair,
+		Action:       req.Action,
+		Price:        info.SnapPrice(req.Price, string(req.Action)),
+		Amount:       info.SnapAmount(req.Amount),
+		Timestamp:    e.now(),
+		Comment:      req.Comment,
+	}}
+	if req.Market {
+		if req.Action != exchange.Ask {
+			return nil, rejected(api.TradeMethod, api.ErrUnknown, "成行買いには対応していません")
+		}
+		o.Price = info.MinPrice
+	}
+	if req.Limit.IsPositive() {
+		o.Limit = info.SnapPrice(req.Limit, string(opposite(req.Action)))
+	}
+	if err := info.Validate(o.Price, o.Amount); err != nil {
+		return nil, err
+	}
+	if err := e.checkFunds(o); err != nil {
+		return nil, err
+	}
+
+	o.ID = e.state.NextID
+	e.state.NextID++
+	received := e.fill(o, e.boards[req.CurrencyPair], false, info)
+	result := &exchange.OrderResult{Received: received, Remains: o.Amount}
+	//成行相当の注文は板に残しません
+	if o.Amount.IsPositive() && !req.Market {
+		e.state.Orders = append(e.state.Orders, o)
+		result.OrderID = o.ID
+	}
+	return result, e.save()
+}
+
+//注文をキャンセルします
+func (e *Exchange) CancelOrder(orderID int) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for i, o := range e.state.Orders {
+		if o.ID == orderID {
+			e.state.Orders = append(e.state.Orders[:i], e.state.Orders[i+1:]...)
+			return e.save()
+		}
+	}
+	return rejected(api.CancelOrderMethod, api.ErrUnknown, fmt.Sprintf("注文%dが見つかりません", orderID))
+}
+
+//板に残っている注文のうち、最新の板と交差したものをメイカーとして約定させます
+func (e *Exchange) matchResting(currencyPair string) error {
+	board := e.boards[currencyPair]
+	info, err := api.GetPairInfo(currencyPair)
+	if err != nil {
+		return err
+	}
+	//買いと売りは板の反対側を使うため分けて並べ、それぞれ有利な価格、同じ価格は先に出した注文から約定させます
+	orders := append([]*order{}, e.state.Orders...)
+	sort.Slice(orders, func(i, j int) bool {
+		a, b := orders[i], orders[j]
+		if a.Action != b.Action {
+			return a.Action == exchange.Bid
+		}
+		if !a.Price.Equal(b.Price) {
+			if a.Action == exchange.Bid {
+				return a.Price.GreaterThan(b.Price)
+			}
+			return a.Price.LessThan(b.Price)
+		}
+		return a.ID < b.ID
+	})
+	changed := false
+	for _, o := range orders {
+		if o.CurrencyPair != currencyPair {
+			continue
+		}
+		if e.fill(o, board, true, info).IsPositive() {
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	remaining := []*order{}
+	for _, o := range e.state.Orders {
+		if o.Amount.IsPositive() {
+			remaining = append(remaining, o)
+		}
+	}
+	e.state.Orders = remaining
+	return e.save()
+}
+
+//注文を板の反対側と突き合わせて約定させ、約定数量を返却します
+//boardの数量は約定した分だけ減らすため、同じ板で同じ流動性を二重に使うことはありません
+//makerがtrueの場合は自分の注文価格、falseの場合は板の価格で約定します
+func (e *Exchange) fill(o *order, board *exchange.Board, maker bool, info *api.PairInfo) decimal.Decimal {
+	if board == nil {
+		return decimal.Zero
+	}
+	levels := board.Asks
+	if o.Action == exchange.Ask {
+		levels = board.Bids
+	}
+	filled := decimal.Zero
+	for i := range levels {
+		if !o.Amount.IsPositive() {
+			break
+		}
+		level := &levels[i]
+		if !level.Amount.IsPositive() {
+			continue
+		}
+		if o.Action == exchange.Bid && level.Price.GreaterThan(o.Price) {
+			break
+		}
+		if o.Action == exchange.Ask && level.Price.LessThan(o.Price) {
+			break
+		}
+		amount := decimal.Min(o.Amount, level.Amount)
+		price := level.Price
+		if maker {
+			price = o.Price
+		}
+		level.Amount = level.Amount.Sub(amount)
+		o.Amount = o.Amount.Sub(amount)
+		filled = filled.Add(amount)
+		e.execute(o, price, amount, maker, info)
+	}
+	return filled
+}
+
+//約定を残高と履歴に反映し、利確価格が指定されている場合は反対売買の注文を出します
+func (e *Exchange) execute(o *order, price decimal.Decimal, amount decimal.Decimal, maker bool, info *api.PairInfo) {
+	base, quote := exchange.SplitPair(o.CurrencyPair)
+	notional := price.Mul(amount)
+	rate := info.TakerFee
+	if maker {
+		rate = info.MakerFee
+	}
+	fee := decimal.Zero
+	bonus := decimal.Zero
+	if rate.IsNegative() {
+		bonus = notional.Mul(rate.Neg())
+	} else {
+		fee = notional.Mul(rate)
+	}
+	if o.Action == exchange.Bid {
+		e.state.Deposit[quote] = e.state.Deposit[quote].Sub(notional).Sub(fee).Add(bonus)
+		e.state.Deposit[base] = e.state.Deposit[base].Add(amount)
+	} else {
+		e.state.Deposit[base] = e.state.Deposit[base].Sub(amount)
+		e.state.Deposit[quote] = e.state.Deposit[quote].Add(notional).Sub(fee).Add(bonus)
+	}
+
+	action := o.Action
+	if maker {
+		action = opposite(o.Action)
+	}
+	trade := exchange.Trade{
+		ID:           e.state.NextID,
+		CurrencyPair: o.CurrencyPair,
+		Action:       action,
+		YourAction:   o.Action,
+		Amount:       amount,
+		Price:        price,
+		Fee:          fee,
+		Bonus:        bonus,
+		Timestamp:    e.now(),
+		Comment:      o.Comment,
+	}
+	e.state.NextID++
+	e.state.Trades = append([]exchange.Trade{trade}, e.state.Trades...)
+
+	//Zaifのlimitと同様に、約定した数量で利確の反対売買注文を出します
+	if o.Limit.IsPositive() {
+		e.state.Orders = append(e.state.Orders, &order{Order: exchange.Order{
+			ID:           e.state.NextID,
+			CurrencyPair: o.CurrencyPair,
+			Action:       opposite(o.Action),
+			Amount:       amount,
+			Price:        o.Limit,
+			Timestamp:    e.now(),
+			Comment:      o.Comment,
+		}})
+		e.state.NextID++
+	}
+}
+
+//注文に必要な残高があるかを確認します
+func (e *Exchange) checkFunds(o *order) error {
+	base, quote := exchange.SplitPair(o.CurrencyPair)
+	funds := e.funds()
+	if o.Action == exchange.Bid {
+		if funds[quote].LessThan(o.Price.Mul(o.Amount)) {
+			return rejected(api.TradeMethod, api.ErrInsufficientFunds, "insufficient funds")
+		}
+		return nil
+	}
+	if funds[base].LessThan(o.Amount) {
+		return rejected(api.TradeMethod, api.ErrInsufficientFunds, "insufficient funds")
+	}
+	return nil
+}
+
+//未約定注文で拘束されている分を除いた残高を返却します
+func (e *Exchange) funds() exchange.Assets {
+	funds := exchange.Assets{}
+	for currency, amount := range e.state.Deposit {
+		funds[currency] = amount
+	}
+	for _, o := range e.state.Orders {
+		base, quote := exchange.SplitPair(o.CurrencyPair)
+		if o.Action == exchange.Bid {
+			funds[quote] = funds[quote].Sub(o.Price.Mul(o.Amount))
+		} else {
+			funds[base] = funds[base].Sub(o.Amount)
+		}
+	}
+	return funds
+}
+
+//状態ファイルが設定されている場合は保存します
+func (e *Exchange) save() error {
+	if e.statePath == "" {
+		return nil
+	}
+	body, err := json.MarshalIndent(&e.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(e.statePath), ".paper")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), e.statePath)
+}
+
+func opposite(action exchange.Action) exchange.Action {
+	if action == exchange.Bid {
+		return exchange.Ask
+	}
+	return exchange.Bid
+}
+
+func copyBoard(board *exchange.Board) *exchange.Board {
+	if board == nil {
+		return nil
+	}
+	return &exchange.Board{
+		Asks: append([]exchange.Level{}, board.Asks...),
+		Bids: append([]exchange.Level{}, board.Bids...),
+	}
+}
+
+//取引所に拒否された場合と同じエラーを返却します
+func rejected(method string, kind api.ErrorKind, message string) error {
+	return &api.APIError{Kind: kind, Method: method, Message: message, Rejected: true}
+}
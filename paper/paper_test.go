@@ -0,0 +1,325 @@
+package paper
+
+import (
+	"grid-crypto-real/api"
+	"grid-crypto-real/decimal"
+	"grid-crypto-real/exchange"
+	"testing"
+	"time"
+)
+
+//常に同じ板を返却する取得元です
+type staticBoard struct {
+	board *exchange.Board
+}
+
+func (s *staticBoard) GetBoard(currencyPair string) (*exchange.Board, error) {
+	return copyBoard(s.board), nil
+}
+
+func d(s string) decimal.Decimal {
+	return decimal.RequireFromString(s)
+}
+
+func levels(pairs ...string) []exchange.Level {
+	ret := []exchange.Level{}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		ret = append(ret, exchange.Level{Price: d(pairs[i]), Amount: d(pairs[i+1])})
+	}
+	return ret
+}
+
+func newTestExchange(board *exchange.Board, funds exchange.Assets) (*Exchange, *staticBoard) {
+	source := &staticBoard{board: board}
+	e := NewExchange(source, funds)
+	at := time.Date(2018, 5, 1, 0, 0, 0, 0, time.UTC)
+	e.SetClock(func() time.Time { return at })
+	return e, source
+}
+
+//約定履歴を古い順に返却します
+func oldestFirst(e *Exchange) []exchange.Trade {
+	ret := []exchange.Trade{}
+	for i := len(e.state.Trades) - 1; i >= 0; i-- {
+		ret = append(ret, e.state.Trades[i])
+	}
+	return ret
+}
+
+func TestFill(t *testing.T) {
+	info, _ := api.GetPairInfo("btc_jpy")
+	cases := []struct {
+		name      string
+		action    exchange.Action
+		price     string
+		amount    string
+		maker     bool
+		board     *exchange.Board
+		wantFills []exchange.Level //約定した価格と数量
+		wantLeft  []exchange.Level //約定後の板の反対側
+	}{
+		{
+			name:      "テイカーの買いは板の価格で安い方から約定",
+			action:    exchange.Bid,
+			price:     "1000",
+			amount:    "0.3",
+			board:     &exchange.Board{Asks: levels("990", "0.1", "995", "0.1", "1005", "1")},
+			wantFills: levels("990", "0.1", "995", "0.1"),
+			wantLeft:  levels("990", "0", "995", "0", "1005", "1"),
+		},
+		{
+			name:      "メイカーの買いは自分の価格で約定",
+			action:    exchange.Bid,
+			price:     "1000",
+			amount:    "0.3",
+			maker:     true,
+			board:     &exchange.Board{Asks: levels("990", "0.1", "995", "0.1", "1005", "1")},
+			wantFills: levels("1000", "0.1", "1000", "0.1"),
+			wantLeft:  levels("990", "0", "995", "0", "1005", "1"),
+		},
+		{
+			name:      "テイカーの売りは板の価格で高い方から約定し、残りは板に残る",
+			action:    exchange.Ask,
+			price:     "1000",
+			amount:    "0.15",
+			board:     &exchange.Board{Bids: levels("1010", "0.1", "1000", "0.1", "995", "1")},
+			wantFills: levels("1010", "0.1", "1000", "0.05"),
+			wantLeft:  levels("1010", "0", "1000", "0.05", "995", "1"),
+		},
+		{
+			name:      "板と交差しない注文は約定しない",
+			action:    exchange.Bid,
+			price:     "985",
+			amount:    "0.1",
+			board:     &exchange.Board{Asks: levels("990", "0.1")},
+			wantFills: levels(),
+			wantLeft:  levels("990", "0.1"),
+		},
+	}
+	for _, c := range cases {
+		e, _ := newTestExchange(nil, exchange.Assets{"jpy": d("1000000"), "btc": d("10")})
+		o := &order{Order: exchange.Order{CurrencyPair: "btc_jpy", Action: c.action, Price: d(c.price), Amount: d(c.amount)}}
+		filled := e.fill(o, c.board, c.maker, info)
+
+		trades := oldestFirst(e)
+		if len(trades) != len(c.wantFills) {
+			t.Errorf("%s: trades = %+v, want %v", c.name, trades, c.wantFills)
+			continue
+		}
+		total := decimal.Zero
+		for i, want := range c.wantFills {
+			if !trades[i].Price.Equal(want.Price) || !trades[i].Amount.Equal(want.Amount) {
+				t.Errorf("%s: trades[%d] = %s@%s, want %s@%s", c.name, i, trades[i].Amount, trades[i].Price, want.Amount, want.Price)
+			}
+			total = total.Add(want.Amount)
+		}
+		if !filled.Equal(total) || !o.Amount.Equal(d(c.amount).Sub(total)) {
+			t.Errorf("%s: filled = %s, remains = %s", c.name, filled, o.Amount)
+		}
+		left := c.board.Asks
+		if c.action == exchange.Ask {
+			left = c.board.Bids
+		}
+		for i, want := range c.wantLeft {
+			if !left[i].Amount.Equal(want.Amount) {
+				t.Errorf("%s: board[%s] = %s, want %s", c.name, want.Price, left[i].Amount, want.Amount)
+			}
+		}
+	}
+}
+
+//同じ板の流動性を複数の注文で二重に使わないこと
+func TestFillConsumesLiquidity(t *testing.T) {
+	info, _ := api.GetPairInfo("btc_jpy")
+	e, _ := newTestExchange(nil, exchange.Assets{"jpy": d("1000000")})
+	board := &exchange.Board{Asks: levels("990", "0.15")}
+	first := &order{Order: exchange.Order{CurrencyPair: "btc_jpy", Action: exchange.Bid, Price: d("1000"), Amount: d("0.1")}}
+	second := &order{Order: exchange.Order{CurrencyPair: "btc_jpy", Action: exchange.Bid, Price: d("1000"), Amount: d("0.1")}}
+	if got := e.fill(first, board, false, info); !got.Equal(d("0.1")) {
+		t.Errorf("first = %s, want 0.1", got)
+	}
+	if got := e.fill(second, board, false, info); !got.Equal(d("0.05")) {
+		t.Errorf("second = %s, want 0.05", got)
+	}
+	if got := e.fill(second, board, false, info); !got.IsZero() {
+		t.Errorf("third = %s, want 0", got)
+	}
+}
+
+//板に残っている注文は買いと売りそれぞれ有利な価格、同じ価格は先に出した注文から約定すること
+func TestMatchRestingOrder(t *testing.T) {
+	e, _ := newTestExchange(nil, exchange.Assets{"jpy": d("1000000"), "btc": d("1")})
+	e.state.Orders = []*order{
+		{Order: exchange.Order{ID: 1, CurrencyPair: "btc_jpy", Action: exchange.Bid, Price: d("1000"), Amount: d("0.1"), Comment: "A"}},
+		{Order: exchange.Order{ID: 4, CurrencyPair: "btc_jpy", Action: exchange.Ask, Price: d("1100"), Amount: d("0.1"), Comment: "D"}},
+		{Order: exchange.Order{ID: 3, CurrencyPair: "btc_jpy", Action: exchange.Bid, Price: d("1005"), Amount: d("0.1"), Comment: "C"}},
+		{Order: exchange.Order{ID: 2, CurrencyPair: "btc_jpy", Action: exchange.Bid, Price: d("1005"), Amount: d("0.1"), Comment: "B"}},
+		{Order: exchange.Order{ID: 5, CurrencyPair: "eth_jpy", Action: exchange.Bid, Price: d("1005"), Amount: d("0.1"), Comment: "E"}},
+	}
+	e.state.NextID = 6
+	e.boards["btc_jpy"] = &exchange.Board{
+		Asks: levels("995", "0.25"),
+		Bids: levels("1100", "0.1"),
+	}
+	if err := e.matchResting("btc_jpy"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		comment string
+		amount  string
+		price   string
+	}{
+		{"B", "0.1", "1005"},
+		{"C", "0.1", "1005"},
+		{"A", "0.05", "1000"},
+		{"D", "0.1", "1100"},
+	}
+	trades := oldestFirst(e)
+	if len(trades) != len(want) {
+		t.Fatalf("trades = %+v", trades)
+	}
+	for i, w := range want {
+		tr := trades[i]
+		if tr.Comment != w.comment || !tr.Amount.Equal(d(w.amount)) || !tr.Price.Equal(d(w.price)) {
+			t.Errorf("trades[%d] = %s %s@%s, want %s %s@%s", i, tr.Comment, tr.Amount, tr.Price, w.comment, w.amount, w.price)
+		}
+	}
+	//全て約定した注文は板から消え、他の通貨ペアの注文はそのまま残ります
+	if len(e.state.Orders) != 2 {
+		t.Fatalf("orders = %d, want 2", len(e.state.Orders))
+	}
+	if o := e.state.Orders[0]; o.Comment != "A" || !o.Amount.Equal(d("0.05")) {
+		t.Errorf("orders[0] = %+v", o.Order)
+	}
+	if o := e.state.Orders[1]; o.Comment != "E" || !o.Amount.Equal(d("0.1")) {
+		t.Errorf("orders[1] = %+v", o.Order)
+	}
+}
+
+//手数料はマイナスの場合にボーナスとして受け取り、買いと売りで残高に逆向きに反映すること
+func TestExecuteFees(t *testing.T) {
+	cases := []struct {
+		pair       string
+		action     exchange.Action
+		maker      bool
+		fee        string
+		bonus      string
+		wantAction exchange.Action //取引所の履歴上の約定方向
+		wantQuote  string
+		wantBase   string
+	}{
+		//btc_jpyはメイカー-0.01%、テイカー0%です
+		{"btc_jpy", exchange.Bid, true, "0", "1", exchange.Ask, "90001", "1.1"},
+		{"btc_jpy", exchange.Ask, true, "0", "1", exchange.Bid, "110001", "0.9"},
+		{"btc_jpy", exchange.Bid, false, "0", "0", exchange.Bid, "90000", "1.1"},
+		//eth_jpyはメイカー0%、テイカー0.1%です
+		{"eth_jpy", exchange.Bid, false, "10", "0", exchange.Bid, "89990", "1.1"},
+		{"eth_jpy", exchange.Ask, false, "10", "0", exchange.Ask, "109990", "0.9"},
+		{"eth_jpy", exchange.Ask, true, "0", "0", exchange.Bid, "110000", "0.9"},
+	}
+	for _, c := range cases {
+		base, quote := exchange.SplitPair(c.pair)
+		info, _ := api.GetPairInfo(c.pair)
+		e, _ := newTestExchange(nil, exchange.Assets{quote: d("100000"), base: d("1")})
+		o := &order{Order: exchange.Order{CurrencyPair: c.pair, Action: c.action, Price: d("100000"), Amount: d("0.1")}}
+		e.execute(o, d("100000"), d("0.1"), c.maker, info)
+
+		name := c.pair + " " + string(c.action)
+		if c.maker {
+			name += " maker"
+		}
+		tr := e.state.Trades[0]
+		if !tr.Fee.Equal(d(c.fee)) || !tr.Bonus.Equal(d(c.bonus)) {
+			t.Errorf("%s: fee/bonus = %s/%s, want %s/%s", name, tr.Fee, tr.Bonus, c.fee, c.bonus)
+		}
+		if tr.Action != c.wantAction || tr.YourAction != c.action {
+			t.Errorf("%s: action/your_action = %s/%s", name, tr.Action, tr.YourAction)
+		}
+		if !e.state.Deposit[quote].Equal(d(c.wantQuote)) || !e.state.Deposit[base].Equal(d(c.wantBase)) {
+			t.Errorf("%s: deposit = %s/%s, want %s/%s", name, e.state.Deposit[quote], e.state.Deposit[base], c.wantQuote, c.wantBase)
+		}
+	}
+}
+
+//未約定注文で拘束されている分を除いた残高で判定すること
+func TestCheckFunds(t *testing.T) {
+	e, _ := newTestExchange(nil, exchange.Assets{"jpy": d("10000"), "btc": d("0.5")})
+	e.state.Orders = []*order{
+		{Order: exchange.Order{ID: 1, CurrencyPair: "btc_jpy", Action: exchange.Bid, Price: d("100000"), Amount: d("0.05")}},
+		{Order: exchange.Order{ID: 2, CurrencyPair: "btc_jpy", Action: exchange.Ask, Price: d("120000"), Amount: d("0.2")}},
+	}
+	cases := []struct {
+		action exchange.Action
+		price  string
+		amount string
+		ok     bool
+	}{
+		{exchange.Bid, "100000", "0.05", true},
+		{exchange.Bid, "100000", "0.0501", false},
+		{exchange.Ask, "120000", "0.3", true},
+		{exchange.Ask, "120000", "0.3001", false},
+	}
+	for _, c := range cases {
+		o := &order{Order: exchange.Order{CurrencyPair: "btc_jpy", Action: c.action, Price: d(c.price), Amount: d(c.amount)}}
+		err := e.checkFunds(o)
+		if c.ok && err != nil {
+			t.Errorf("%s %s@%s: %v", c.action, c.amount, c.price, err)
+		}
+		if !c.ok && api.KindOf(err) != api.ErrInsufficientFunds {
+			t.Errorf("%s %s@%s: err = %v, want insufficient funds", c.action, c.amount, c.price, err)
+		}
+	}
+}
+
+//limitを指定した注文は約定した数量ごとに利確の反対売買注文を出すこと
+func TestLimitCounterOrders(t *testing.T) {
+	e, source := newTestExchange(&exchange.Board{Asks: levels("990", "0.1", "1000", "0.5")}, exchange.Assets{"jpy": d("10000")})
+
+	//即座に約定した分は、約定ごとに刻みに合わせたlimitの価格で売り注文になります
+	result, err := e.PlaceOrder(&exchange.OrderRequest{CurrencyPair: "btc_jpy", Action: exchange.Bid, Price: d("1000"), Amount: d("0.3"), Limit: d("1102")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Received.Equal(d("0.3")) || !result.Remains.IsZero() || result.OrderID != 0 {
+		t.Errorf("result = %+v", result)
+	}
+	counters, _ := e.GetActiveOrders("btc_jpy")
+	if len(counters) != 2 {
+		t.Fatalf("orders = %+v", counters)
+	}
+	for i, want := range []string{"0.1", "0.2"} {
+		if o := counters[i]; o.Action != exchange.Ask || !o.Price.Equal(d("1105")) || !o.Amount.Equal(d(want)) {
+			t.Errorf("counters[%d] = %+v", i, o)
+		}
+	}
+
+	//板に残った注文は、後の板で約定した分だけ反対売買注文を出します
+	result, err = e.PlaceOrder(&exchange.OrderRequest{CurrencyPair: "btc_jpy", Action: exchange.Bid, Price: d("950"), Amount: d("0.2"), Limit: d("1050")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.OrderID == 0 || !result.Received.IsZero() {
+		t.Fatalf("result = %+v", result)
+	}
+	source.board = &exchange.Board{Asks: levels("940", "0.05")}
+	if _, err := e.GetBoard("btc_jpy"); err != nil {
+		t.Fatal(err)
+	}
+	orders, _ := e.GetActiveOrders("btc_jpy")
+	var resting, counter *exchange.Order
+	for i := range orders {
+		switch {
+		case orders[i].ID == result.OrderID:
+			resting = &orders[i]
+		case orders[i].Price.Equal(d("1050")):
+			counter = &orders[i]
+		}
+	}
+	if resting == nil || !resting.Amount.Equal(d("0.15")) {
+		t.Errorf("resting = %+v", resting)
+	}
+	if counter == nil || counter.Action != exchange.Ask || !counter.Amount.Equal(d("0.05")) {
+		t.Errorf("counter = %+v", counter)
+	}
+}
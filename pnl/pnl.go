@@ -0,0 +1,264 @@
+package pnl
+
+import (
+	"fmt"
+	"grid-crypto-real/decimal"
+	"grid-crypto-real/exchange"
+	"grid-crypto-real/ledger"
+	"io"
+	"sort"
+	"time"
+)
+
+//集計の単位です
+type Period string
+
+const (
+	Daily   Period = "daily"
+	Weekly  Period = "weekly"
+	Monthly Period = "monthly"
+)
+
+//期間の開始時刻を返却します。週は月曜始まりです
+func (p Period) Start(t time.Time) time.Time {
+	y, m, d := t.Date()
+	switch p {
+	case Weekly:
+		offset := (int(t.Weekday()) + 6) % 7
+		return time.Date(y, m, d-offset, 0, 0, 0, 0, t.Location())
+	case Monthly:
+		return time.Date(y, m, 1, 0, 0, 0, 0, t.Location())
+	}
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+//次の期間の開始時刻を返却します
+func (p Period) Next(start time.Time) time.Time {
+	switch p {
+	case Weekly:
+		return start.AddDate(0, 0, 7)
+	case Monthly:
+		return start.AddDate(0, 1, 0)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+//期間の名前を解釈します
+func ParsePeriod(s string) (Period, error) {
+	switch p := Period(s); p {
+	case Daily, Weekly, Monthly:
+		return p, nil
+	}
+	return "", fmt.Errorf("未知の集計単位です: %s", s)
+}
+
+//決済まで終わったロット1つ分の損益です
+type RoundTrip struct {
+	LotID     int             `json:"lotId"`
+	OpenedAt  time.Time       `json:"openedAt"`
+	ClosedAt  time.Time       `json:"closedAt"`
+	Amount    decimal.Decimal `json:"amount"`
+	BuyPrice  decimal.Decimal `json:"buyPrice"`
+	SellPrice decimal.Decimal `json:"sellPrice"` //売りの平均約定価格
+	Fees      decimal.Decimal `json:"fees"`
+	Bonus     decimal.Decimal `json:"bonus"`
+	Profit    decimal.Decimal `json:"profit"`
+}
+
+//期間ごとの集計です
+type Bucket struct {
+	Start      time.Time       `json:"start"`
+	Realized   decimal.Decimal `json:"realized"`
+	Fees       decimal.Decimal `json:"fees"`
+	Bonus      decimal.Decimal `json:"bonus"`
+	Buys       int             `json:"buys"`
+	Sells      int             `json:"sells"`
+	RoundTrips int             `json:"roundTrips"`
+	//期間の終わり時点の損益です。含み損益は期間内の最後の約定価格で評価します
+	Unrealized decimal.Decimal `json:"unrealized"`
+	Equity     decimal.Decimal `json:"equity"` //期首からの実現損益と含み損益の合計
+}
+
+//損益の集計結果です。金額は決済通貨建てです
+type Report struct {
+	CurrencyPair string          `json:"currencyPair"`
+	Mark         decimal.Decimal `json:"mark"` //含み損益の評価に使用した価格
+	Realized     decimal.Decimal `json:"realized"`
+	Unrealized   decimal.Decimal `json:"unrealized"`
+	Fees         decimal.Decimal `json:"fees"`
+	Bonus        decimal.Decimal `json:"bonus"`
+	Held         decimal.Decimal `json:"held"`
+	OpenLots     int             `json:"openLots"`
+	RoundTrips   []RoundTrip     `json:"roundTrips"`
+	Buckets      []Bucket        `json:"buckets"`
+}
+
+//約定1件分の損益への影響です
+type event struct {
+	tradeID  int
+	time     time.Time
+	action   exchange.Action
+	price    decimal.Decimal
+	amount   decimal.Decimal //保有数量の増減(買いは手数料を除いて受け取った数量)
+	cost     decimal.Decimal //買いの場合は取得原価、売りの場合は取り崩す取得原価
+	fee      decimal.Decimal
+	bonus    decimal.Decimal
+	realized decimal.Decimal
+	closes   bool //ロットを決済し終えた約定
+}
+
+//台帳のロットから損益を集計します。markは保有中のロットの評価に使用する価格です
+func Compute(currencyPair string, lots []ledger.Lot, mark decimal.Decimal, period Period) *Report {
+	r := &Report{CurrencyPair: currencyPair, Mark: mark}
+	events := []event{}
+	for _, lot := range lots {
+		if lot.CurrencyPair != currencyPair || !lot.Net().IsPositive() {
+			continue
+		}
+		r.Fees = r.Fees.Add(lot.Fees)
+		r.Bonus = r.Bonus.Add(lot.Bonus)
+		r.Realized = r.Realized.Add(lot.RealizedProfit)
+		events = append(events, lotEvents(&lot)...)
+		if lot.Held().IsPositive() {
+			r.OpenLots++
+			r.Held = r.Held.Add(lot.Held())
+			r.Unrealized = r.Unrealized.Add(unrealized(&lot, mark))
+		}
+		if lot.Status == ledger.StatusClosed {
+			r.RoundTrips = append(r.RoundTrips, roundTrip(&lot))
+		}
+	}
+	sort.SliceStable(r.RoundTrips, func(i, j int) bool { return r.RoundTrips[i].ClosedAt.Before(r.RoundTrips[j].ClosedAt) })
+	sort.SliceStable(events, func(i, j int) bool { return events[i].time.Before(events[j].time) })
+	r.Buckets = buckets(events, period)
+	return r
+}
+
+//保有中のロットをmarkで評価した含み損益を返却します
+func Unrealized(lots []ledger.Lot, mark decimal.Decimal) decimal.Decimal {
+	ret := decimal.Zero
+	for i := range lots {
+		if lots[i].Net().IsPositive() && lots[i].Held().IsPositive() {
+			ret = ret.Add(unrealized(&lots[i], mark))
+		}
+	}
+	return ret
+}
+
+func unrealized(lot *ledger.Lot, mark decimal.Decimal) decimal.Decimal {
+	held := lot.Held()
+	return mark.Mul(held).Sub(lot.BuyCost.Mul(held).Div(lot.Net()))
+}
+
+func roundTrip(lot *ledger.Lot) RoundTrip {
+	sellPrice := decimal.Zero
+	if lot.SoldAmount.IsPositive() {
+		sellPrice = lot.SellProceeds.Div(lot.SoldAmount)
+	}
+	return RoundTrip{
+		LotID:     lot.ID,
+		OpenedAt:  lot.OpenedAt,
+		ClosedAt:  lot.ClosedAt,
+		Amount:    lot.Amount,
+		BuyPrice:  lot.BuyPrice(),
+		SellPrice: sellPrice,
+		Fees:      lot.Fees,
+		Bonus:     lot.Bonus,
+		Profit:    lot.RealizedProfit,
+	}
+}
+
+//ロットの約定を時系列の損益の変化に分解します
+func lotEvents(lot *ledger.Lot) []event {
+	ret := []event{}
+	sold := decimal.Zero
+	for _, f := range lot.Fills {
+		e := event{tradeID: f.TradeID, time: f.Time, action: f.Action, price: f.Price, amount: f.Amount, fee: f.Fee, bonus: f.Bonus}
+		if f.Action == exchange.Bid {
+			e.amount = f.Net()
+			e.cost = f.BuyCost()
+		} else {
+			e.cost = lot.BuyCost.Mul(f.Amount).Div(lot.Net())
+			e.realized = f.Price.Mul(f.Amount).Sub(f.Fee).Add(f.Bonus).Sub(e.cost)
+			sold = sold.Add(f.Amount)
+			e.closes = lot.Status == ledger.StatusClosed && sold.GreaterThanOrEqual(lot.Net())
+		}
+		ret = append(ret, e)
+	}
+	return ret
+}
+
+//時系列の約定を期間ごとに集計します。約定の無い期間も含めます
+func buckets(events []event, period Period) []Bucket {
+	if len(events) == 0 {
+		return []Bucket{}
+	}
+	ret := []Bucket{}
+	held := decimal.Zero
+	heldCost := decimal.Zero
+	realized := decimal.Zero
+	last := decimal.Zero
+	i := 0
+	for start := period.Start(events[0].time); i < len(events); start = period.Next(start) {
+		end := period.Next(start)
+		b := Bucket{Start: start}
+		//複数のロットに分けて割り当てた約定は1件として数えます
+		counted := map[int]bool{}
+		for ; i < len(events) && events[i].time.Before(end); i++ {
+			e := events[i]
+			last = e.price
+			b.Fees = b.Fees.Add(e.fee)
+			b.Bonus = b.Bonus.Add(e.bonus)
+			if !counted[e.tradeID] {
+				counted[e.tradeID] = true
+				if e.action == exchange.Bid {
+					b.Buys++
+				} else {
+					b.Sells++
+				}
+			}
+			if e.action == exchange.Bid {
+				held = held.Add(e.amount)
+				heldCost = heldCost.Add(e.cost)
+				continue
+			}
+			b.Realized = b.Realized.Add(e.realized)
+			if e.closes {
+				b.RoundTrips++
+			}
+			held = held.Sub(e.amount)
+			heldCost = heldCost.Sub(e.cost)
+		}
+		realized = realized.Add(b.Realized)
+		b.Unrealized = last.Mul(held).Sub(heldCost)
+		b.Equity = realized.Add(b.Unrealized)
+		ret = append(ret, b)
+	}
+	return ret
+}
+
+//集計結果を出力します
+func (r *Report) Print(w io.Writer, period Period) {
+	fmt.Fprintln(w, "----損益("+r.CurrencyPair+")-----")
+	fmt.Fprintf(w, "実現損益:%.2f 含み損益:%.2f(評価価格:%f)\n", r.Realized, r.Unrealized, r.Mark)
+	fmt.Fprintf(w, "合計:%.2f\n", r.Realized.Add(r.Unrealized))
+	fmt.Fprintf(w, "支払手数料:%.2f 受取ボーナス:%.2f\n", r.Fees, r.Bonus)
+	fmt.Fprintf(w, "往復取引:%d 保有ロット:%d 保有数量:%f\n", len(r.RoundTrips), r.OpenLots, r.Held)
+	fmt.Fprintf(w, "----%s-----\n", period)
+	fmt.Fprintln(w, "期間\t買い\t売り\t往復\t実現損益\t手数料\tボーナス\t含み損益\t累計損益")
+	for _, b := range r.Buckets {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%.2f\t%.2f\t%.2f\t%.2f\t%.2f\n",
+			b.Start.Format("2006-01-02"), b.Buys, b.Sells, b.RoundTrips, b.Realized, b.Fees, b.Bonus, b.Unrealized, b.Equity)
+	}
+	fmt.Fprintln(w, "-------------")
+}
+
+//往復取引の一覧を出力します
+func (r *Report) PrintRoundTrips(w io.Writer) {
+	fmt.Fprintln(w, "ロット\t買い\t売り\t数量\t買値\t売値\t手数料\tボーナス\t損益")
+	for _, t := range r.RoundTrips {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%f\t%f\t%f\t%.2f\t%.2f\t%.2f\n",
+			t.LotID, t.OpenedAt.Format("2006-01-02 15:04"), t.ClosedAt.Format("2006-01-02 15:04"),
+			t.Amount, t.BuyPrice, t.SellPrice, t.Fees, t.Bonus, t.Profit)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"grid-crypto-real/adapter"
+	"grid-crypto-real/config"
+	"grid-crypto-real/history"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+//設定の再読み込みでグリッドの設定が変わり、次の周回で買い注文を見直す通貨ペアです
+var regridPairs = map[string]bool{}
+
+//設定ファイルの再読み込みの要求を監視します
+//SIGHUPを受けた場合と、ファイルの更新時刻が変わった場合に再読み込みします
+type configWatcher struct {
+	path    string
+	profile string
+	modTime time.Time
+	hup     chan os.Signal
+	out     io.Writer
+}
+
+func newConfigWatcher(out io.Writer, path string, profile string) *configWatcher {
+	w := &configWatcher{path: path, profile: profile, hup: make(chan os.Signal, 1), out: out}
+	signal.Notify(w.hup, syscall.SIGHUP)
+	if info, err := os.Stat(path); err == nil {
+		w.modTime = info.ModTime()
+	}
+	return w
+}
+
+//再読み込みが必要かどうかを返却します。待たずに返ります
+func (w *configWatcher) requested() bool {
+	select {
+	case <-w.hup:
+		fmt.Fprintln(w.out, "SIGHUPを受けたため設定を再読み込みします")
+		w.touch()
+		return true
+	default:
+	}
+	if w.path == "" {
+		return false
+	}
+	info, err := os.Stat(w.path)
+	if err != nil || info.ModTime().Equal(w.modTime) {
+		return false
+	}
+	fmt.Fprintln(w.out, "設定ファイルが更新されたため再読み込みします")
+	w.modTime = info.ModTime()
+	return true
+}
+
+func (w *configWatcher) touch() {
+	if info, err := os.Stat(w.path); err == nil {
+		w.modTime = info.ModTime()
+	}
+}
+
+//周回の区切りで設定を再読み込みし、稼働中のボットに反映します
+//検証に失敗した場合は以前の設定のまま続行します
+func reloadConfig(w io.Writer, watcher *configWatcher, bots []*adapter.Bot, store *history.Store) {
+	if watcher.path == "" {
+		fmt.Fprintln(w, "設定ファイルを使用していないため再読み込みできません")
+		return
+	}
+	result, err := config.Reload(watcher.path, watcher.profile)
+	if err != nil {
+		fmt.Fprintln(w, "設定の再読み込みに失敗したため以前の設定で続行します", err)
+		return
+	}
+	if result.Empty() {
+		fmt.Fprintln(w, "設定に変更はありません")
+		return
+	}
+	for _, change := range result.Changes {
+		fmt.Fprintln(w, "設定を変更しました", change)
+	}
+	for _, change := range result.Ignored {
+		fmt.Fprintln(w, "再起動するまで反映しません", change)
+	}
+	for _, bot := range bots {
+		bot.SetHistory(store, config.HistorySyncInterval)
+	}
+	if config.ReloadOrderPolicy == config.ReloadKeepOrders {
+		return
+	}
+	for _, pair := range result.GridChanged {
+		regridPairs[pair] = true
+	}
+}
+
+//グリッドの設定が変わった通貨ペアの買い注文を、設定に従ってキャンセルします
+func regrid(w io.Writer, bot *adapter.Bot) {
+	pair := bot.Pair().CurrencyPair
+	if !regridPairs[pair] {
+		return
+	}
+	delete(regridPairs, pair)
+	var err error
+	switch config.ReloadOrderPolicy {
+	case config.ReloadCancelOffGrid:
+		_, err = bot.CancelOffGridOrders()
+	case config.ReloadRepriceOrders:
+		_, err = bot.CancelGridOrders()
+	}
+	if err != nil {
+		fmt.Fprintln(w, "買い注文の見直しに失敗しました", err)
+	}
+}
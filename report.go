@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"grid-crypto-real/api"
+	"grid-crypto-real/config"
+	"grid-crypto-real/decimal"
+	"grid-crypto-real/history"
+	"grid-crypto-real/ledger"
+	"grid-crypto-real/pnl"
+	"io"
+)
+
+//約定履歴と台帳から損益を集計します
+//
+//	report [-pair btc_jpy] [-period daily|weekly|monthly] [-sync] [-mark 1000000] [-trips]
+//
+//台帳と約定履歴は設定のledgerFileとhistoryDirを使用します。-syncを指定した場合は設定の取引所から同期してから集計します
+//評価価格を省略した場合はZaifの板の最良買気配を使用します
+func reportCommand(args []string) error {
+	o := &options{}
+	fs := o.flagSet("report")
+	periodName := fs.String("period", string(pnl.Daily), "集計単位(daily|weekly|monthly)")
+	syncFirst := fs.Bool("sync", false, "集計前に取引所から約定履歴を同期します")
+	markValue := fs.String("mark", "", "含み損益の評価価格(複数の通貨ペアでは使用できません)")
+	trips := fs.Bool("trips", false, "往復取引の一覧を出力します")
+	o.parse(fs, args)
+
+	period, err := pnl.ParsePeriod(*periodName)
+	if err != nil {
+		return err
+	}
+	if err := loadConfig(o.logs, o.configPath, o.profile); err != nil {
+		return err
+	}
+	pairs, err := o.pairs()
+	if err != nil {
+		return err
+	}
+	if *markValue != "" && len(pairs) > 1 {
+		return fmt.Errorf("-markを指定する場合は-pairで通貨ペアを1つにしてください")
+	}
+	store, err := history.OpenStore(config.HistoryDir)
+	if err != nil {
+		return err
+	}
+	if *syncFirst {
+		if err := syncHistory(o.logs, store, pairs); err != nil {
+			return err
+		}
+	}
+
+	reports := []*pnl.Report{}
+	for _, pair := range pairs {
+		book, err := ledgerWithHistory(config.LedgerFile, store, pair)
+		if err != nil {
+			return err
+		}
+		mark, err := markPrice(o.logs, *markValue, pair, store)
+		if err != nil {
+			return err
+		}
+		report := pnl.Compute(pair, book.Lots(pair), mark, period)
+		reports = append(reports, report)
+		if o.json {
+			continue
+		}
+		report.Print(o.out, period)
+		if *trips {
+			report.PrintRoundTrips(o.out)
+		}
+	}
+	if o.json {
+		return o.printJSON(reports)
+	}
+	return nil
+}
+
+//-pairで指定した通貨ペア、省略した場合は設定の全ての通貨ペアを返却します
+func (o *options) pairs() ([]string, error) {
+	ret := []string{}
+	for _, pc := range config.Pairs {
+		if o.pair == "" || pc.CurrencyPair == o.pair {
+			ret = append(ret, pc.CurrencyPair)
+		}
+	}
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("通貨ペア%sは設定にありません", o.pair)
+	}
+	return ret, nil
+}
+
+//台帳を読み込み、同期済みの約定履歴のうち未反映のものを反映します
+//台帳ファイルが無い場合は約定履歴だけから組み立てます。ファイルは変更しません
+func ledgerWithHistory(path string, store *history.Store, pair string) (*ledger.Ledger, error) {
+	book, err := loadLedger(path)
+	if err != nil {
+		return nil, err
+	}
+	trades, err := store.Query(pair, history.Filter{AfterID: book.LastTradeID(pair)})
+	if err != nil {
+		return nil, err
+	}
+	return book, book.ApplyTrades(trades)
+}
+
+//評価価格を決めます。指定が無い場合は板の最良買気配、取得できない場合は最後の約定価格です
+func markPrice(w io.Writer, value string, pair string, store *history.Store) (decimal.Decimal, error) {
+	if value != "" {
+		return decimal.NewFromString(value)
+	}
+	board, err := api.NewZaif().GetBoard(pair)
+	if err == nil && len(board.Bids) > 0 {
+		return board.Bids[0].Price, nil
+	}
+	fmt.Fprintln(w, "板を取得できないため最後の約定価格で評価します", err)
+	trades, errQuery := store.Query(pair, history.Filter{})
+	if errQuery != nil {
+		return decimal.Zero, errQuery
+	}
+	if len(trades) == 0 {
+		return decimal.Zero, nil
+	}
+	return trades[len(trades)-1].Price, nil
+}
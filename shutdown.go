@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"grid-crypto-real/adapter"
+	"grid-crypto-real/config"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+//終了処理で注文の取り消しに失敗し、注文が残っている可能性がある場合の終了コードです
+const exitCleanupFailed = 3
+
+//終了コードを指定して終了するエラーです
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+//SIGINT/SIGTERMによる停止の要求を監視します
+//取り消しと発注の途中で止まらないように、停止は周回の区切りで行います
+type stopWatcher struct {
+	ch       chan os.Signal
+	received os.Signal
+}
+
+func newStopWatcher() *stopWatcher {
+	w := &stopWatcher{ch: make(chan os.Signal, 1)}
+	signal.Notify(w.ch, syscall.SIGINT, syscall.SIGTERM)
+	return w
+}
+
+//次の周回までd待ちます。停止の要求を受けている場合はtrueを返却します
+func (w *stopWatcher) wait(d time.Duration) bool {
+	select {
+	case w.received = <-w.ch:
+		return true
+	case <-time.After(d):
+		return false
+	}
+}
+
+//終了処理の間に再度シグナルを受けた場合は、終了処理を待たずに終了します
+func (w *stopWatcher) forceOnSecond(out io.Writer) {
+	go func() {
+		sig := <-w.ch
+		fmt.Fprintln(out, sig, "を再度受けたため終了処理を中断します。注文が残っている可能性があります")
+		code := 1
+		if s, ok := sig.(syscall.Signal); ok {
+			code = 128 + int(s)
+		}
+		os.Exit(code)
+	}()
+}
+
+//停止の要求を受けた後か、続行できないエラー(cause)で止まる場合に、設定に従って注文を片付け、約定履歴と記録を保存します
+//注文の取り消しに失敗した通貨ペアがある場合はexitCleanupFailedで終了するエラー、それ以外でcauseがある場合はcauseを返却します
+func shutdown(stop *stopWatcher, bots []*adapter.Bot, o *options, cause error) error {
+	if cause == nil {
+		fmt.Fprintln(o.logs, stop.received, "を受けたため終了します。終了時の注文の扱い:", config.ShutdownOrderPolicy)
+	} else {
+		fmt.Fprintln(o.logs, cause, "終了時の注文の扱い:", config.ShutdownOrderPolicy)
+	}
+	stop.forceOnSecond(o.logs)
+	failed := []string{}
+	for _, bot := range bots {
+		pair := bot.Pair().CurrencyPair
+		if err := cleanupOrders(o.logs, bot); err != nil {
+			fmt.Fprintln(o.logs, pair, "の注文の取り消しに失敗しました", err)
+			failed = append(failed, pair)
+		}
+		if added, err := bot.SyncHistoryNow(); err != nil {
+			fmt.Fprintln(o.logs, pair, "の約定履歴の同期に失敗しました", err)
+		} else if added > 0 {
+			fmt.Fprintf(o.logs, "%sの約定履歴を%d件同期しました\n", pair, added)
+		}
+	}
+	if o.recorder != nil {
+		if err := o.recorder.Close(); err != nil {
+			fmt.Fprintln(o.logs, "ドライランの記録を閉じられませんでした", err)
+		}
+	}
+	if len(failed) > 0 {
+		err := fmt.Errorf("注文が残っている可能性があります: %s", strings.Join(failed, ","))
+		if cause != nil {
+			err = fmt.Errorf("%v。%v", cause, err)
+		}
+		return &exitError{code: exitCleanupFailed, err: err}
+	}
+	if cause != nil {
+		return cause
+	}
+	fmt.Fprintln(o.logs, "終了しました")
+	return nil
+}
+
+//終了時の注文の扱いに従って注文を取り消します
+func cleanupOrders(w io.Writer, bot *adapter.Bot) error {
+	if config.ShutdownOrderPolicy == config.ShutdownKeepOrders {
+		return nil
+	}
+	if config.Debug == 1 {
+		fmt.Fprintln(w, "debugが1のため注文を取り消しません")
+		return nil
+	}
+	//周回の後に約定した注文を取り消そうとしないように、未約定注文を取得し直します
+	if _, err := bot.UpdateAllInfo(); err != nil {
+		return err
+	}
+	var err error
+	switch config.ShutdownOrderPolicy {
+	case config.ShutdownCancelBuys:
+		_, err = bot.CancelAllLongOrder()
+	case config.ShutdownCancelAll:
+		_, err = bot.CancelAllOrder()
+	}
+	return err
+}
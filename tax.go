@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"grid-crypto-real/config"
+	"grid-crypto-real/decimal"
+	"grid-crypto-real/exchange"
+	"grid-crypto-real/history"
+	"grid-crypto-real/tax"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+//同期済みの約定履歴から確定申告用の暗号資産の所得を計算します
+//
+//	tax [-pair btc_jpy] [-method moving|total|both] [-out tax] [-sync]
+//	    [-opening-amount 0.5 -opening-cost 500000]
+//
+//<out>/<通貨ペア>_<計算方法>.csvに年ごとの集計、<out>/<通貨ペア>_<年>_<計算方法>.csvに約定ごとの計算を出力します
+//約定履歴は設定のhistoryDirを使用します。履歴より前から保有していた分は-opening-amountと-opening-costで指定してください
+func taxCommand(args []string) error {
+	o := &options{}
+	fs := o.flagSet("tax")
+	methodName := fs.String("method", "both", "計算方法(moving|total|both)")
+	outDir := fs.String("out", "tax", "CSVの出力先")
+	syncFirst := fs.Bool("sync", false, "計算前に取引所から約定履歴を同期します")
+	openingAmount := fs.String("opening-amount", "0", "履歴より前から保有していた数量")
+	openingCost := fs.String("opening-cost", "0", "履歴より前から保有していた分の取得価額(円)")
+	o.parse(fs, args)
+
+	methods := []tax.Method{tax.Method(*methodName)}
+	if *methodName == "both" {
+		methods = []tax.Method{tax.MovingAverage, tax.TotalAverage}
+	}
+	opening := tax.Opening{}
+	var err error
+	if opening.Amount, err = decimal.NewFromString(*openingAmount); err != nil {
+		return err
+	}
+	if opening.Cost, err = decimal.NewFromString(*openingCost); err != nil {
+		return err
+	}
+	if err := loadConfig(o.logs, o.configPath, o.profile); err != nil {
+		return err
+	}
+	pairs, err := o.taxPairs()
+	if err != nil {
+		return err
+	}
+	if (opening.Amount.IsPositive() || opening.Cost.IsPositive()) && len(pairs) > 1 {
+		return fmt.Errorf("-opening-amountと-opening-costを指定する場合は-pairで通貨ペアを1つにしてください")
+	}
+	store, err := history.OpenStore(config.HistoryDir)
+	if err != nil {
+		return err
+	}
+	if *syncFirst {
+		if err := syncHistory(o.logs, store, pairs); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(*outDir, 0755); err != nil {
+		return err
+	}
+
+	results := []*tax.Result{}
+	for _, pair := range pairs {
+		trades, err := store.Query(pair, history.Filter{})
+		if err != nil {
+			return err
+		}
+		for _, method := range methods {
+			result, err := tax.Compute(pair, trades, method, opening)
+			if err != nil {
+				return err
+			}
+			if err := writeTaxResult(o.logs, *outDir, result); err != nil {
+				return err
+			}
+			results = append(results, result)
+			if !o.json {
+				result.Print(o.out)
+			}
+		}
+	}
+	if o.json {
+		return o.printJSON(results)
+	}
+	return nil
+}
+
+//計算する通貨ペアを返却します。-pairを省略した場合は設定の円建ての通貨ペアです
+func (o *options) taxPairs() ([]string, error) {
+	pairs, err := o.pairs()
+	if err != nil || o.pair != "" {
+		return pairs, err
+	}
+	ret := []string{}
+	for _, pair := range pairs {
+		if _, quote := exchange.SplitPair(pair); quote == "jpy" {
+			ret = append(ret, pair)
+		} else {
+			fmt.Fprintln(o.logs, pair, "は円建てでないため計算しません")
+		}
+	}
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("設定に円建ての通貨ペアがありません")
+	}
+	return ret, nil
+}
+
+//計算結果をCSVに書き出します
+func writeTaxResult(w io.Writer, dir string, result *tax.Result) error {
+	path := filepath.Join(dir, result.CurrencyPair+"_"+string(result.Method)+".csv")
+	if err := writeCSV(w, path, func(f *os.File) error { return tax.WriteYearsCSV(f, result.Years) }); err != nil {
+		return err
+	}
+	for year, entries := range result.Entries {
+		path := filepath.Join(dir, result.CurrencyPair+"_"+strconv.Itoa(year)+"_"+string(result.Method)+".csv")
+		if err := writeCSV(w, path, func(f *os.File) error { return tax.WriteEntriesCSV(f, result.CurrencyPair, entries) }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeCSV(w io.Writer, path string, write func(f *os.File) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	fmt.Fprintln(w, path+"を出力しました")
+	return f.Close()
+}
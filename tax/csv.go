@@ -0,0 +1,102 @@
+package tax
+
+import (
+	"encoding/csv"
+	"fmt"
+	"grid-crypto-real/decimal"
+	"grid-crypto-real/exchange"
+	"io"
+	"strconv"
+)
+
+//年ごとの集計の見出しです
+var yearHeader = []string{
+	"年", "計算方法", "年始数量", "年始取得価額", "購入数量", "購入金額", "売却数量", "売却金額",
+	"平均単価", "売却原価", "売却所得", "ボーナス", "所得金額", "年末数量", "年末取得価額",
+}
+
+//約定ごとの見出しです
+var entryHeader = []string{
+	"日時", "取引内容", "数量", "単価", "約定金額", "手数料", "手数料通貨",
+	"平均単価", "売却原価", "損益", "ボーナス", "保有数量", "簿価",
+}
+
+//年ごとの集計をCSVで出力します。円は1円未満を切り捨てます
+func WriteYearsCSV(w io.Writer, years []*Year) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(yearHeader); err != nil {
+		return err
+	}
+	for _, y := range years {
+		row := []string{
+			strconv.Itoa(y.Year), y.Method.Name(),
+			y.OpeningAmount.String(), yen(y.OpeningCost),
+			y.BoughtAmount.String(), yen(y.BoughtValue),
+			y.SoldAmount.String(), yen(y.SoldValue),
+			y.AverageCost.StringFixed(2), yen(y.CostOfSold),
+			yen(y.Gain), yen(y.Bonus), yen(y.Income()),
+			y.ClosingAmount.String(), yen(y.ClosingCost),
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+//1年分の約定ごとの計算をCSVで出力します
+func WriteEntriesCSV(w io.Writer, currencyPair string, entries []Entry) error {
+	base, quote := exchange.SplitPair(currencyPair)
+	cw := csv.NewWriter(w)
+	if err := cw.Write(entryHeader); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		kind := "購入"
+		if e.Action == exchange.Ask {
+			kind = "売却"
+		}
+		fee, feeCurrency := e.Fee, quote
+		if e.FeeIsBase {
+			fee, feeCurrency = e.FeeAmount, base
+		}
+		row := []string{
+			e.Time.Format("2006/01/02 15:04:05"), kind,
+			e.Amount.String(), e.Price.String(), e.Value.String(),
+			fee.String(), feeCurrency,
+			"", "", "", e.Bonus.String(),
+			e.Holding.String(), yen(e.BookValue),
+		}
+		if e.Action == exchange.Ask {
+			row[7] = e.AvgCost.StringFixed(2)
+			row[8] = yen(e.CostOfSold)
+			row[9] = yen(e.Gain)
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+//年ごとの集計を表示します
+func (r *Result) Print(w io.Writer) {
+	fmt.Fprintf(w, "----%s(%s)-----\n", r.Method.Name(), r.CurrencyPair)
+	for _, y := range r.Years {
+		fmt.Fprintf(w, "%d年 売却金額:%s 売却原価:%s 売却所得:%s ボーナス:%s 所得金額:%s 年末数量:%s 年末取得価額:%s\n",
+			y.Year, yen(y.SoldValue), yen(y.CostOfSold), yen(y.Gain), yen(y.Bonus), yen(y.Income()), y.ClosingAmount, yen(y.ClosingCost))
+	}
+	for _, warning := range r.Warnings {
+		fmt.Fprintln(w, "警告:", warning)
+	}
+}
+
+//円の金額を1円未満切り捨て(0方向)の文字列にします
+func yen(d decimal.Decimal) string {
+	if d.IsNegative() {
+		return d.Neg().FloorTo(decimal.NewFromInt(1)).Neg().StringFixed(0)
+	}
+	return d.FloorTo(decimal.NewFromInt(1)).StringFixed(0)
+}
@@ -0,0 +1,258 @@
+package tax
+
+import (
+	"fmt"
+	"grid-crypto-real/decimal"
+	"grid-crypto-real/exchange"
+	"sort"
+	"time"
+)
+
+//損益の計算方法です
+type Method string
+
+const (
+	MovingAverage Method = "moving" //移動平均法
+	TotalAverage  Method = "total"  //総平均法
+)
+
+//計算方法の日本語名を返却します
+func (m Method) Name() string {
+	if m == TotalAverage {
+		return "総平均法"
+	}
+	return "移動平均法"
+}
+
+//年の区切りに使用する日本時間です
+var JST = time.FixedZone("JST", 9*60*60)
+
+//計算開始時点で保有していた数量と取得価額です
+type Opening struct {
+	Amount decimal.Decimal
+	Cost   decimal.Decimal
+}
+
+//約定1件ごとの計算結果です。金額は円です
+type Entry struct {
+	Time       time.Time
+	Action     exchange.Action
+	Amount     decimal.Decimal //約定数量
+	Price      decimal.Decimal
+	Value      decimal.Decimal //約定金額
+	Fee        decimal.Decimal //手数料(円換算)
+	FeeIsBase  bool            //手数料を暗号資産で支払った場合はtrue
+	FeeAmount  decimal.Decimal //暗号資産で支払った手数料の数量
+	Quantity   decimal.Decimal //保有数量の増減
+	AvgCost    decimal.Decimal //売却時点の平均取得単価
+	CostOfSold decimal.Decimal //売却原価
+	Gain       decimal.Decimal //売却損益
+	Bonus      decimal.Decimal
+	Holding    decimal.Decimal //約定後の保有数量
+	BookValue  decimal.Decimal //約定後の簿価
+}
+
+//1年分の集計です。確定申告の暗号資産の計算書の項目に合わせています
+type Year struct {
+	Year          int             `json:"year"`
+	Method        Method          `json:"method"`
+	OpeningAmount decimal.Decimal `json:"openingAmount"` //年始の数量
+	OpeningCost   decimal.Decimal `json:"openingCost"`   //年始の取得価額
+	BoughtAmount  decimal.Decimal `json:"boughtAmount"`  //購入数量
+	BoughtValue   decimal.Decimal `json:"boughtValue"`   //購入金額(手数料を含む)
+	SoldAmount    decimal.Decimal `json:"soldAmount"`    //売却数量
+	SoldValue     decimal.Decimal `json:"soldValue"`     //売却金額(手数料を除く)
+	AverageCost   decimal.Decimal `json:"averageCost"`   //総平均法の平均単価(移動平均法では年末の平均単価)
+	CostOfSold    decimal.Decimal `json:"costOfSold"`    //売却原価
+	Gain          decimal.Decimal `json:"gain"`          //売却による所得
+	Bonus         decimal.Decimal `json:"bonus"`         //メイカーボーナスによる所得
+	ClosingAmount decimal.Decimal `json:"closingAmount"` //年末の数量
+	ClosingCost   decimal.Decimal `json:"closingCost"`   //年末の取得価額
+}
+
+//所得金額の合計です
+func (y *Year) Income() decimal.Decimal {
+	return y.Gain.Add(y.Bonus)
+}
+
+//計算結果です
+type Result struct {
+	CurrencyPair string          `json:"currencyPair"`
+	Method       Method          `json:"method"`
+	Years        []*Year         `json:"years"`
+	Entries      map[int][]Entry `json:"-"` //年ごとの約定
+	Warnings     []string        `json:"warnings"`
+}
+
+//円建ての通貨ペアの約定履歴(古い順)から年ごとの所得を計算します
+//買いの手数料は、約定にFeeAmountがある場合は暗号資産、無い場合は円で支払ったものとします
+func Compute(currencyPair string, trades []exchange.Trade, method Method, opening Opening) (*Result, error) {
+	if _, quote := exchange.SplitPair(currencyPair); quote != "jpy" {
+		return nil, fmt.Errorf("円建てでない通貨ペアは計算できません: %s", currencyPair)
+	}
+	if method != MovingAverage && method != TotalAverage {
+		return nil, fmt.Errorf("未知の計算方法です: %s", method)
+	}
+	sorted := append([]exchange.Trade{}, trades...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Timestamp.Before(sorted[j].Timestamp) })
+
+	r := &Result{CurrencyPair: currencyPair, Method: method, Entries: map[int][]Entry{}}
+	for _, t := range sorted {
+		if t.CurrencyPair != "" && t.CurrencyPair != currencyPair {
+			continue
+		}
+		year := t.Timestamp.In(JST).Year()
+		r.Entries[year] = append(r.Entries[year], toEntry(t))
+	}
+	years := []int{}
+	for year := range r.Entries {
+		years = append(years, year)
+	}
+	sort.Ints(years)
+
+	amount := opening.Amount
+	cost := opening.Cost
+	for i, year := range years {
+		//取引の無い年も年末残高を引き継ぐために出力します
+		if i > 0 {
+			for gap := years[i-1] + 1; gap < year; gap++ {
+				r.Years = append(r.Years, &Year{Year: gap, Method: method, OpeningAmount: amount, OpeningCost: cost,
+					AverageCost: average(cost, amount), ClosingAmount: amount, ClosingCost: cost})
+			}
+		}
+		y := &Year{Year: year, Method: method, OpeningAmount: amount, OpeningCost: cost}
+		entries := r.Entries[year]
+		if method == TotalAverage {
+			amount, cost = r.totalAverage(y, entries)
+		} else {
+			amount, cost = r.movingAverage(y, entries)
+		}
+		y.ClosingAmount = amount
+		y.ClosingCost = cost
+		r.Years = append(r.Years, y)
+	}
+	return r, nil
+}
+
+//約定を数量と金額の増減に変換します
+func toEntry(t exchange.Trade) Entry {
+	e := Entry{Time: t.Timestamp.In(JST), Action: t.YourAction, Amount: t.Amount, Price: t.Price,
+		Value: t.Price.Mul(t.Amount), Fee: t.Fee, Bonus: t.Bonus}
+	if t.YourAction == exchange.Bid {
+		//Feeは円での評価額のため、暗号資産で支払った場合の数量はFeeAmountで減らします
+		e.FeeIsBase = t.FeeAmount.IsPositive()
+		e.FeeAmount = t.FeeAmount
+		e.Quantity = t.Amount.Sub(t.FeeAmount)
+	} else {
+		e.Quantity = t.Amount.Neg()
+	}
+	return e
+}
+
+//購入にかかった円を返却します
+func (e *Entry) cost() decimal.Decimal {
+	if e.FeeIsBase {
+		return e.Value
+	}
+	return e.Value.Add(e.Fee)
+}
+
+//売却で受け取った円を返却します
+func (e *Entry) proceeds() decimal.Decimal {
+	return e.Value.Sub(e.Fee)
+}
+
+//移動平均法で1年分を計算し、年末の数量と取得価額を返却します
+func (r *Result) movingAverage(y *Year, entries []Entry) (decimal.Decimal, decimal.Decimal) {
+	amount := y.OpeningAmount
+	cost := y.OpeningCost
+	for i := range entries {
+		e := &entries[i]
+		y.Bonus = y.Bonus.Add(e.Bonus)
+		if e.Action == exchange.Bid {
+			y.BoughtAmount = y.BoughtAmount.Add(e.Quantity)
+			y.BoughtValue = y.BoughtValue.Add(e.cost())
+			amount = amount.Add(e.Quantity)
+			cost = cost.Add(e.cost())
+		} else {
+			e.AvgCost = average(cost, amount)
+			e.CostOfSold = r.costOfSold(e, cost, amount)
+			e.Gain = e.proceeds().Sub(e.CostOfSold)
+			y.SoldAmount = y.SoldAmount.Add(e.Amount)
+			y.SoldValue = y.SoldValue.Add(e.proceeds())
+			y.CostOfSold = y.CostOfSold.Add(e.CostOfSold)
+			y.Gain = y.Gain.Add(e.Gain)
+			amount = remaining(amount, e.Amount)
+			cost = cost.Sub(e.CostOfSold)
+		}
+		e.Holding = amount
+		e.BookValue = cost
+	}
+	y.AverageCost = average(cost, amount)
+	return amount, cost
+}
+
+//総平均法で1年分を計算し、年末の数量と取得価額を返却します
+//年始の取得価額と年中の購入金額の合計を数量の合計で割った単価を、その年の全ての売却に使用します
+func (r *Result) totalAverage(y *Year, entries []Entry) (decimal.Decimal, decimal.Decimal) {
+	for i := range entries {
+		e := &entries[i]
+		y.Bonus = y.Bonus.Add(e.Bonus)
+		if e.Action == exchange.Bid {
+			y.BoughtAmount = y.BoughtAmount.Add(e.Quantity)
+			y.BoughtValue = y.BoughtValue.Add(e.cost())
+		} else {
+			y.SoldAmount = y.SoldAmount.Add(e.Amount)
+			y.SoldValue = y.SoldValue.Add(e.proceeds())
+		}
+	}
+	totalAmount := y.OpeningAmount.Add(y.BoughtAmount)
+	totalCost := y.OpeningCost.Add(y.BoughtValue)
+	y.AverageCost = average(totalCost, totalAmount)
+
+	amount := y.OpeningAmount
+	for i := range entries {
+		e := &entries[i]
+		if e.Action == exchange.Bid {
+			amount = amount.Add(e.Quantity)
+		} else {
+			e.AvgCost = y.AverageCost
+			e.CostOfSold = r.costOfSold(e, y.AverageCost.Mul(amount), amount)
+			e.Gain = e.proceeds().Sub(e.CostOfSold)
+			y.CostOfSold = y.CostOfSold.Add(e.CostOfSold)
+			amount = remaining(amount, e.Amount)
+		}
+		e.Holding = amount
+		e.BookValue = y.AverageCost.Mul(amount)
+	}
+	y.Gain = y.SoldValue.Sub(y.CostOfSold)
+	return amount, y.AverageCost.Mul(amount)
+}
+
+//売却原価を返却します。保有数量を超えて売却している場合は超えた分の原価を0として警告します
+func (r *Result) costOfSold(e *Entry, cost decimal.Decimal, amount decimal.Decimal) decimal.Decimal {
+	if e.Amount.GreaterThan(amount) {
+		r.Warnings = append(r.Warnings, fmt.Sprintf("%s 保有数量%sを超えて%sを売却しています。計算開始前の保有分を指定してください",
+			e.Time.Format("2006-01-02 15:04:05"), amount, e.Amount))
+		if !amount.IsPositive() {
+			return decimal.Zero
+		}
+		return cost
+	}
+	return average(cost, amount).Mul(e.Amount)
+}
+
+//売却後の保有数量を返却します。保有数量を超えた売却では0です
+func remaining(amount decimal.Decimal, sold decimal.Decimal) decimal.Decimal {
+	if sold.GreaterThan(amount) {
+		return decimal.Zero
+	}
+	return amount.Sub(sold)
+}
+
+func average(cost decimal.Decimal, amount decimal.Decimal) decimal.Decimal {
+	if !amount.IsPositive() {
+		return decimal.Zero
+	}
+	return cost.Div(amount)
+}
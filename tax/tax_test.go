@@ -0,0 +1,147 @@
+package tax
+
+import (
+	"grid-crypto-real/decimal"
+	"grid-crypto-real/exchange"
+	"testing"
+	"time"
+)
+
+func d(s string) decimal.Decimal {
+	return decimal.RequireFromString(s)
+}
+
+//年始に1BTCを取得価額100万円で保有していた場合の約定です
+//
+//	2017-12-31 23:00 JST 1BTCを119万9000円で購入、手数料1000円
+//	2018-01-01 00:30 JST 1BTCを150万円で売却、手数料150円(UTCではまだ2017年)
+//	2018-03-01 09:00 JST 0.5005BTCを100万円で購入、手数料0.0005BTC(500円相当)
+//	2018-06-01 09:00 JST 0.5BTCを140万円で売却、ボーナス70円
+func fixture() []exchange.Trade {
+	trade := func(id int, at string, action exchange.Action, price string, amount string) exchange.Trade {
+		ts, err := time.Parse(time.RFC3339, at)
+		if err != nil {
+			panic(err)
+		}
+		return exchange.Trade{ID: id, CurrencyPair: "btc_jpy", YourAction: action, Price: d(price), Amount: d(amount), Timestamp: ts}
+	}
+	trades := []exchange.Trade{
+		trade(1, "2017-12-31T14:00:00Z", exchange.Bid, "1199000", "1"),
+		trade(2, "2017-12-31T15:30:00Z", exchange.Ask, "1500000", "1"),
+		trade(3, "2018-03-01T00:00:00Z", exchange.Bid, "1000000", "0.5005"),
+		trade(4, "2018-06-01T00:00:00Z", exchange.Ask, "1400000", "0.5"),
+	}
+	trades[0].Fee = d("1000")
+	trades[1].Fee = d("150")
+	trades[2].Fee = d("500")
+	trades[2].FeeAmount = d("0.0005")
+	trades[3].Bonus = d("70")
+	//取引所と同じく新しい順で渡します
+	return []exchange.Trade{trades[3], trades[2], trades[1], trades[0]}
+}
+
+func TestCompute(t *testing.T) {
+	type year struct {
+		year                                 int
+		openingAmount, openingCost           string
+		boughtAmount, boughtValue            string
+		soldAmount, soldValue                string
+		averageCost, costOfSold, gain, bonus string
+		closingAmount, closingCost, income   string
+	}
+	cases := []struct {
+		method Method
+		years  []year
+		gains  []string //2018年の売却ごとの所得
+	}{
+		{
+			//2017年末: 2BTC 220万円(平均110万円)
+			//2018年: 1BTCを原価110万円で売却し、0.5BTCを50万500円で購入して1.5BTC 160万500円(平均106万7000円)
+			//       0.5BTCを原価53万3500円で売却
+			method: MovingAverage,
+			years: []year{
+				{2017, "1", "1000000", "1", "1200000", "0", "0", "1100000", "0", "0", "0", "2", "2200000", "0"},
+				{2018, "2", "2200000", "0.5", "500500", "1.5", "2199850", "1067000", "1633500", "566350", "70", "1", "1067000", "566420"},
+			},
+			gains: []string{"399850", "166500"},
+		},
+		{
+			//2018年の平均単価は(220万円+50万500円)/(2BTC+0.5BTC)=108万200円です
+			method: TotalAverage,
+			years: []year{
+				{2017, "1", "1000000", "1", "1200000", "0", "0", "1100000", "0", "0", "0", "2", "2200000", "0"},
+				{2018, "2", "2200000", "0.5", "500500", "1.5", "2199850", "1080200", "1620300", "579550", "70", "1", "1080200", "579620"},
+			},
+			gains: []string{"419650", "159900"},
+		},
+	}
+	for _, c := range cases {
+		r, err := Compute("btc_jpy", fixture(), c.method, Opening{Amount: d("1"), Cost: d("1000000")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(r.Warnings) != 0 {
+			t.Errorf("%s: warnings = %v", c.method, r.Warnings)
+		}
+		if len(r.Years) != len(c.years) {
+			t.Fatalf("%s: years = %d", c.method, len(r.Years))
+		}
+		for i, w := range c.years {
+			y := r.Years[i]
+			got := []decimal.Decimal{y.OpeningAmount, y.OpeningCost, y.BoughtAmount, y.BoughtValue, y.SoldAmount, y.SoldValue,
+				y.AverageCost, y.CostOfSold, y.Gain, y.Bonus, y.ClosingAmount, y.ClosingCost, y.Income()}
+			want := []string{w.openingAmount, w.openingCost, w.boughtAmount, w.boughtValue, w.soldAmount, w.soldValue,
+				w.averageCost, w.costOfSold, w.gain, w.bonus, w.closingAmount, w.closingCost, w.income}
+			if y.Year != w.year {
+				t.Errorf("%s: years[%d] = %d, want %d", c.method, i, y.Year, w.year)
+			}
+			for j := range want {
+				if !got[j].Equal(d(want[j])) {
+					t.Errorf("%s %d: got %v, want %v", c.method, w.year, got, want)
+					break
+				}
+			}
+		}
+
+		//日本時間の年で分けるため、UTCで2017年の売却は2018年に入ります
+		if len(r.Entries[2017]) != 1 || len(r.Entries[2018]) != 3 {
+			t.Fatalf("%s: entries = %d/%d", c.method, len(r.Entries[2017]), len(r.Entries[2018]))
+		}
+		gains := []string{}
+		for _, e := range r.Entries[2018] {
+			if e.Action == exchange.Ask {
+				gains = append(gains, e.Gain.String())
+			}
+		}
+		if len(gains) != 2 || gains[0] != c.gains[0] || gains[1] != c.gains[1] {
+			t.Errorf("%s: gains = %v, want %v", c.method, gains, c.gains)
+		}
+		//手数料を暗号資産で支払った買いは受け取った数量だけ増えます
+		if e := r.Entries[2018][1]; !e.FeeIsBase || !e.Quantity.Equal(d("0.5")) {
+			t.Errorf("%s: base fee entry = %+v", c.method, e)
+		}
+		if e := r.Entries[2017][0]; e.FeeIsBase || !e.Quantity.Equal(d("1")) {
+			t.Errorf("%s: quote fee entry = %+v", c.method, e)
+		}
+	}
+}
+
+//保有数量を超える売却は超えた分の原価を0として警告すること
+func TestComputeOversold(t *testing.T) {
+	//年末の購入が無いと年始の売却は保有数量を超えます
+	trades := fixture()[:3]
+	r, err := Compute("btc_jpy", trades, MovingAverage, Opening{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Warnings) != 1 {
+		t.Errorf("warnings = %v", r.Warnings)
+	}
+	//1BTCの売却は原価0、残りは0.5BTCを50万500円で購入した分です
+	if y := r.Years[0]; !y.CostOfSold.Equal(d("500500")) || !y.Gain.Equal(d("1699350")) || !y.ClosingAmount.IsZero() {
+		t.Errorf("year = %+v", y)
+	}
+	if _, err := Compute("eth_btc", trades, MovingAverage, Opening{}); err == nil {
+		t.Error("eth_btc: expected error")
+	}
+}